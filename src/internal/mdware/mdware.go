@@ -132,6 +132,12 @@ func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return w.w.(http.Hijacker).Hijack()
 }
 
+func (w *responseWriter) Flush() {
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (w *responseWriter) Code() int {
 	return int(atomic.LoadUint64(&w.c))
 }
@@ -292,12 +298,18 @@ func Exec(v interface{}) func(http.Handler) http.Handler {
 	}
 }
 
+// Written is result of API functions, which write response themselves, e.g.
+// streams of server-sent events, Resp skips it.
+var Written interface{} = written{}
+
+type written struct{}
+
 // Resp writes result data to response.
 func Resp(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		err := ctxutil.ErrorFrom(ctx)
-		if err != nil || ctxutil.SizeFrom(ctx) != 0 { // skip if response exists
+		if err != nil || ctxutil.SizeFrom(ctx) != 0 || ctxutil.ResultFrom(ctx) == Written { // skip if response exists
 			h.ServeHTTP(w, r)
 			return
 		}
@@ -0,0 +1,1092 @@
+package redismem
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+type (
+	hashValue map[string]string
+	setValue  map[string]struct{}
+	zsetValue map[string]float64
+)
+
+// command is executed under store lock, args do not include command name.
+type command func(*Store, []string) interface{}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":     cmdPing,
+		"ECHO":     cmdEcho,
+		"AUTH":     cmdOK,
+		"SELECT":   cmdOK,
+		"FLUSHDB":  cmdFlush,
+		"FLUSHALL": cmdFlush,
+		"DBSIZE":   cmdDBSize,
+
+		"DEL":    cmdDel,
+		"EXISTS": cmdExists,
+		"TYPE":   cmdType,
+		"KEYS":   cmdKeys,
+		"SCAN":   cmdScan,
+		"RENAME": cmdRename,
+
+		"DUMP":    cmdDump,
+		"RESTORE": cmdRestore,
+
+		"GET":    cmdGet,
+		"MGET":   cmdMGet,
+		"SET":    cmdSet,
+		"INCR":   cmdIncr,
+		"INCRBY": cmdIncrBy,
+
+		"HSET":    cmdHSet,
+		"HMSET":   cmdHMSet,
+		"HINCRBY": cmdHIncrBy,
+		"HGET":    cmdHGet,
+		"HMGET":   cmdHMGet,
+		"HGETALL": cmdHGetAll,
+		"HDEL":    cmdHDel,
+		"HEXISTS": cmdHExists,
+		"HLEN":    cmdHLen,
+
+		"LPUSH":  cmdLPush,
+		"RPUSH":  cmdRPush,
+		"LLEN":   cmdLLen,
+		"LRANGE": cmdLRange,
+		"LTRIM":  cmdLTrim,
+
+		"SADD":      cmdSAdd,
+		"SREM":      cmdSRem,
+		"SMEMBERS":  cmdSMembers,
+		"SISMEMBER": cmdSIsMember,
+		"SCARD":     cmdSCard,
+
+		"ZADD":             cmdZAdd,
+		"ZREM":             cmdZRem,
+		"ZINCRBY":          cmdZIncrBy,
+		"ZSCORE":           cmdZScore,
+		"ZCARD":            cmdZCard,
+		"ZRANGE":           cmdZRange,
+		"ZREVRANGE":        cmdZRevRange,
+		"ZRANGEBYSCORE":    cmdZRangeByScore,
+		"ZREVRANGEBYSCORE": cmdZRevRangeByScore,
+		"ZREMRANGEBYSCORE": cmdZRemRangeByScore,
+		"ZREMRANGEBYRANK":  cmdZRemRangeByRank,
+		"ZSCAN":            cmdZScan,
+
+		"XADD":      cmdXAdd,
+		"XLEN":      cmdXLen,
+		"XRANGE":    cmdXRange,
+		"XREVRANGE": cmdXRevRange,
+		"XREAD":     cmdXRead,
+
+		"EVAL":    cmdEval,
+		"EVALSHA": cmdEvalSHA,
+		"SCRIPT":  cmdScript,
+	}
+}
+
+func errArgs(name string) redis.Error {
+	return redis.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+}
+
+var (
+	errType   = redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errInt    = redis.Error("ERR value is not an integer or out of range")
+	errFloat  = redis.Error("ERR value is not a valid float")
+	errSyntax = redis.Error("ERR syntax error")
+	errNoKey  = redis.Error("ERR no such key")
+)
+
+func cmdOK(_ *Store, _ []string) interface{} {
+	return "OK"
+}
+
+func cmdPing(_ *Store, a []string) interface{} {
+	if len(a) > 0 {
+		return []byte(a[0])
+	}
+	return "PONG"
+}
+
+func cmdEcho(_ *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("echo")
+	}
+	return []byte(a[0])
+}
+
+func cmdFlush(s *Store, _ []string) interface{} {
+	for k := range s.keys {
+		s.touch(k)
+	}
+	s.keys = make(map[string]interface{})
+	return "OK"
+}
+
+func cmdDBSize(s *Store, _ []string) interface{} {
+	return int64(len(s.keys))
+}
+
+func cmdDel(s *Store, a []string) interface{} {
+	if len(a) == 0 {
+		return errArgs("del")
+	}
+	var n int64
+	for i := range a {
+		if _, ok := s.keys[a[i]]; ok {
+			delete(s.keys, a[i])
+			s.touch(a[i])
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(s *Store, a []string) interface{} {
+	if len(a) == 0 {
+		return errArgs("exists")
+	}
+	var n int64
+	for i := range a {
+		if _, ok := s.keys[a[i]]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdType(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("type")
+	}
+	switch s.keys[a[0]].(type) {
+	case string:
+		return "string"
+	case hashValue:
+		return "hash"
+	case setValue:
+		return "set"
+	case zsetValue:
+		return "zset"
+	case *listValue:
+		return "list"
+	case *streamValue:
+		return "stream"
+	}
+	return "none"
+}
+
+func cmdKeys(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("keys")
+	}
+	return bulks(s.match(a[0]))
+}
+
+func cmdScan(s *Store, a []string) interface{} {
+	if len(a) == 0 {
+		return errArgs("scan")
+	}
+	pattern := "*"
+	for i := 1; i+1 < len(a); i += 2 {
+		if strings.EqualFold(a[i], "MATCH") {
+			pattern = a[i+1]
+		}
+	}
+	// the whole keyspace is returned in one iteration, COUNT is only a hint
+	return []interface{}{[]byte("0"), bulks(s.match(pattern))}
+}
+
+func cmdRename(s *Store, a []string) interface{} {
+	if len(a) != 2 {
+		return errArgs("rename")
+	}
+	v, ok := s.keys[a[0]]
+	if !ok {
+		return errNoKey
+	}
+	delete(s.keys, a[0])
+	s.touch(a[0])
+	s.keys[a[1]] = v
+	s.touch(a[1])
+	return "OK"
+}
+
+func (s *Store) match(pattern string) []string {
+	res := make([]string, 0, len(s.keys))
+	for k := range s.keys {
+		if globMatch(pattern, k) {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// STRING
+
+func cmdGet(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("get")
+	}
+	switch v := s.keys[a[0]].(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(v)
+	}
+	return errType
+}
+
+func cmdMGet(s *Store, a []string) interface{} {
+	if len(a) == 0 {
+		return errArgs("mget")
+	}
+	res := make([]interface{}, len(a))
+	for i := range a {
+		if v, ok := s.keys[a[i]].(string); ok {
+			res[i] = []byte(v)
+		}
+	}
+	return res
+}
+
+func cmdSet(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("set")
+	}
+	var nx, xx bool
+	for _, v := range a[2:] {
+		switch strings.ToUpper(v) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		}
+	}
+	_, ok := s.keys[a[0]]
+	if (nx && ok) || (xx && !ok) {
+		return nil
+	}
+	s.keys[a[0]] = a[1]
+	s.touch(a[0])
+	return "OK"
+}
+
+func cmdIncr(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("incr")
+	}
+	return s.incrBy(a[0], 1)
+}
+
+func cmdIncrBy(s *Store, a []string) interface{} {
+	if len(a) != 2 {
+		return errArgs("incrby")
+	}
+	d, err := strconv.ParseInt(a[1], 10, 64)
+	if err != nil {
+		return errInt
+	}
+	return s.incrBy(a[0], d)
+}
+
+func (s *Store) incrBy(key string, d int64) interface{} {
+	var n int64
+	switch v := s.keys[key].(type) {
+	case nil:
+	case string:
+		var err error
+		n, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errInt
+		}
+	default:
+		return errType
+	}
+	n += d
+	s.keys[key] = strconv.FormatInt(n, 10)
+	s.touch(key)
+	return n
+}
+
+// HASH
+
+func (s *Store) hash(key string, create bool) (hashValue, redis.Error) {
+	switch v := s.keys[key].(type) {
+	case nil:
+		if !create {
+			return nil, ""
+		}
+		h := make(hashValue)
+		s.keys[key] = h
+		return h, ""
+	case hashValue:
+		return v, ""
+	}
+	return nil, errType
+}
+
+func cmdHSet(s *Store, a []string) interface{} {
+	if len(a) < 3 || len(a)%2 != 1 {
+		return errArgs("hset")
+	}
+	h, err := s.hash(a[0], true)
+	if err != "" {
+		return err
+	}
+	var n int64
+	for i := 1; i < len(a); i += 2 {
+		if _, ok := h[a[i]]; !ok {
+			n++
+		}
+		h[a[i]] = a[i+1]
+	}
+	s.touch(a[0])
+	return n
+}
+
+func cmdHMSet(s *Store, a []string) interface{} {
+	if len(a) < 3 || len(a)%2 != 1 {
+		return errArgs("hmset")
+	}
+	r := cmdHSet(s, a)
+	if _, ok := r.(int64); ok {
+		return "OK"
+	}
+	return r
+}
+
+func cmdHIncrBy(s *Store, a []string) interface{} {
+	if len(a) != 3 {
+		return errArgs("hincrby")
+	}
+	d, e := strconv.ParseInt(a[2], 10, 64)
+	if e != nil {
+		return errInt
+	}
+	h, err := s.hash(a[0], true)
+	if err != "" {
+		return err
+	}
+	var n int64
+	if v, ok := h[a[1]]; ok {
+		n, e = strconv.ParseInt(v, 10, 64)
+		if e != nil {
+			return errInt
+		}
+	}
+	n += d
+	h[a[1]] = strconv.FormatInt(n, 10)
+	s.touch(a[0])
+	return n
+}
+
+func cmdHGet(s *Store, a []string) interface{} {
+	if len(a) != 2 {
+		return errArgs("hget")
+	}
+	h, err := s.hash(a[0], false)
+	if err != "" {
+		return err
+	}
+	if v, ok := h[a[1]]; ok {
+		return []byte(v)
+	}
+	return nil
+}
+
+func cmdHMGet(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("hmget")
+	}
+	h, err := s.hash(a[0], false)
+	if err != "" {
+		return err
+	}
+	res := make([]interface{}, len(a)-1)
+	for i := range res {
+		if v, ok := h[a[i+1]]; ok {
+			res[i] = []byte(v)
+		}
+	}
+	return res
+}
+
+func cmdHGetAll(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("hgetall")
+	}
+	h, err := s.hash(a[0], false)
+	if err != "" {
+		return err
+	}
+	f := make([]string, 0, len(h))
+	for k := range h {
+		f = append(f, k)
+	}
+	sort.Strings(f)
+	res := make([]interface{}, 0, len(h)*2)
+	for i := range f {
+		res = append(res, []byte(f[i]), []byte(h[f[i]]))
+	}
+	return res
+}
+
+func cmdHDel(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("hdel")
+	}
+	h, err := s.hash(a[0], false)
+	if err != "" {
+		return err
+	}
+	var n int64
+	for _, f := range a[1:] {
+		if _, ok := h[f]; ok {
+			delete(h, f)
+			n++
+		}
+	}
+	if n > 0 {
+		s.cleanup(a[0], len(h))
+	}
+	return n
+}
+
+func cmdHExists(s *Store, a []string) interface{} {
+	if len(a) != 2 {
+		return errArgs("hexists")
+	}
+	h, err := s.hash(a[0], false)
+	if err != "" {
+		return err
+	}
+	if _, ok := h[a[1]]; ok {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func cmdHLen(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("hlen")
+	}
+	h, err := s.hash(a[0], false)
+	if err != "" {
+		return err
+	}
+	return int64(len(h))
+}
+
+// cleanup removes empty collection like Redis does and marks key as modified.
+func (s *Store) cleanup(key string, n int) {
+	if n == 0 {
+		delete(s.keys, key)
+	}
+	s.touch(key)
+}
+
+// SET
+
+func (s *Store) set(key string, create bool) (setValue, redis.Error) {
+	switch v := s.keys[key].(type) {
+	case nil:
+		if !create {
+			return nil, ""
+		}
+		m := make(setValue)
+		s.keys[key] = m
+		return m, ""
+	case setValue:
+		return v, ""
+	}
+	return nil, errType
+}
+
+func cmdSAdd(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("sadd")
+	}
+	m, err := s.set(a[0], true)
+	if err != "" {
+		return err
+	}
+	var n int64
+	for _, v := range a[1:] {
+		if _, ok := m[v]; !ok {
+			m[v] = struct{}{}
+			n++
+		}
+	}
+	s.touch(a[0])
+	return n
+}
+
+func cmdSRem(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("srem")
+	}
+	m, err := s.set(a[0], false)
+	if err != "" {
+		return err
+	}
+	var n int64
+	for _, v := range a[1:] {
+		if _, ok := m[v]; ok {
+			delete(m, v)
+			n++
+		}
+	}
+	if n > 0 {
+		s.cleanup(a[0], len(m))
+	}
+	return n
+}
+
+func cmdSMembers(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("smembers")
+	}
+	m, err := s.set(a[0], false)
+	if err != "" {
+		return err
+	}
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return bulks(res)
+}
+
+func cmdSIsMember(s *Store, a []string) interface{} {
+	if len(a) != 2 {
+		return errArgs("sismember")
+	}
+	m, err := s.set(a[0], false)
+	if err != "" {
+		return err
+	}
+	if _, ok := m[a[1]]; ok {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func cmdSCard(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("scard")
+	}
+	m, err := s.set(a[0], false)
+	if err != "" {
+		return err
+	}
+	return int64(len(m))
+}
+
+// SORTED SET
+
+func (s *Store) zset(key string, create bool) (zsetValue, redis.Error) {
+	switch v := s.keys[key].(type) {
+	case nil:
+		if !create {
+			return nil, ""
+		}
+		z := make(zsetValue)
+		s.keys[key] = z
+		return z, ""
+	case zsetValue:
+		return v, ""
+	}
+	return nil, errType
+}
+
+type zmember struct {
+	name  string
+	score float64
+}
+
+// sorted returns members ordered by score and then lexicographically.
+func (z zsetValue) sorted() []zmember {
+	res := make([]zmember, 0, len(z))
+	for k, v := range z {
+		res = append(res, zmember{k, v})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].score != res[j].score {
+			return res[i].score < res[j].score
+		}
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+func cmdZAdd(s *Store, a []string) interface{} {
+	if len(a) < 3 {
+		return errArgs("zadd")
+	}
+	var nx, xx, ch bool
+	i := 1
+loop:
+	for ; i < len(a); i++ {
+		switch strings.ToUpper(a[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+			ch = true
+		default:
+			break loop
+		}
+	}
+	if len(a[i:]) == 0 || len(a[i:])%2 != 0 {
+		return errSyntax
+	}
+
+	scores := make([]float64, 0, len(a[i:])/2)
+	for j := i; j < len(a); j += 2 {
+		f, err := parseScore(a[j])
+		if err != nil {
+			return errFloat
+		}
+		scores = append(scores, f)
+	}
+
+	z, err := s.zset(a[0], true)
+	if err != "" {
+		return err
+	}
+	var added, changed int64
+	for j := i; j < len(a); j += 2 {
+		f := scores[(j-i)/2]
+		v, ok := z[a[j+1]]
+		if (nx && ok) || (xx && !ok) {
+			continue
+		}
+		if !ok {
+			added++
+		} else if v != f {
+			changed++
+		}
+		z[a[j+1]] = f
+	}
+	s.cleanup(a[0], len(z))
+	if ch {
+		return added + changed
+	}
+	return added
+}
+
+func cmdZRem(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("zrem")
+	}
+	z, err := s.zset(a[0], false)
+	if err != "" {
+		return err
+	}
+	var n int64
+	for _, v := range a[1:] {
+		if _, ok := z[v]; ok {
+			delete(z, v)
+			n++
+		}
+	}
+	if n > 0 {
+		s.cleanup(a[0], len(z))
+	}
+	return n
+}
+
+func cmdZIncrBy(s *Store, a []string) interface{} {
+	if len(a) != 3 {
+		return errArgs("zincrby")
+	}
+	d, e := parseScore(a[1])
+	if e != nil {
+		return errFloat
+	}
+	z, err := s.zset(a[0], true)
+	if err != "" {
+		return err
+	}
+	z[a[2]] += d
+	s.touch(a[0])
+	return []byte(formatScore(z[a[2]]))
+}
+
+func cmdZScore(s *Store, a []string) interface{} {
+	if len(a) != 2 {
+		return errArgs("zscore")
+	}
+	z, err := s.zset(a[0], false)
+	if err != "" {
+		return err
+	}
+	if v, ok := z[a[1]]; ok {
+		return []byte(formatScore(v))
+	}
+	return nil
+}
+
+func cmdZCard(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("zcard")
+	}
+	z, err := s.zset(a[0], false)
+	if err != "" {
+		return err
+	}
+	return int64(len(z))
+}
+
+func cmdZRange(s *Store, a []string) interface{} {
+	if len(a) < 3 {
+		return errArgs("zrange")
+	}
+	return zrange(s, a, false)
+}
+
+func cmdZRevRange(s *Store, a []string) interface{} {
+	if len(a) < 3 {
+		return errArgs("zrevrange")
+	}
+	return zrange(s, a, true)
+}
+
+func zrange(s *Store, a []string, rev bool) interface{} {
+	start, err1 := strconv.Atoi(a[1])
+	stop, err2 := strconv.Atoi(a[2])
+	if err1 != nil || err2 != nil {
+		return errInt
+	}
+	withScores := len(a) > 3 && strings.EqualFold(a[3], "WITHSCORES")
+	z, err := s.zset(a[0], false)
+	if err != "" {
+		return err
+	}
+
+	m := z.sorted()
+	if rev {
+		for i, j := 0, len(m)-1; i < j; i, j = i+1, j-1 {
+			m[i], m[j] = m[j], m[i]
+		}
+	}
+	i, j := listRange(start, stop, len(m))
+	return zreply(m[i:j], withScores)
+}
+
+func cmdZRangeByScore(s *Store, a []string) interface{} {
+	if len(a) < 3 {
+		return errArgs("zrangebyscore")
+	}
+	return zrangeByScore(s, a[0], a[1], a[2], a[3:], false)
+}
+
+func cmdZRevRangeByScore(s *Store, a []string) interface{} {
+	if len(a) < 3 {
+		return errArgs("zrevrangebyscore")
+	}
+	return zrangeByScore(s, a[0], a[2], a[1], a[3:], true)
+}
+
+func zrangeByScore(s *Store, key, from, to string, a []string, rev bool) interface{} {
+	min, err1 := parseBound(from)
+	max, err2 := parseBound(to)
+	if err1 != nil || err2 != nil {
+		return redis.Error("ERR min or max is not a float")
+	}
+
+	var withScores bool
+	offset, count := 0, -1
+	for i := 0; i < len(a); i++ {
+		switch strings.ToUpper(a[i]) {
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(a) {
+				return errSyntax
+			}
+			var e1, e2 error
+			offset, e1 = strconv.Atoi(a[i+1])
+			count, e2 = strconv.Atoi(a[i+2])
+			if e1 != nil || e2 != nil {
+				return errInt
+			}
+			i += 2
+		default:
+			return errSyntax
+		}
+	}
+
+	z, err := s.zset(key, false)
+	if err != "" {
+		return err
+	}
+
+	res := make([]zmember, 0, len(z))
+	for _, m := range z.sorted() {
+		if min.less(m.score) && max.greater(m.score) {
+			res = append(res, m)
+		}
+	}
+	if rev {
+		for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+			res[i], res[j] = res[j], res[i]
+		}
+	}
+	if offset >= len(res) {
+		res = res[:0]
+	} else {
+		res = res[offset:]
+	}
+	if count >= 0 && count < len(res) {
+		res = res[:count]
+	}
+	return zreply(res, withScores)
+}
+
+func cmdZRemRangeByScore(s *Store, a []string) interface{} {
+	if len(a) != 3 {
+		return errArgs("zremrangebyscore")
+	}
+	min, err1 := parseBound(a[1])
+	max, err2 := parseBound(a[2])
+	if err1 != nil || err2 != nil {
+		return redis.Error("ERR min or max is not a float")
+	}
+	z, err := s.zset(a[0], false)
+	if err != "" {
+		return err
+	}
+	var n int64
+	for k, v := range z {
+		if min.less(v) && max.greater(v) {
+			delete(z, k)
+			n++
+		}
+	}
+	if n > 0 {
+		s.cleanup(a[0], len(z))
+	}
+	return n
+}
+
+func cmdZRemRangeByRank(s *Store, a []string) interface{} {
+	if len(a) != 3 {
+		return errArgs("zremrangebyrank")
+	}
+	start, err1 := strconv.Atoi(a[1])
+	stop, err2 := strconv.Atoi(a[2])
+	if err1 != nil || err2 != nil {
+		return errInt
+	}
+	z, err := s.zset(a[0], false)
+	if err != "" {
+		return err
+	}
+	m := z.sorted()
+	i, j := listRange(start, stop, len(m))
+	if i == j {
+		return int64(0)
+	}
+	for _, x := range m[i:j] {
+		delete(z, x.name)
+	}
+	s.cleanup(a[0], len(z))
+	return int64(j - i)
+}
+
+func cmdZScan(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("zscan")
+	}
+	pattern := "*"
+	for i := 2; i+1 < len(a); i += 2 {
+		if strings.EqualFold(a[i], "MATCH") {
+			pattern = a[i+1]
+		}
+	}
+	z, err := s.zset(a[0], false)
+	if err != "" {
+		return err
+	}
+	res := make([]zmember, 0, len(z))
+	for _, m := range z.sorted() {
+		if globMatch(pattern, m.name) {
+			res = append(res, m)
+		}
+	}
+	return []interface{}{[]byte("0"), zreply(res, true)}
+}
+
+func zreply(m []zmember, withScores bool) []interface{} {
+	n := len(m)
+	if withScores {
+		n *= 2
+	}
+	res := make([]interface{}, 0, n)
+	for i := range m {
+		res = append(res, []byte(m[i].name))
+		if withScores {
+			res = append(res, []byte(formatScore(m[i].score)))
+		}
+	}
+	return res
+}
+
+type bound struct {
+	v    float64
+	excl bool
+}
+
+func (b bound) less(f float64) bool {
+	if b.excl {
+		return b.v < f
+	}
+	return b.v <= f
+}
+
+func (b bound) greater(f float64) bool {
+	if b.excl {
+		return b.v > f
+	}
+	return b.v >= f
+}
+
+func parseBound(s string) (bound, error) {
+	var b bound
+	if strings.HasPrefix(s, "(") {
+		b.excl = true
+		s = s[1:]
+	}
+	var err error
+	b.v, err = parseScore(s)
+	return b, err
+}
+
+func parseScore(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "-inf":
+		return math.Inf(-1), nil
+	case "+inf", "inf":
+		return math.Inf(1), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == math.Trunc(f) && math.Abs(f) < 1e17:
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func bulks(v []string) []interface{} {
+	res := make([]interface{}, len(v))
+	for i := range v {
+		res[i] = []byte(v[i])
+	}
+	return res
+}
+
+// globMatch reports whether s matches Redis glob-style pattern.
+func globMatch(pattern, s string) bool {
+	return matchRunes([]rune(pattern), []rune(s))
+}
+
+func matchRunes(p, s []rune) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 1 && p[1] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchRunes(p[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := 1
+			for end < len(p) && p[end] != ']' {
+				if p[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(p) {
+				return false // malformed class
+			}
+			if !matchClass(p[1:end], s[0]) {
+				return false
+			}
+			p = p[end:]
+		case '\\':
+			if len(p) > 1 {
+				p = p[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || p[0] != s[0] {
+				return false
+			}
+		}
+		p = p[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+func matchClass(c []rune, r rune) bool {
+	not := len(c) > 0 && c[0] == '^'
+	if not {
+		c = c[1:]
+	}
+	var ok bool
+	for i := 0; i < len(c); i++ {
+		if c[i] == '\\' && i+1 < len(c) {
+			i++
+			ok = ok || c[i] == r
+			continue
+		}
+		if i+2 < len(c) && c[i+1] == '-' {
+			lo, hi := c[i], c[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			ok = ok || (r >= lo && r <= hi)
+			i += 2
+			continue
+		}
+		ok = ok || c[i] == r
+	}
+	return ok != not
+}
@@ -0,0 +1,142 @@
+package redismem
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// dumpValue is serialization format of DUMP, it is not compatible with Redis.
+type dumpValue struct {
+	String *string            `json:"string,omitempty"`
+	Hash   map[string]string  `json:"hash,omitempty"`
+	Set    []string           `json:"set,omitempty"`
+	Zset   map[string]float64 `json:"zset,omitempty"`
+	List   []string           `json:"list,omitempty"`
+	Stream []dumpStreamEntry  `json:"stream,omitempty"`
+	Last   string             `json:"last,omitempty"`
+}
+
+type dumpStreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// dumpKey returns serialization of value, false if the type is unknown.
+func dumpKey(v interface{}) (dumpValue, bool) {
+	var d dumpValue
+	switch x := v.(type) {
+	case string:
+		d.String = &x
+	case hashValue:
+		d.Hash = x
+	case setValue:
+		d.Set = make([]string, 0, len(x))
+		for k := range x {
+			d.Set = append(d.Set, k)
+		}
+	case zsetValue:
+		d.Zset = x
+	case *listValue:
+		d.List = x.items
+	case *streamValue:
+		d.Stream = make([]dumpStreamEntry, len(x.entries))
+		for i := range x.entries {
+			d.Stream[i] = dumpStreamEntry{x.entries[i].id.String(), x.entries[i].fields}
+		}
+		d.Last = x.last.String()
+	default:
+		return d, false
+	}
+	return d, true
+}
+
+// restoreKey returns value of serialization, false if it is empty.
+func restoreKey(d dumpValue) (interface{}, bool) {
+	switch {
+	case d.String != nil:
+		return *d.String, true
+	case d.Hash != nil:
+		h := make(hashValue, len(d.Hash))
+		for k, v := range d.Hash {
+			h[k] = v
+		}
+		return h, true
+	case d.Set != nil:
+		v := make(setValue, len(d.Set))
+		for i := range d.Set {
+			v[d.Set[i]] = struct{}{}
+		}
+		return v, true
+	case d.Zset != nil:
+		z := make(zsetValue, len(d.Zset))
+		for k, v := range d.Zset {
+			z[k] = v
+		}
+		return z, true
+	case d.List != nil:
+		return &listValue{append([]string(nil), d.List...)}, true
+	case d.Stream != nil:
+		x := &streamValue{entries: make([]streamEntry, 0, len(d.Stream))}
+		for i := range d.Stream {
+			id, ok := parseStreamID(d.Stream[i].ID, 0)
+			if !ok {
+				return nil, false
+			}
+			x.entries = append(x.entries, streamEntry{id, append([]string(nil), d.Stream[i].Fields...)})
+		}
+		x.last, _ = parseStreamID(d.Last, 0)
+		return x, true
+	}
+	return nil, false
+}
+
+func cmdDump(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("dump")
+	}
+
+	d, ok := dumpKey(s.keys[a[0]])
+	if !ok {
+		return nil
+	}
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return redis.Error("ERR " + err.Error())
+	}
+	return b
+}
+
+func cmdRestore(s *Store, a []string) interface{} {
+	if len(a) < 3 {
+		return errArgs("restore")
+	}
+
+	replace := false
+	for i := 3; i < len(a); i++ {
+		if !strings.EqualFold(a[i], "REPLACE") {
+			return errSyntax
+		}
+		replace = true
+	}
+	if _, ok := s.keys[a[0]]; ok && !replace {
+		return redis.Error("BUSYKEY Target key name already exists.")
+	}
+
+	var d dumpValue
+	err := json.Unmarshal([]byte(a[2]), &d)
+	if err != nil {
+		return redis.Error("ERR DUMP payload version or checksum are wrong")
+	}
+
+	v, ok := restoreKey(d)
+	if !ok {
+		return redis.Error("ERR DUMP payload version or checksum are wrong")
+	}
+	s.keys[a[0]] = v
+	s.touch(a[0])
+
+	return "OK"
+}
@@ -0,0 +1,73 @@
+package redismem
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Open returns store with keys loaded from file, the keys are saved back
+// by Close, so separate runs share the keyspace. Missing file is empty.
+func Open(path string) (*Store, error) {
+	s := New()
+	s.path = path
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var v map[string]dumpValue
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		return nil, fmt.Errorf("redismem: %s: %v", path, err)
+	}
+
+	for k := range v {
+		x, ok := restoreKey(v[k])
+		if !ok {
+			return nil, fmt.Errorf("redismem: %s: invalid key %q", path, k)
+		}
+		s.keys[k] = x
+	}
+
+	return s, nil
+}
+
+// save writes keys to file, the file is replaced at once.
+func (s *Store) save(path string) error {
+	s.mu.Lock()
+	v := make(map[string]dumpValue, len(s.keys))
+	for k := range s.keys {
+		if d, ok := dumpKey(s.keys[k]); ok {
+			v[k] = d
+		}
+	}
+	b, err := json.Marshal(v)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,134 @@
+package redismem
+
+import (
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+type listValue struct {
+	items []string
+}
+
+func (s *Store) list(key string, create bool) (*listValue, redis.Error) {
+	switch v := s.keys[key].(type) {
+	case nil:
+		if !create {
+			return nil, ""
+		}
+		x := &listValue{}
+		s.keys[key] = x
+		return x, ""
+	case *listValue:
+		return v, ""
+	}
+	return nil, errType
+}
+
+// listRange converts Redis start and stop indexes of lists and ranks of
+// sorted sets to slice bounds.
+func listRange(start, stop, n int) (int, int) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+func cmdLPush(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("lpush")
+	}
+	l, err := s.list(a[0], true)
+	if err != "" {
+		return err
+	}
+	for _, v := range a[1:] {
+		l.items = append([]string{v}, l.items...)
+	}
+	s.touch(a[0])
+	return int64(len(l.items))
+}
+
+func cmdRPush(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("rpush")
+	}
+	l, err := s.list(a[0], true)
+	if err != "" {
+		return err
+	}
+	l.items = append(l.items, a[1:]...)
+	s.touch(a[0])
+	return int64(len(l.items))
+}
+
+func cmdLLen(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("llen")
+	}
+	l, err := s.list(a[0], false)
+	if err != "" {
+		return err
+	}
+	if l == nil {
+		return int64(0)
+	}
+	return int64(len(l.items))
+}
+
+func cmdLRange(s *Store, a []string) interface{} {
+	if len(a) != 3 {
+		return errArgs("lrange")
+	}
+	start, err1 := strconv.Atoi(a[1])
+	stop, err2 := strconv.Atoi(a[2])
+	if err1 != nil || err2 != nil {
+		return errInt
+	}
+	l, err := s.list(a[0], false)
+	if err != "" {
+		return err
+	}
+	if l == nil {
+		return []interface{}{}
+	}
+	i, j := listRange(start, stop, len(l.items))
+	return bulks(l.items[i:j])
+}
+
+func cmdLTrim(s *Store, a []string) interface{} {
+	if len(a) != 3 {
+		return errArgs("ltrim")
+	}
+	start, err1 := strconv.Atoi(a[1])
+	stop, err2 := strconv.Atoi(a[2])
+	if err1 != nil || err2 != nil {
+		return errInt
+	}
+	l, err := s.list(a[0], false)
+	if err != "" {
+		return err
+	}
+	if l == nil {
+		return "OK"
+	}
+	i, j := listRange(start, stop, len(l.items))
+	l.items = append([]string(nil), l.items[i:j]...)
+	if len(l.items) == 0 {
+		delete(s.keys, a[0])
+	}
+	s.touch(a[0])
+	return "OK"
+}
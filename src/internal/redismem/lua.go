@@ -0,0 +1,795 @@
+package redismem
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Scripts of EVAL are run by a small interpreter of the Lua subset used by
+// the application: local variables and assignments, if statements, return,
+// calls of redis.call, redis.pcall, tonumber and tostring, KEYS and ARGV,
+// arithmetic, comparisons, concatenation and the length of tables. Scripts
+// out of the subset are refused when they are loaded, so tests fail instead
+// of running something else than Redis would.
+
+type luaTable []interface{}
+
+// luaStatus and luaError are tables with ok and err fields of Lua, they are
+// made of status and error replies.
+type (
+	luaStatus string
+	luaError  string
+)
+
+type luaToken struct {
+	kind byte // 'n' name, '0' number, 's' string, 'o' operator, 0 end
+	text string
+	num  float64
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "else": true, "elseif": true, "end": true, "false": true,
+	"if": true, "local": true, "nil": true, "not": true, "or": true,
+	"return": true, "then": true, "true": true,
+}
+
+func luaTokens(src string) ([]luaToken, error) {
+	var res []luaToken
+	for i := 0; i < len(src); {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case ch == '_' || isLetter(ch):
+			j := i
+			for j < len(src) && (src[j] == '_' || isLetter(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			res = append(res, luaToken{kind: 'n', text: src[i:j]})
+			i = j
+		case isDigit(ch):
+			j := i
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.' || src[j] == 'e' || src[j] == 'E' ||
+				((src[j] == '-' || src[j] == '+') && (src[j-1] == 'e' || src[j-1] == 'E'))) {
+				j++
+			}
+			f, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed number %q", src[i:j])
+			}
+			res = append(res, luaToken{kind: '0', text: src[i:j], num: f})
+			i = j
+		case ch == '\'' || ch == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != ch; j++ {
+				if src[j] == '\n' {
+					return nil, fmt.Errorf("unfinished string")
+				}
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					case 'r':
+						b.WriteByte('\r')
+					default:
+						b.WriteByte(src[j])
+					}
+					continue
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unfinished string")
+			}
+			res = append(res, luaToken{kind: 's', text: b.String()})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range []string{"==", "~=", "<=", ">=", "..", "+", "-", "*", "/", "%", "<", ">", "=", "(", ")", "[", "]", ",", ".", ";", "#"} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected symbol %q", ch)
+			}
+			res = append(res, luaToken{kind: 'o', text: op})
+			i += len(op)
+		}
+	}
+	return append(res, luaToken{}), nil
+}
+
+func isLetter(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+type (
+	luaStmt interface{}
+	luaExpr interface{}
+
+	luaLocal struct {
+		name string
+		x    luaExpr
+	}
+	luaAssign struct {
+		name string
+		x    luaExpr
+	}
+	luaReturn struct {
+		x luaExpr
+	}
+	luaIf struct {
+		cond  []luaExpr
+		block [][]luaStmt
+		other []luaStmt
+	}
+
+	luaConst struct {
+		v interface{}
+	}
+	luaName struct {
+		name string
+	}
+	luaIndex struct {
+		x, key luaExpr
+	}
+	luaCall struct {
+		fn   string
+		args []luaExpr
+	}
+	luaUnary struct {
+		op string
+		x  luaExpr
+	}
+	luaBinary struct {
+		op   string
+		l, r luaExpr
+	}
+)
+
+var luaFuncs = map[string]bool{
+	"redis.call":  true,
+	"redis.pcall": true,
+	"tonumber":    true,
+	"tostring":    true,
+}
+
+type luaParser struct {
+	t []luaToken
+	i int
+}
+
+// parseLua parses the script, see the subset above.
+func parseLua(src string) ([]luaStmt, error) {
+	t, err := luaTokens(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &luaParser{t: t}
+	var res []luaStmt
+	err = p.try(func() {
+		res = p.block()
+		if p.peek().kind != 0 {
+			p.fail("unexpected %q", p.peek().text)
+		}
+	})
+	return res, err
+}
+
+type luaSyntaxError string
+
+func (p *luaParser) fail(format string, args ...interface{}) {
+	panic(luaSyntaxError(fmt.Sprintf(format, args...)))
+}
+
+func (p *luaParser) try(fn func()) (err error) {
+	defer func() {
+		switch e := recover().(type) {
+		case nil:
+		case luaSyntaxError:
+			err = fmt.Errorf("%s", string(e))
+		default:
+			panic(e)
+		}
+	}()
+	fn()
+	return nil
+}
+
+func (p *luaParser) peek() luaToken {
+	return p.t[p.i]
+}
+
+func (p *luaParser) next() luaToken {
+	t := p.t[p.i]
+	if t.kind != 0 {
+		p.i++
+	}
+	return t
+}
+
+func (p *luaParser) is(kind byte, text string) bool {
+	t := p.peek()
+	return t.kind == kind && t.text == text
+}
+
+func (p *luaParser) accept(kind byte, text string) bool {
+	if p.is(kind, text) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *luaParser) expect(kind byte, text string) {
+	if !p.accept(kind, text) {
+		p.fail("%q expected near %q", text, p.peek().text)
+	}
+}
+
+func (p *luaParser) name() string {
+	t := p.next()
+	if t.kind != 'n' || luaKeywords[t.text] {
+		p.fail("name expected near %q", t.text)
+	}
+	return t.text
+}
+
+// block parses statements until end, else, elseif or the end of script.
+func (p *luaParser) block() []luaStmt {
+	var res []luaStmt
+	for {
+		t := p.peek()
+		if t.kind == 0 || t.kind == 'n' && (t.text == "end" || t.text == "else" || t.text == "elseif") {
+			return res
+		}
+		if p.accept('o', ";") {
+			continue
+		}
+		res = append(res, p.stmt())
+		if _, ok := res[len(res)-1].(luaReturn); ok {
+			p.accept('o', ";")
+			return res
+		}
+	}
+}
+
+func (p *luaParser) stmt() luaStmt {
+	switch {
+	case p.accept('n', "local"):
+		s := luaLocal{name: p.name()}
+		if p.accept('o', "=") {
+			s.x = p.expr(0)
+		}
+		return s
+	case p.accept('n', "return"):
+		t := p.peek()
+		if t.kind == 0 || t.kind == 'n' && (t.text == "end" || t.text == "else" || t.text == "elseif") || p.is('o', ";") {
+			return luaReturn{}
+		}
+		return luaReturn{x: p.expr(0)}
+	case p.accept('n', "if"):
+		var s luaIf
+		for {
+			s.cond = append(s.cond, p.expr(0))
+			p.expect('n', "then")
+			s.block = append(s.block, p.block())
+			if !p.accept('n', "elseif") {
+				break
+			}
+		}
+		if p.accept('n', "else") {
+			s.other = p.block()
+		}
+		p.expect('n', "end")
+		return s
+	}
+
+	x := p.primary()
+	if c, ok := x.(luaCall); ok {
+		return c
+	}
+	n, ok := x.(luaName)
+	if !ok {
+		p.fail("syntax error near %q", p.peek().text)
+	}
+	p.expect('o', "=")
+	return luaAssign{name: n.name, x: p.expr(0)}
+}
+
+var luaPriority = map[string]int{
+	"or": 1, "and": 2,
+	"<": 3, ">": 3, "<=": 3, ">=": 3, "~=": 3, "==": 3,
+	"..": 4,
+	"+":  5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+const luaUnaryPriority = 7
+
+// expr parses binary expressions with operators of priority above limit.
+func (p *luaParser) expr(limit int) luaExpr {
+	var x luaExpr
+	if t := p.peek(); t.kind == 'o' && (t.text == "-" || t.text == "#") || t.kind == 'n' && t.text == "not" {
+		p.next()
+		x = luaUnary{op: t.text, x: p.expr(luaUnaryPriority)}
+	} else {
+		x = p.simple()
+	}
+
+	for {
+		t := p.peek()
+		n, ok := luaPriority[t.text]
+		if !ok || t.kind == 's' || t.kind == '0' || n <= limit {
+			return x
+		}
+		p.next()
+		// concatenation is right associative
+		if t.text == ".." {
+			n--
+		}
+		x = luaBinary{op: t.text, l: x, r: p.expr(n)}
+	}
+}
+
+func (p *luaParser) simple() luaExpr {
+	t := p.peek()
+	switch {
+	case t.kind == '0':
+		p.next()
+		return luaConst{t.num}
+	case t.kind == 's':
+		p.next()
+		return luaConst{t.text}
+	case t.kind == 'n' && t.text == "nil":
+		p.next()
+		return luaConst{nil}
+	case t.kind == 'n' && t.text == "true":
+		p.next()
+		return luaConst{true}
+	case t.kind == 'n' && t.text == "false":
+		p.next()
+		return luaConst{false}
+	}
+	return p.primary()
+}
+
+func (p *luaParser) primary() luaExpr {
+	var x luaExpr
+	if p.accept('o', "(") {
+		x = p.expr(0)
+		p.expect('o', ")")
+	} else {
+		x = luaName{p.name()}
+	}
+
+	for {
+		switch {
+		case p.accept('o', "["):
+			x = luaIndex{x: x, key: p.expr(0)}
+			p.expect('o', "]")
+		case p.is('o', "."):
+			n, ok := x.(luaName)
+			if !ok {
+				p.fail("syntax error near '.'")
+			}
+			p.next()
+			x = luaName{n.name + "." + p.name()}
+		case p.accept('o', "("):
+			n, ok := x.(luaName)
+			if !ok || !luaFuncs[n.name] {
+				p.fail("unsupported function %v", x)
+			}
+			c := luaCall{fn: n.name}
+			if !p.accept('o', ")") {
+				for {
+					c.args = append(c.args, p.expr(0))
+					if p.accept('o', ")") {
+						break
+					}
+					p.expect('o', ",")
+				}
+			}
+			x = c
+		default:
+			return x
+		}
+	}
+}
+
+// luaState runs parsed script, commands are called by call under the store
+// lock.
+type luaState struct {
+	vars  []map[string]interface{}
+	call  func(cmd string, args []string) interface{}
+	done  bool
+	reply interface{}
+}
+
+type luaRuntimeError string
+
+func (l *luaState) fail(format string, args ...interface{}) {
+	panic(luaRuntimeError(fmt.Sprintf(format, args...)))
+}
+
+// runLua runs the script with keys and argv and returns its reply.
+func runLua(body []luaStmt, keys, argv []string, call func(string, []string) interface{}) (res interface{}) {
+	l := &luaState{
+		vars: []map[string]interface{}{{
+			"KEYS": luaStrings(keys),
+			"ARGV": luaStrings(argv),
+		}},
+		call: call,
+	}
+
+	defer func() {
+		switch e := recover().(type) {
+		case nil:
+		case luaRuntimeError:
+			res = redis.Error("ERR Error running script: " + string(e))
+		case redis.Error:
+			res = e
+		default:
+			panic(e)
+		}
+	}()
+
+	l.block(body)
+	return luaReply(l.reply)
+}
+
+func luaStrings(v []string) luaTable {
+	res := make(luaTable, len(v))
+	for i := range v {
+		res[i] = v[i]
+	}
+	return res
+}
+
+func (l *luaState) block(body []luaStmt) {
+	l.vars = append(l.vars, make(map[string]interface{}))
+	defer func() { l.vars = l.vars[:len(l.vars)-1] }()
+
+	for _, s := range body {
+		if l.done {
+			return
+		}
+		switch s := s.(type) {
+		case luaLocal:
+			var v interface{}
+			if s.x != nil {
+				v = l.eval(s.x)
+			}
+			l.vars[len(l.vars)-1][s.name] = v
+		case luaAssign:
+			v := l.eval(s.x)
+			for i := len(l.vars) - 1; i >= 0; i-- {
+				if _, ok := l.vars[i][s.name]; ok || i == 0 {
+					l.vars[i][s.name] = v
+					break
+				}
+			}
+		case luaReturn:
+			if s.x != nil {
+				l.reply = l.eval(s.x)
+			}
+			l.done = true
+		case luaIf:
+			ok := false
+			for i := range s.cond {
+				if luaTrue(l.eval(s.cond[i])) {
+					l.block(s.block[i])
+					ok = true
+					break
+				}
+			}
+			if !ok && s.other != nil {
+				l.block(s.other)
+			}
+		case luaCall:
+			l.eval(s)
+		}
+	}
+}
+
+func (l *luaState) eval(x luaExpr) interface{} {
+	switch x := x.(type) {
+	case luaConst:
+		return x.v
+	case luaName:
+		for i := len(l.vars) - 1; i >= 0; i-- {
+			if v, ok := l.vars[i][x.name]; ok {
+				return v
+			}
+		}
+		return nil
+	case luaIndex:
+		t, ok := l.eval(x.x).(luaTable)
+		if !ok {
+			l.fail("attempt to index a non-table value")
+		}
+		k, ok := l.eval(x.key).(float64)
+		if !ok || k != math.Trunc(k) || k < 1 || int(k) > len(t) {
+			return nil
+		}
+		return t[int(k)-1]
+	case luaUnary:
+		v := l.eval(x.x)
+		switch x.op {
+		case "not":
+			return !luaTrue(v)
+		case "#":
+			switch v := v.(type) {
+			case luaTable:
+				return float64(len(v))
+			case string:
+				return float64(len(v))
+			}
+			l.fail("attempt to get length of %s", luaType(v))
+		}
+		return -l.number(v)
+	case luaBinary:
+		return l.binary(x)
+	case luaCall:
+		return l.callFunc(x)
+	}
+	panic(fmt.Sprintf("redismem: unknown Lua expression %T", x))
+}
+
+func (l *luaState) binary(x luaBinary) interface{} {
+	switch x.op {
+	case "and":
+		a := l.eval(x.l)
+		if !luaTrue(a) {
+			return a
+		}
+		return l.eval(x.r)
+	case "or":
+		a := l.eval(x.l)
+		if luaTrue(a) {
+			return a
+		}
+		return l.eval(x.r)
+	}
+
+	a, b := l.eval(x.l), l.eval(x.r)
+	switch x.op {
+	case "==":
+		return luaEqual(a, b)
+	case "~=":
+		return !luaEqual(a, b)
+	case "..":
+		return l.text(a) + l.text(b)
+	case "<", ">", "<=", ">=":
+		sa, ok1 := a.(string)
+		sb, ok2 := b.(string)
+		var n int
+		switch {
+		case ok1 && ok2:
+			n = strings.Compare(sa, sb)
+		default:
+			fa, ok1 := a.(float64)
+			fb, ok2 := b.(float64)
+			if !ok1 || !ok2 {
+				l.fail("attempt to compare %s with %s", luaType(a), luaType(b))
+			}
+			switch {
+			case fa < fb:
+				n = -1
+			case fa > fb:
+				n = 1
+			}
+		}
+		switch x.op {
+		case "<":
+			return n < 0
+		case ">":
+			return n > 0
+		case "<=":
+			return n <= 0
+		}
+		return n >= 0
+	}
+
+	fa, fb := l.number(a), l.number(b)
+	switch x.op {
+	case "+":
+		return fa + fb
+	case "-":
+		return fa - fb
+	case "*":
+		return fa * fb
+	case "/":
+		return fa / fb
+	}
+	return fa - math.Floor(fa/fb)*fb
+}
+
+// number converts arithmetic operand as Lua does, strings are converted too.
+func (l *luaState) number(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case string:
+		if f, ok := luaParseNumber(v); ok {
+			return f
+		}
+	}
+	l.fail("attempt to perform arithmetic on a %s value", luaType(v))
+	return 0
+}
+
+func (l *luaState) text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return luaFormatNumber(v)
+	}
+	l.fail("attempt to concatenate a %s value", luaType(v))
+	return ""
+}
+
+func (l *luaState) callFunc(x luaCall) interface{} {
+	args := make([]interface{}, len(x.args))
+	for i := range x.args {
+		args[i] = l.eval(x.args[i])
+	}
+
+	switch x.fn {
+	case "tonumber":
+		if len(args) == 0 {
+			l.fail("bad argument #1 to 'tonumber' (value expected)")
+		}
+		switch v := args[0].(type) {
+		case float64:
+			return v
+		case string:
+			if f, ok := luaParseNumber(v); ok {
+				return f
+			}
+		}
+		return nil
+	case "tostring":
+		if len(args) == 0 {
+			l.fail("bad argument #1 to 'tostring' (value expected)")
+		}
+		switch v := args[0].(type) {
+		case nil:
+			return "nil"
+		case bool:
+			return strconv.FormatBool(v)
+		case string, float64:
+			return l.text(v)
+		}
+		return luaType(args[0])
+	}
+
+	if len(args) == 0 {
+		l.fail("please specify at least one argument for redis.call()")
+	}
+	a := make([]string, len(args))
+	for i := range args {
+		switch v := args[i].(type) {
+		case string:
+			a[i] = v
+		case float64:
+			a[i] = luaFormatNumber(v)
+		default:
+			l.fail("lua redis() command arguments must be strings or integers")
+		}
+	}
+
+	r := l.call(strings.ToUpper(a[0]), a[1:])
+	if e, ok := r.(redis.Error); ok {
+		if x.fn == "redis.pcall" {
+			return luaError(e)
+		}
+		panic(e)
+	}
+	return luaValue(r)
+}
+
+// luaValue converts command reply to Lua value as Redis does.
+func luaValue(r interface{}) interface{} {
+	switch r := r.(type) {
+	case int64:
+		return float64(r)
+	case []byte:
+		return string(r)
+	case string:
+		return luaStatus(r)
+	case []interface{}:
+		res := make(luaTable, len(r))
+		for i := range r {
+			res[i] = luaValue(r[i])
+		}
+		return res
+	case nil:
+		return false
+	}
+	return r
+}
+
+// luaReply converts Lua value to reply of EVAL as Redis does.
+func luaReply(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		return int64(v)
+	case string:
+		return []byte(v)
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return nil
+	case luaTable:
+		res := make([]interface{}, 0, len(v))
+		for i := range v {
+			if v[i] == nil {
+				break
+			}
+			res = append(res, luaReply(v[i]))
+		}
+		return res
+	case luaStatus:
+		return string(v)
+	case luaError:
+		return redis.Error(v)
+	}
+	return nil
+}
+
+// luaEqual compares values, tables are never equal as they are not shared.
+func luaEqual(a, b interface{}) bool {
+	_, ok1 := a.(luaTable)
+	_, ok2 := b.(luaTable)
+	return !ok1 && !ok2 && a == b
+}
+
+func luaTrue(v interface{}) bool {
+	return v != nil && v != false
+}
+
+func luaType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	}
+	return "table"
+}
+
+func luaParseNumber(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return f, err == nil
+}
+
+// luaFormatNumber formats number as Lua 5.1 does, with %.14g.
+func luaFormatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'g', 14, 64)
+}
@@ -0,0 +1,273 @@
+package redismem
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var errClosed = errors.New("redismem: connection closed")
+
+// Store is in-memory keyspace which understands the subset of Redis commands
+// used by the application. It is intended for local development and tests.
+type Store struct {
+	mu   sync.Mutex
+	keys map[string]interface{}
+	vers map[string]uint64
+	ver  uint64
+	path string
+}
+
+// New returns empty in-memory store.
+func New() *Store {
+	return &Store{
+		keys: make(map[string]interface{}),
+		vers: make(map[string]uint64),
+	}
+}
+
+// Get returns connection to the store, it is never shared between goroutines.
+func (s *Store) Get() redis.Conn {
+	return &conn{s: s}
+}
+
+// Close saves the store opened from file, see Open.
+func (s *Store) Close() error {
+	if s.path == "" {
+		return nil
+	}
+	return s.save(s.path)
+}
+
+// touch marks key as modified for WATCH. Must be called under lock.
+func (s *Store) touch(key string) {
+	s.ver++
+	s.vers[key] = s.ver
+}
+
+func (s *Store) version(key string) uint64 {
+	return s.vers[key]
+}
+
+type conn struct {
+	s       *Store
+	sent    [][]string
+	replies []interface{}
+	multi   bool
+	queue   [][]string
+	watch   map[string]uint64
+	closed  bool
+}
+
+// Close closes the connection and discards transaction state.
+func (c *conn) Close() error {
+	c.closed = true
+	c.multi = false
+	c.queue = nil
+	c.watch = nil
+	return nil
+}
+
+// Err returns a non-nil value when the connection is not usable.
+func (c *conn) Err() error {
+	if c.closed {
+		return errClosed
+	}
+	return nil
+}
+
+// Send writes the command to the output buffer.
+func (c *conn) Send(cmd string, args ...interface{}) error {
+	if c.closed {
+		return errClosed
+	}
+	c.sent = append(c.sent, makeCommand(cmd, args))
+	return nil
+}
+
+// Flush executes buffered commands and keeps replies for Receive.
+func (c *conn) Flush() error {
+	if c.closed {
+		return errClosed
+	}
+	for i := range c.sent {
+		c.replies = append(c.replies, c.exec(c.sent[i]))
+	}
+	c.sent = nil
+	return nil
+}
+
+// Receive receives a single reply.
+func (c *conn) Receive() (interface{}, error) {
+	if len(c.replies) == 0 {
+		err := c.Flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(c.replies) == 0 {
+		return nil, errors.New("redismem: no pending replies")
+	}
+
+	r := c.replies[0]
+	c.replies = c.replies[1:]
+	if err, ok := r.(redis.Error); ok {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Do executes the command and returns the received reply, pending replies
+// are consumed like redigo does.
+func (c *conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	err := c.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := c.replies
+	c.replies = nil
+	if cmd == "" {
+		if len(pending) == 0 {
+			return nil, nil
+		}
+		return pending, nil
+	}
+
+	r := c.exec(makeCommand(cmd, args))
+	for i := range pending {
+		if e, ok := pending[i].(redis.Error); ok {
+			return r, e
+		}
+	}
+	if e, ok := r.(redis.Error); ok {
+		return r, e
+	}
+	return r, nil
+}
+
+func (c *conn) exec(v []string) interface{} {
+	name := strings.ToUpper(v[0])
+	args := v[1:]
+
+	switch name {
+	case "MULTI":
+		if c.multi {
+			return redis.Error("ERR MULTI calls can not be nested")
+		}
+		c.multi = true
+		return "OK"
+	case "DISCARD":
+		if !c.multi {
+			return redis.Error("ERR DISCARD without MULTI")
+		}
+		c.multi = false
+		c.queue = nil
+		c.watch = nil
+		return "OK"
+	case "EXEC":
+		if !c.multi {
+			return redis.Error("ERR EXEC without MULTI")
+		}
+		return c.execQueue()
+	case "WATCH":
+		if c.multi {
+			return redis.Error("ERR WATCH inside MULTI is not allowed")
+		}
+		c.s.mu.Lock()
+		if c.watch == nil {
+			c.watch = make(map[string]uint64, len(args))
+		}
+		for i := range args {
+			c.watch[args[i]] = c.s.version(args[i])
+		}
+		c.s.mu.Unlock()
+		return "OK"
+	case "UNWATCH":
+		c.watch = nil
+		return "OK"
+	}
+
+	f, ok := commands[name]
+	if !ok {
+		return redis.Error(fmt.Sprintf("ERR unknown command '%s'", v[0]))
+	}
+
+	if c.multi {
+		c.queue = append(c.queue, v)
+		return "QUEUED"
+	}
+
+	if name == "XREAD" {
+		return c.xread(args)
+	}
+
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	return f(c.s, args)
+}
+
+func (c *conn) execQueue() interface{} {
+	q, w := c.queue, c.watch
+	c.multi = false
+	c.queue = nil
+	c.watch = nil
+
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	for k, v := range w {
+		if c.s.version(k) != v {
+			return nil // transaction aborted
+		}
+	}
+
+	res := make([]interface{}, len(q))
+	for i := range q {
+		res[i] = commands[strings.ToUpper(q[i][0])](c.s, q[i][1:])
+	}
+	return res
+}
+
+func makeCommand(cmd string, args []interface{}) []string {
+	v := make([]string, 0, len(args)+1)
+	v = append(v, cmd)
+	for i := range args {
+		v = append(v, ArgString(args[i]))
+	}
+	return v
+}
+
+// ArgString converts argument the same way as redigo writes it to the wire.
+func ArgString(arg interface{}) string {
+	switch x := arg.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case int:
+		return strconv.Itoa(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case bool:
+		if x {
+			return "1"
+		}
+		return "0"
+	case nil:
+		return ""
+	case redis.Argument:
+		return ArgString(x.RedisArg())
+	default:
+		var buf bytes.Buffer
+		fmt.Fprint(&buf, x)
+		return buf.String()
+	}
+}
@@ -0,0 +1,196 @@
+package redismem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestPipeline(t *testing.T) {
+	c := New().Get()
+	defer c.Close()
+
+	for _, k := range []string{"a", "b"} {
+		err := c.Send("SADD", "s:"+k, 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Send("SMEMBERS", "s:"+k)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v, err := redis.Values(c.Do(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 4 {
+		t.Fatalf("replies: %v", v)
+	}
+	ids, err := redis.Int64s(v[3], nil)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("smembers: %v %v", ids, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := New()
+	c1, c2 := s.Get(), s.Get()
+	defer c1.Close()
+	defer c2.Close()
+
+	_, err := c1.Do("WATCH", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c2.Do("SET", "other", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = c1.Send("MULTI")
+	_ = c1.Send("SET", "k", 1)
+	v, err := c1.Do("EXEC")
+	if err != nil || v == nil {
+		t.Fatalf("exec with untouched watch: %v %v", v, err)
+	}
+
+	_, _ = c1.Do("WATCH", "k")
+	_, _ = c2.Do("INCR", "k")
+	_ = c1.Send("MULTI")
+	_ = c1.Send("SET", "k", 10)
+	v, err = c1.Do("EXEC")
+	if err != nil || v != nil {
+		t.Fatalf("exec with touched watch: %v %v", v, err)
+	}
+
+	n, err := redis.Int(c1.Do("GET", "k"))
+	if err != nil || n != 2 {
+		t.Fatalf("get: %v %v", n, err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redismem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.json")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := s.Get()
+	_ = c.Send("SET", "str", "v")
+	_ = c.Send("HSET", "hash", "f", "v")
+	_ = c.Send("SADD", "set", "a", "b")
+	_ = c.Send("ZADD", "zset", 2, "b", 1, "a")
+	_ = c.Send("RPUSH", "list", "a", "b")
+	_ = c.Send("XADD", "stream", "1-1", "f", "v")
+	_, err = c.Do("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dumpAll(t, c)
+	c.Close()
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = s.Get()
+	defer c.Close()
+	got := dumpAll(t, c)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("reopened store:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func dumpAll(t *testing.T, c redis.Conn) map[string]string {
+	keys, err := redis.Strings(c.Do("KEYS", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]string, len(keys))
+	for _, k := range keys {
+		var v interface{}
+		switch k {
+		case "set":
+			var m []string
+			m, err = redis.Strings(c.Do("SMEMBERS", k))
+			sort.Strings(m)
+			v = m
+		case "stream":
+			v, err = c.Do("XRANGE", k, "-", "+")
+		default:
+			v, err = c.Do("DUMP", k)
+		}
+		if err != nil {
+			t.Fatal(k, err)
+		}
+		res[k] = fmt.Sprintf("%s", v)
+	}
+	return res
+}
+
+func TestScript(t *testing.T) {
+	const src = `local n = redis.call('INCRBY', KEYS[1], ARGV[1])
+if n > 4 then
+	redis.call('ZADD', KEYS[2], n * tonumber(ARGV[2]), 'x' .. n)
+end
+return n`
+
+	c := New().Get()
+	defer c.Close()
+
+	_ = c.Send("MULTI")
+	_ = c.Send("EVAL", src, 2, "n", "z", 2, -1)
+	_ = c.Send("EVAL", src, 2, "n", "z", 3, -1)
+	v, err := redis.Int64s(c.Do("EXEC"))
+	if err != nil || !reflect.DeepEqual(v, []int64{2, 5}) {
+		t.Fatalf("eval: %v %v", v, err)
+	}
+
+	sha, err := redis.String(c.Do("SCRIPT", "LOAD", src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := redis.Int64(c.Do("EVALSHA", sha, 2, "n", "z", 1, 1))
+	if err != nil || n != 6 {
+		t.Fatalf("evalsha: %v %v", n, err)
+	}
+
+	z, err := redis.Strings(c.Do("ZRANGE", "z", 0, -1, "WITHSCORES"))
+	if err != nil || !reflect.DeepEqual(z, []string{"x5", "-5", "x6", "6"}) {
+		t.Fatalf("zrange: %v %v", z, err)
+	}
+
+	_, err = c.Do("EVAL", "return redis.call('SET', KEYS[1])", 1, "k")
+	if err == nil {
+		t.Fatal("error of command is not returned")
+	}
+	_, err = c.Do("EVAL", "local e = redis.pcall('NOPE') return e", 0)
+	if err == nil || !strings.Contains(err.Error(), "Unknown Redis command") {
+		t.Fatalf("error of pcall is not returned: %v", err)
+	}
+	_, err = c.Do("EVAL", "for i = 1, 2 do end", 0)
+	if err == nil || !strings.Contains(err.Error(), "compiling") {
+		t.Fatalf("unsupported script is run: %v", err)
+	}
+}
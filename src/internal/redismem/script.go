@@ -0,0 +1,120 @@
+package redismem
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// scripts are parsed scripts by SHA1 of their source, as the script cache of
+// Redis they are shared by all connections.
+var scripts = struct {
+	sync.RWMutex
+	m map[string][]luaStmt
+}{m: make(map[string][]luaStmt)}
+
+func scriptSHA(src string) string {
+	b := sha1.Sum([]byte(src))
+	return hex.EncodeToString(b[:])
+}
+
+// loadScript parses and caches the script, it returns SHA1 of the source.
+func loadScript(src string) (string, redis.Error) {
+	sha := scriptSHA(src)
+	if _, ok := findScript(sha); ok {
+		return sha, ""
+	}
+
+	body, err := parseLua(src)
+	if err != nil {
+		return "", redis.Error("ERR Error compiling script (redismem): " + err.Error())
+	}
+
+	scripts.Lock()
+	scripts.m[sha] = body
+	scripts.Unlock()
+	return sha, ""
+}
+
+func findScript(sha string) ([]luaStmt, bool) {
+	scripts.RLock()
+	body, ok := scripts.m[strings.ToLower(sha)]
+	scripts.RUnlock()
+	return body, ok
+}
+
+var errNoScript = redis.Error("NOSCRIPT No matching script. Please use EVAL.")
+
+func cmdEval(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("eval")
+	}
+	sha, err := loadScript(a[0])
+	if err != "" {
+		return err
+	}
+	return evalScript(s, sha, a[1:])
+}
+
+func cmdEvalSHA(s *Store, a []string) interface{} {
+	if len(a) < 2 {
+		return errArgs("evalsha")
+	}
+	return evalScript(s, a[0], a[1:])
+}
+
+func evalScript(s *Store, sha string, a []string) interface{} {
+	body, ok := findScript(sha)
+	if !ok {
+		return errNoScript
+	}
+
+	n, err := strconv.Atoi(a[0])
+	if err != nil || n < 0 {
+		return errInt
+	}
+	if n > len(a)-1 {
+		return redis.Error("ERR Number of keys can't be greater than number of args")
+	}
+
+	return runLua(body, a[1:1+n], a[1+n:], func(cmd string, args []string) interface{} {
+		f, ok := commands[cmd]
+		if !ok || cmd == "EVAL" || cmd == "EVALSHA" || cmd == "SCRIPT" {
+			return redis.Error("ERR Unknown Redis command called from Lua script")
+		}
+		return f(s, args)
+	})
+}
+
+// cmdScript supports SCRIPT LOAD and SCRIPT EXISTS.
+func cmdScript(_ *Store, a []string) interface{} {
+	if len(a) == 0 {
+		return errArgs("script")
+	}
+	switch strings.ToUpper(a[0]) {
+	case "LOAD":
+		if len(a) != 2 {
+			return errArgs("script|load")
+		}
+		sha, err := loadScript(a[1])
+		if err != "" {
+			return err
+		}
+		return []byte(sha)
+	case "EXISTS":
+		res := make([]interface{}, len(a)-1)
+		for i := range a[1:] {
+			_, ok := findScript(a[1+i])
+			res[i] = int64(0)
+			if ok {
+				res[i] = int64(1)
+			}
+		}
+		return res
+	}
+	return errSyntax
+}
@@ -0,0 +1,339 @@
+package redismem
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+type streamID struct {
+	ms, seq uint64
+}
+
+func (id streamID) less(x streamID) bool {
+	return id.ms < x.ms || (id.ms == x.ms && id.seq < x.seq)
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+type streamValue struct {
+	entries []streamEntry
+	last    streamID
+}
+
+var errStreamID = redis.Error("ERR Invalid stream ID specified as stream command argument")
+
+// parseStreamID parses ms-seq, missing seq is replaced with def.
+func parseStreamID(s string, def uint64) (streamID, bool) {
+	switch s {
+	case "-":
+		return streamID{}, true
+	case "+":
+		return streamID{math.MaxUint64, math.MaxUint64}, true
+	}
+
+	p := strings.SplitN(s, "-", 2)
+	ms, err := strconv.ParseUint(p[0], 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	seq := def
+	if len(p) == 2 {
+		seq, err = strconv.ParseUint(p[1], 10, 64)
+		if err != nil {
+			return streamID{}, false
+		}
+	}
+	return streamID{ms, seq}, true
+}
+
+func (s *Store) stream(key string, create bool) (*streamValue, redis.Error) {
+	switch v := s.keys[key].(type) {
+	case nil:
+		if !create {
+			return nil, ""
+		}
+		x := &streamValue{}
+		s.keys[key] = x
+		return x, ""
+	case *streamValue:
+		return v, ""
+	}
+	return nil, errType
+}
+
+func streamReply(v []streamEntry) []interface{} {
+	res := make([]interface{}, len(v))
+	for i := range v {
+		f := make([]interface{}, len(v[i].fields))
+		for j := range f {
+			f[j] = []byte(v[i].fields[j])
+		}
+		res[i] = []interface{}{[]byte(v[i].id.String()), f}
+	}
+	return res
+}
+
+func cmdXAdd(s *Store, a []string) interface{} {
+	if len(a) < 4 {
+		return errArgs("xadd")
+	}
+
+	key, a := a[0], a[1:]
+	maxLen := -1
+	if strings.EqualFold(a[0], "MAXLEN") {
+		a = a[1:]
+		if len(a) > 0 && (a[0] == "~" || a[0] == "=") {
+			a = a[1:]
+		}
+		if len(a) == 0 {
+			return errSyntax
+		}
+		var err error
+		maxLen, err = strconv.Atoi(a[0])
+		if err != nil {
+			return errInt
+		}
+		a = a[1:]
+	}
+	if len(a) < 3 || len(a)%2 != 1 {
+		return errArgs("xadd")
+	}
+
+	x, err := s.stream(key, true)
+	if err != "" {
+		return err
+	}
+
+	var id streamID
+	if a[0] == "*" {
+		id = streamID{uint64(time.Now().UnixNano() / int64(time.Millisecond)), 0}
+		if !x.last.less(id) {
+			id = streamID{x.last.ms, x.last.seq + 1}
+		}
+	} else {
+		var ok bool
+		id, ok = parseStreamID(a[0], 0)
+		if !ok {
+			return errStreamID
+		}
+		if !x.last.less(id) {
+			return redis.Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+	}
+
+	x.entries = append(x.entries, streamEntry{id, append([]string(nil), a[1:]...)})
+	x.last = id
+	if maxLen >= 0 && len(x.entries) > maxLen {
+		x.entries = append(x.entries[:0:0], x.entries[len(x.entries)-maxLen:]...)
+	}
+	s.touch(key)
+
+	return []byte(id.String())
+}
+
+func cmdXLen(s *Store, a []string) interface{} {
+	if len(a) != 1 {
+		return errArgs("xlen")
+	}
+	x, err := s.stream(a[0], false)
+	if err != "" {
+		return err
+	}
+	if x == nil {
+		return int64(0)
+	}
+	return int64(len(x.entries))
+}
+
+func cmdXRange(s *Store, a []string) interface{} {
+	if len(a) != 3 && len(a) != 5 {
+		return errArgs("xrange")
+	}
+	return xrange(s, a[0], a[1], a[2], a[3:], false)
+}
+
+func cmdXRevRange(s *Store, a []string) interface{} {
+	if len(a) != 3 && len(a) != 5 {
+		return errArgs("xrevrange")
+	}
+	return xrange(s, a[0], a[2], a[1], a[3:], true)
+}
+
+func xrange(s *Store, key, from, to string, a []string, rev bool) interface{} {
+	start, ok1 := parseStreamID(from, 0)
+	end, ok2 := parseStreamID(to, math.MaxUint64)
+	if !ok1 || !ok2 {
+		return errStreamID
+	}
+
+	count := -1
+	if len(a) == 2 {
+		if !strings.EqualFold(a[0], "COUNT") {
+			return errSyntax
+		}
+		var err error
+		count, err = strconv.Atoi(a[1])
+		if err != nil {
+			return errInt
+		}
+	}
+
+	x, err := s.stream(key, false)
+	if err != "" {
+		return err
+	}
+	if x == nil {
+		return []interface{}{}
+	}
+
+	var res []streamEntry
+	for i := range x.entries {
+		j := i
+		if rev {
+			j = len(x.entries) - 1 - i
+		}
+		e := x.entries[j]
+		if e.id.less(start) || end.less(e.id) {
+			continue
+		}
+		if count >= 0 && len(res) == count {
+			break
+		}
+		res = append(res, e)
+	}
+
+	return streamReply(res)
+}
+
+// xreadArgs returns COUNT, BLOCK in milliseconds (-1 if missing) and index
+// of the first key.
+func xreadArgs(a []string) (int, int, int, redis.Error) {
+	count, block := -1, -1
+	for i := 0; i < len(a); i++ {
+		switch strings.ToUpper(a[i]) {
+		case "COUNT", "BLOCK":
+			if i+1 >= len(a) {
+				return 0, 0, 0, errSyntax
+			}
+			n, err := strconv.Atoi(a[i+1])
+			if err != nil {
+				return 0, 0, 0, errInt
+			}
+			if strings.EqualFold(a[i], "COUNT") {
+				count = n
+			} else {
+				block = n
+			}
+			i++
+		case "STREAMS":
+			if (len(a)-i-1)%2 != 0 || len(a)-i-1 == 0 {
+				return 0, 0, 0, redis.Error("ERR Unbalanced XREAD list of streams")
+			}
+			return count, block, i + 1, ""
+		default:
+			return 0, 0, 0, errSyntax
+		}
+	}
+	return 0, 0, 0, errSyntax
+}
+
+// cmdXRead answers immediately, BLOCK is handled by connection.
+func cmdXRead(s *Store, a []string) interface{} {
+	count, _, from, err := xreadArgs(a)
+	if err != "" {
+		return err
+	}
+
+	n := (len(a) - from) / 2
+	var res []interface{}
+	for i := 0; i < n; i++ {
+		key, last := a[from+i], a[from+n+i]
+
+		x, err := s.stream(key, false)
+		if err != "" {
+			return err
+		}
+		if x == nil {
+			continue
+		}
+
+		after := x.last
+		if last != "$" {
+			var ok bool
+			after, ok = parseStreamID(last, 0)
+			if !ok {
+				return errStreamID
+			}
+		}
+
+		var v []streamEntry
+		for _, e := range x.entries {
+			if !after.less(e.id) {
+				continue
+			}
+			if count >= 0 && len(v) == count {
+				break
+			}
+			v = append(v, e)
+		}
+		if len(v) > 0 {
+			res = append(res, []interface{}{[]byte(key), streamReply(v)})
+		}
+	}
+
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
+// xread resolves $ to the last ids and polls the store until data arrives
+// or BLOCK timeout expires.
+func (c *conn) xread(a []string) interface{} {
+	_, block, from, err := xreadArgs(a)
+	if err != "" {
+		return err
+	}
+
+	a = append([]string(nil), a...)
+	c.s.mu.Lock()
+	n := (len(a) - from) / 2
+	for i := 0; i < n; i++ {
+		if a[from+n+i] != "$" {
+			continue
+		}
+		x, err := c.s.stream(a[from+i], false)
+		if err != "" {
+			c.s.mu.Unlock()
+			return err
+		}
+		a[from+n+i] = "0-0"
+		if x != nil {
+			a[from+n+i] = x.last.String()
+		}
+	}
+	c.s.mu.Unlock()
+
+	deadline := time.Now().Add(time.Duration(block) * time.Millisecond)
+	for {
+		c.s.mu.Lock()
+		res := cmdXRead(c.s, a)
+		c.s.mu.Unlock()
+
+		if res != nil || block < 0 || (block > 0 && time.Now().After(deadline)) {
+			return res
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,78 @@
+package redispool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Getter is common interface for connection pools.
+type Getter interface {
+	Get() redis.Conn
+}
+
+// Balancer spreads connections over replicas by round robin. Replicas which
+// failed to give connection are skipped for a while, if all replicas are
+// down, connections are taken from main.
+type Balancer struct {
+	mu   sync.Mutex
+	main Getter
+	list []Getter
+	down []time.Time
+	next int
+	wait time.Duration
+}
+
+// NewBalancer returns balancer over replicas with fallback to main.
+func NewBalancer(main Getter, replicas ...Getter) *Balancer {
+	return &Balancer{
+		main: main,
+		list: replicas,
+		down: make([]time.Time, len(replicas)),
+		wait: 5 * time.Second,
+	}
+}
+
+// Get returns connection to the next healthy replica or to main.
+func (b *Balancer) Get() redis.Conn {
+	for n := 0; n < len(b.list); n++ {
+		i, ok := b.pick()
+		if !ok {
+			break
+		}
+
+		c := b.list[i].Get()
+		if c.Err() == nil {
+			return c
+		}
+
+		_ = c.Close()
+		b.fail(i)
+	}
+
+	return b.main.Get()
+}
+
+// pick returns index of the next replica, which is not marked as down.
+func (b *Balancer) pick() (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for n := 0; n < len(b.list); n++ {
+		i := b.next
+		b.next = (b.next + 1) % len(b.list)
+		if now.After(b.down[i]) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func (b *Balancer) fail(i int) {
+	b.mu.Lock()
+	b.down[i] = time.Now().Add(b.wait)
+	b.mu.Unlock()
+}
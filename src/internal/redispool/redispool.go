@@ -1,7 +1,12 @@
 package redispool
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -9,15 +14,45 @@ import (
 
 const defaultURL = "redis://localhost:6379"
 
+// Pool is wrapper for *redis.Pool with dial params.
+type Pool struct {
+	pool *redis.Pool
+
+	addr      string
+	password  string
+	db        int
+	useTLS    bool
+	tlsConfig *tls.Config
+
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+
+	sentinel          *sentinel
+	sentinelPassword  string
+	sentinelTLS       bool
+	sentinelTLSConfig *tls.Config
+
+	testIdle time.Duration
+	testRole bool
+}
+
 // New returns connection pool to Redis Server.
-func New(options ...func(*redis.Pool) error) (*redis.Pool, error) {
-	p := &redis.Pool{}
+func New(options ...func(*Pool) error) (*Pool, error) {
+	p := &Pool{
+		pool: &redis.Pool{},
+	}
 
 	err := Address(defaultURL)(p)
 	if err != nil {
 		return nil, err
 	}
 
+	err = TestOnBorrow(time.Minute)(p)
+	if err != nil {
+		return nil, err
+	}
+
 	for i := range options {
 		err = options[i](p)
 		if err != nil {
@@ -25,36 +60,283 @@ func New(options ...func(*redis.Pool) error) (*redis.Pool, error) {
 		}
 	}
 
+	p.pool.Dial = p.dial
+	p.pool.TestOnBorrow = p.testOnBorrow
+
 	return p, nil
 }
 
-// Address is TCP address to listen on, "redis://localhost:6379" if empty.
-func Address(a string) func(*redis.Pool) error {
-	return func(p *redis.Pool) error {
+// Get gets a connection from the pool.
+func (p *Pool) Get() redis.Conn {
+	return p.pool.Get()
+}
+
+// Close releases the resources used by the pool.
+func (p *Pool) Close() error {
+	return p.pool.Close()
+}
+
+func (p *Pool) dial() (redis.Conn, error) {
+	addr := p.addr
+	if p.sentinel != nil {
+		var err error
+		addr, err = p.sentinel.masterAddr(p.sentinelDialOptions()...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return redis.Dial("tcp", addr, append(p.dialOptions(), redis.DialDatabase(p.db))...)
+}
+
+// dialOptions are options of connections to servers.
+func (p *Pool) dialOptions() []redis.DialOption {
+	return []redis.DialOption{
+		redis.DialPassword(p.password),
+		redis.DialUseTLS(p.useTLS),
+		redis.DialTLSConfig(p.tlsConfig),
+		redis.DialConnectTimeout(p.connectTimeout),
+		redis.DialReadTimeout(p.readTimeout),
+		redis.DialWriteTimeout(p.writeTimeout),
+	}
+}
+
+// sentinelDialOptions are options of connections to sentinels, which have
+// their own password and TLS.
+func (p *Pool) sentinelDialOptions() []redis.DialOption {
+	return []redis.DialOption{
+		redis.DialPassword(p.sentinelPassword),
+		redis.DialUseTLS(p.sentinelTLS),
+		redis.DialTLSConfig(p.sentinelTLSConfig),
+		redis.DialConnectTimeout(p.connectTimeout),
+		redis.DialReadTimeout(p.readTimeout),
+		redis.DialWriteTimeout(p.writeTimeout),
+	}
+}
+
+// Address is URL of Redis server, "redis://localhost:6379" if empty.
+// Format is redis[s]://[:password@]host[:port][/db][?dial_timeout=&read_timeout=&write_timeout=],
+// rediss scheme enables TLS. Master of Sentinel set is addressed by
+// redis[s]+sentinel://[:password@]host1[:port],host2[:port]/name[/db][?...],
+// where password and rediss are of servers, sentinel_password= and
+// sentinel_tls=true parameters are of sentinels. test_role=true parameter
+// enables TestRole. Password, database and TLS set by other options are kept
+// unless the URL has them.
+func Address(a string) func(*Pool) error {
+	return func(p *Pool) error {
+		if a == "" {
+			a = defaultURL
+		}
+
 		u, err := url.Parse(a)
 		if err != nil {
 			return err
 		}
-		p.Dial = func() (redis.Conn, error) {
-			return redis.Dial("tcp", u.Host)
+
+		switch u.Scheme {
+		case "redis", "redis+sentinel":
+		case "rediss", "rediss+sentinel":
+			p.useTLS = true
+		default:
+			return fmt.Errorf("invalid redis URL scheme: %s", u.Scheme)
+		}
+
+		path := strings.Trim(u.Path, "/")
+
+		p.sentinel = nil
+		if strings.HasSuffix(u.Scheme, "+sentinel") {
+			name := path
+			if i := strings.Index(path, "/"); i >= 0 {
+				name, path = path[:i], path[i+1:]
+			} else {
+				path = ""
+			}
+			p.sentinel, err = newSentinel(name, splitHosts(u.Host, "26379")...)
+			if err != nil {
+				return err
+			}
+		} else {
+			p.addr = splitHosts(u.Host, "6379")[0]
+		}
+
+		if u.User != nil {
+			if s, ok := u.User.Password(); ok {
+				p.password = s
+			}
+		}
+
+		if path != "" {
+			p.db, err = strconv.Atoi(path)
+			if err != nil {
+				return fmt.Errorf("invalid redis database: %s", path)
+			}
+		}
+
+		q := u.Query()
+		for k, d := range map[string]*time.Duration{
+			"dial_timeout":  &p.connectTimeout,
+			"read_timeout":  &p.readTimeout,
+			"write_timeout": &p.writeTimeout,
+		} {
+			if s := q.Get(k); s != "" {
+				*d, err = time.ParseDuration(s)
+				if err != nil {
+					return fmt.Errorf("invalid redis %s: %s", k, s)
+				}
+			}
+		}
+
+		if s := q.Get("sentinel_password"); s != "" {
+			p.sentinelPassword = s
+		}
+
+		if s := q.Get("sentinel_tls"); s != "" {
+			p.sentinelTLS, err = strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("invalid redis sentinel_tls: %s", s)
+			}
+		}
+
+		if s := q.Get("test_role"); s != "" {
+			p.testRole, err = strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("invalid redis test_role: %s", s)
+			}
+		}
+
+		return nil
+	}
+}
+
+// Password sets password for AUTH, it overrides password from URL.
+func Password(s string) func(*Pool) error {
+	return func(p *Pool) error {
+		p.password = s
+		return nil
+	}
+}
+
+// Database sets database index for SELECT, it overrides database from URL.
+func Database(db int) func(*Pool) error {
+	return func(p *Pool) error {
+		if db < 0 {
+			return fmt.Errorf("invalid redis database: %d", db)
 		}
+		p.db = db
+		return nil
+	}
+}
+
+// TLSConfig enables TLS with the given config.
+func TLSConfig(c *tls.Config) func(*Pool) error {
+	return func(p *Pool) error {
+		p.useTLS = true
+		p.tlsConfig = c
+		return nil
+	}
+}
+
+// SentinelPassword sets password for AUTH to sentinels, it overrides
+// sentinel_password from URL.
+func SentinelPassword(s string) func(*Pool) error {
+	return func(p *Pool) error {
+		p.sentinelPassword = s
+		return nil
+	}
+}
+
+// SentinelTLSConfig enables TLS to sentinels with the given config.
+func SentinelTLSConfig(c *tls.Config) func(*Pool) error {
+	return func(p *Pool) error {
+		p.sentinelTLS = true
+		p.sentinelTLSConfig = c
+		return nil
+	}
+}
+
+// ConnectTimeout sets timeout for connecting to Redis server.
+func ConnectTimeout(d time.Duration) func(*Pool) error {
+	return func(p *Pool) error {
+		p.connectTimeout = d
+		return nil
+	}
+}
+
+// ReadTimeout sets timeout for reading a single command reply.
+func ReadTimeout(d time.Duration) func(*Pool) error {
+	return func(p *Pool) error {
+		p.readTimeout = d
 		return nil
 	}
 }
 
+// WriteTimeout sets timeout for writing a single command.
+func WriteTimeout(d time.Duration) func(*Pool) error {
+	return func(p *Pool) error {
+		p.writeTimeout = d
+		return nil
+	}
+}
+
+// TestOnBorrow PINGs idle connections before they are returned by the pool,
+// if they remained idle longer than d. Connections which failed are closed.
+// If the value is zero, then every connection is checked.
+func TestOnBorrow(d time.Duration) func(*Pool) error {
+	return func(p *Pool) error {
+		p.testIdle = d
+		return nil
+	}
+}
+
+// TestRole checks that every borrowed connection still points to master,
+// so connections to demoted node are closed after failover.
+func TestRole(b bool) func(*Pool) error {
+	return func(p *Pool) error {
+		p.testRole = b
+		return nil
+	}
+}
+
+func (p *Pool) testOnBorrow(c redis.Conn, t time.Time) error {
+	if p.testRole {
+		return testRole(c, "master")
+	}
+	if time.Since(t) < p.testIdle {
+		return nil
+	}
+	_, err := c.Do("PING")
+	return err
+}
+
+// splitHosts splits comma separated hosts and adds default port if missing.
+func splitHosts(s, port string) []string {
+	v := strings.Split(s, ",")
+	for i := range v {
+		h, p, err := net.SplitHostPort(v[i])
+		if err != nil {
+			h, p = v[i], port // assume port is missing
+		}
+		if h == "" {
+			h = "localhost"
+		}
+		v[i] = net.JoinHostPort(h, p)
+	}
+	return v
+}
+
 // MaxActive sets maximum number of connections allocated by the pool at a given time.
 // When zero, there is no limit on the number of connections in the pool.
-func MaxActive(m int) func(*redis.Pool) error {
-	return func(p *redis.Pool) error {
-		p.MaxActive = m
+func MaxActive(m int) func(*Pool) error {
+	return func(p *Pool) error {
+		p.pool.MaxActive = m
 		return nil
 	}
 }
 
 // MaxIdle sets maximum number of idle connections in the pool.
-func MaxIdle(m int) func(*redis.Pool) error {
-	return func(p *redis.Pool) error {
-		p.MaxIdle = m
+func MaxIdle(m int) func(*Pool) error {
+	return func(p *Pool) error {
+		p.pool.MaxIdle = m
 		return nil
 	}
 }
@@ -62,9 +344,9 @@ func MaxIdle(m int) func(*redis.Pool) error {
 // IdleTimeout closes connections after remaining idle for this duration. If the value
 // is zero, then idle connections are not closed. Applications should set
 // the timeout to a value less than the server's timeout.
-func IdleTimeout(d time.Duration) func(*redis.Pool) error {
-	return func(p *redis.Pool) error {
-		p.IdleTimeout = d
+func IdleTimeout(d time.Duration) func(*Pool) error {
+	return func(p *Pool) error {
+		p.pool.IdleTimeout = d
 		return nil
 	}
 }
@@ -72,9 +354,9 @@ func IdleTimeout(d time.Duration) func(*redis.Pool) error {
 // Wait is rule for Get()'s behavior.
 // If Wait is true and the pool is at the MaxActive limit, then Get() waits
 // for a connection to be returned to the pool before returning.
-func Wait(w bool) func(*redis.Pool) error {
-	return func(p *redis.Pool) error {
-		p.Wait = w
+func Wait(w bool) func(*Pool) error {
+	return func(p *Pool) error {
+		p.pool.Wait = w
 		return nil
 	}
 }
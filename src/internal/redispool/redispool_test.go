@@ -0,0 +1,247 @@
+package redispool
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestAddressKeepsOptions(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		options  []func(*Pool) error
+		password string
+		db       int
+		useTLS   bool
+	}{
+		{"url", []func(*Pool) error{Address("rediss://:secret@host/2")}, "secret", 2, true},
+		{"options before", []func(*Pool) error{Password("secret"), Database(3), TLSConfig(&tls.Config{}), Address("redis://host")}, "secret", 3, true},
+		{"options after", []func(*Pool) error{Address("redis://:url@host/2"), Password("secret"), Database(3)}, "secret", 3, false},
+		{"url overrides", []func(*Pool) error{Password("secret"), Database(3), Address("redis://:url@host/2")}, "url", 2, false},
+		{"sentinel", []func(*Pool) error{Password("secret"), Address("rediss+sentinel://s1,s2/master/4")}, "secret", 4, true},
+	} {
+		p, err := New(c.options...)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if p.password != c.password || p.db != c.db || p.useTLS != c.useTLS {
+			t.Errorf("%s: password %q, db %d, tls %v", c.name, p.password, p.db, p.useTLS)
+		}
+	}
+}
+
+// fakeServer answers RESP commands by handle and records them, replies are
+// strings, errors, integers, nil and slices of them.
+type fakeServer struct {
+	l      net.Listener
+	handle func(a []string) interface{}
+
+	mu   sync.Mutex
+	cmds []string
+}
+
+func newFakeServer(t *testing.T, handle func(a []string) interface{}) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{l: l, handle: handle}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *fakeServer) close() {
+	_ = s.l.Close()
+}
+
+// commands returns names of commands received so far.
+func (s *fakeServer) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(c)
+	}
+}
+
+func (s *fakeServer) serveConn(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	for {
+		a, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.cmds = append(s.cmds, strings.ToUpper(a[0]))
+		s.mu.Unlock()
+
+		writeReply(w, s.handle(a))
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	_, err := fmt.Fscanf(r, "*%d\r\n", &n)
+	if err != nil {
+		return nil, err
+	}
+	a := make([]string, n)
+	for i := range a {
+		var l int
+		_, err = fmt.Fscanf(r, "$%d\r\n", &l)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, l+2)
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = string(b[:l])
+	}
+	return a, nil
+}
+
+func writeReply(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case redis.Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for i := range v {
+			writeReply(w, v[i])
+		}
+	}
+}
+
+// TestSentinelFailover promotes replica behind sentinel and checks that
+// idle connection to demoted master fails ROLE test on borrow and the next
+// one is dialed to the new master. Sentinel and servers have own passwords.
+func TestSentinelFailover(t *testing.T) {
+	var mu sync.Mutex
+	var master string
+
+	node := func(name string, addr *string) func([]string) interface{} {
+		return func(a []string) interface{} {
+			switch strings.ToUpper(a[0]) {
+			case "AUTH":
+				if a[1] != "data" {
+					return redis.Error("ERR invalid password")
+				}
+				return "OK"
+			case "SELECT":
+				return "OK"
+			case "ROLE":
+				mu.Lock()
+				defer mu.Unlock()
+				if master == *addr {
+					return []interface{}{"master", 0, []interface{}{}}
+				}
+				return []interface{}{"slave", "127.0.0.1", 0, "connected", 0}
+			case "GET":
+				return name
+			}
+			return redis.Error("ERR unknown command")
+		}
+	}
+
+	var addrA, addrB string
+	a := newFakeServer(t, node("a", &addrA))
+	defer a.close()
+	b := newFakeServer(t, node("b", &addrB))
+	defer b.close()
+	addrA, addrB = a.addr(), b.addr()
+	master = addrA
+
+	s := newFakeServer(t, func(a []string) interface{} {
+		switch strings.ToUpper(a[0]) {
+		case "AUTH":
+			if a[1] != "sentinel" {
+				return redis.Error("ERR invalid password")
+			}
+			return "OK"
+		case "SENTINEL":
+			if len(a) != 3 || a[2] != "mymaster" {
+				return nil
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			h, p, _ := net.SplitHostPort(master)
+			return []interface{}{h, p}
+		}
+		return redis.Error("ERR unknown command")
+	})
+	defer s.close()
+
+	p, err := New(
+		Address("redis+sentinel://"+s.addr()+"/mymaster/1?test_role=true&sentinel_password=sentinel"),
+		Password("data"),
+		MaxIdle(1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	get := func() string {
+		c := p.Get()
+		defer c.Close()
+		v, err := redis.String(c.Do("GET", "k"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if v := get(); v != "a" {
+		t.Fatalf("before failover got %s", v)
+	}
+
+	mu.Lock()
+	master = addrB
+	mu.Unlock()
+
+	if v := get(); v != "b" {
+		t.Fatalf("after failover got %s", v)
+	}
+
+	if v := strings.Join(a.commands(), " "); v != "AUTH SELECT GET ROLE" {
+		t.Errorf("old master got %s", v)
+	}
+	if v := strings.Join(b.commands(), " "); v != "AUTH SELECT GET" {
+		t.Errorf("new master got %s", v)
+	}
+	if v := strings.Join(s.commands(), " "); v != "AUTH SENTINEL AUTH SENTINEL" {
+		t.Errorf("sentinel got %s", v)
+	}
+}
@@ -0,0 +1,82 @@
+package redispool
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// sentinel resolves address of master by asking the list of sentinels.
+type sentinel struct {
+	mu    sync.Mutex
+	name  string
+	addrs []string
+}
+
+func newSentinel(name string, addrs ...string) (*sentinel, error) {
+	if name == "" {
+		return nil, fmt.Errorf("empty sentinel master name")
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("empty sentinel address list")
+	}
+	return &sentinel{name: name, addrs: addrs}, nil
+}
+
+// masterAddr asks sentinels in turn for the current master, the sentinel
+// which answered is moved to the head of the list.
+func (s *sentinel) masterAddr(options ...redis.DialOption) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lastErr error
+	for i := range s.addrs {
+		c, err := redis.Dial("tcp", s.addrs[i], options...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		v, err := redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", s.name))
+		_ = c.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(v) != 2 {
+			lastErr = fmt.Errorf("sentinel %s: unknown master %s", s.addrs[i], s.name)
+			continue
+		}
+
+		if i > 0 {
+			s.addrs[0], s.addrs[i] = s.addrs[i], s.addrs[0]
+		}
+		return net.JoinHostPort(v[0], v[1]), nil
+	}
+
+	return "", fmt.Errorf("sentinel: no master %s found: %v", s.name, lastErr)
+}
+
+// testRole checks that connection is to the node with the given role.
+func testRole(c redis.Conn, role string) error {
+	v, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(v) == 0 {
+		return fmt.Errorf("empty role reply")
+	}
+
+	r, err := redis.String(v[0], nil)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(r, role) {
+		return fmt.Errorf("role is %s, want %s", r, role)
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"crypto/sha1"
+	"crypto/subtle"
 	"fmt"
 	"io"
 	"net/http"
@@ -21,7 +22,12 @@ import (
 type handler struct {
 	api    map[string]http.Handler
 	rdb    rediser
+	rdr    rediser
+	rds    rediser
+	live   liveCache
+	ns     string
 	log    logger.Logger
+	admin  string
 	err404 http.Handler
 	err405 http.Handler
 }
@@ -42,6 +48,21 @@ func (h *handler) prepareAPI() *handler {
 		mdware.Tail(h.log),
 	)
 
+	// server-sent events are flushed as is, without gzip
+	live := mdware.NewPipe(8)
+
+	live.BeforeJoin(
+		mdware.Head(uuid),
+		mdware.Auth(auth),
+		mdware.Body,
+	)
+
+	live.AfterJoin(
+		mdware.Resp,
+		mdware.Fail,
+		mdware.Tail(h.log),
+	)
+
 	h.api = map[string]http.Handler{
 		"GET /":            pipe.Join(mdware.Exec(home)),
 		"GET /help":        pipe.Join(mdware.Exec(help())),
@@ -51,181 +72,210 @@ func (h *handler) prepareAPI() *handler {
 		"POST /redis/ping": pipe.Join(mdware.Exec(ping(h.rdb))),
 
 		// FIXME GET POST /
-		"POST /get-class-atc-sync":       pipe.Join(mdware.Exec(exec(h, getClassATCSync))),
-		"POST /get-class-atc-root":       pipe.Join(mdware.Exec(exec(h, getClassATCRoot))),
-		"POST /get-class-atc-next":       pipe.Join(mdware.Exec(exec(h, getClassATCNext))),
-		"POST /get-class-atc-next-by-id": pipe.Join(mdware.Exec(exec(h, getClassATCNextByID))),
-		"POST /get-class-atc-path-by-id": pipe.Join(mdware.Exec(exec(h, getClassATCPathByID))),
-		"POST /get-class-atc":            pipe.Join(mdware.Exec(exec(h, getClassATC))),
-		"POST /set-class-atc":            pipe.Join(mdware.Exec(exec(h, setClassATC))),
-		"POST /del-class-atc":            pipe.Join(mdware.Exec(exec(h, delClassATC))),
-
-		"POST /get-class-nfc-sync":       pipe.Join(mdware.Exec(exec(h, getClassNFCSync))),
-		"POST /get-class-nfc-root":       pipe.Join(mdware.Exec(exec(h, getClassNFCRoot))),
-		"POST /get-class-nfc-next":       pipe.Join(mdware.Exec(exec(h, getClassNFCNext))),
-		"POST /get-class-nfc-next-by-id": pipe.Join(mdware.Exec(exec(h, getClassNFCNextByID))),
-		"POST /get-class-nfc-path-by-id": pipe.Join(mdware.Exec(exec(h, getClassNFCPathByID))),
-		"POST /get-class-nfc":            pipe.Join(mdware.Exec(exec(h, getClassNFC))),
-		"POST /set-class-nfc":            pipe.Join(mdware.Exec(exec(h, setClassNFC))),
-		"POST /del-class-nfc":            pipe.Join(mdware.Exec(exec(h, delClassNFC))),
-
-		"POST /get-class-fsc-sync":       pipe.Join(mdware.Exec(exec(h, getClassFSCSync))),
-		"POST /get-class-fsc-root":       pipe.Join(mdware.Exec(exec(h, getClassFSCRoot))),
-		"POST /get-class-fsc-next":       pipe.Join(mdware.Exec(exec(h, getClassFSCNext))),
-		"POST /get-class-fsc-next-by-id": pipe.Join(mdware.Exec(exec(h, getClassFSCNextByID))),
-		"POST /get-class-fsc-path-by-id": pipe.Join(mdware.Exec(exec(h, getClassFSCPathByID))),
-		"POST /get-class-fsc":            pipe.Join(mdware.Exec(exec(h, getClassFSC))),
-		"POST /set-class-fsc":            pipe.Join(mdware.Exec(exec(h, setClassFSC))),
-		"POST /del-class-fsc":            pipe.Join(mdware.Exec(exec(h, delClassFSC))),
-
-		"POST /get-class-bfc-sync":       pipe.Join(mdware.Exec(exec(h, getClassBFCSync))),
-		"POST /get-class-bfc-root":       pipe.Join(mdware.Exec(exec(h, getClassBFCRoot))),
-		"POST /get-class-bfc-next":       pipe.Join(mdware.Exec(exec(h, getClassBFCNext))),
-		"POST /get-class-bfc-next-by-id": pipe.Join(mdware.Exec(exec(h, getClassBFCNextByID))),
-		"POST /get-class-bfc-path-by-id": pipe.Join(mdware.Exec(exec(h, getClassBFCPathByID))),
-		"POST /get-class-bfc":            pipe.Join(mdware.Exec(exec(h, getClassBFC))),
-		"POST /set-class-bfc":            pipe.Join(mdware.Exec(exec(h, setClassBFC))),
-		"POST /del-class-bfc":            pipe.Join(mdware.Exec(exec(h, delClassBFC))),
-
-		"POST /get-class-cfc-sync":       pipe.Join(mdware.Exec(exec(h, getClassCFCSync))),
-		"POST /get-class-cfc-root":       pipe.Join(mdware.Exec(exec(h, getClassCFCRoot))),
-		"POST /get-class-cfc-next":       pipe.Join(mdware.Exec(exec(h, getClassCFCNext))),
-		"POST /get-class-cfc-next-by-id": pipe.Join(mdware.Exec(exec(h, getClassCFCNextByID))),
-		"POST /get-class-cfc-path-by-id": pipe.Join(mdware.Exec(exec(h, getClassCFCPathByID))),
-		"POST /get-class-cfc":            pipe.Join(mdware.Exec(exec(h, getClassCFC))),
-		"POST /set-class-cfc":            pipe.Join(mdware.Exec(exec(h, setClassCFC))),
-		"POST /del-class-cfc":            pipe.Join(mdware.Exec(exec(h, delClassCFC))),
-
-		"POST /get-class-mpc-sync":       pipe.Join(mdware.Exec(exec(h, getClassMPCSync))),
-		"POST /get-class-mpc-root":       pipe.Join(mdware.Exec(exec(h, getClassMPCRoot))),
-		"POST /get-class-mpc-next":       pipe.Join(mdware.Exec(exec(h, getClassMPCNext))),
-		"POST /get-class-mpc-next-by-id": pipe.Join(mdware.Exec(exec(h, getClassMPCNextByID))),
-		"POST /get-class-mpc-path-by-id": pipe.Join(mdware.Exec(exec(h, getClassMPCPathByID))),
-		"POST /get-class-mpc":            pipe.Join(mdware.Exec(exec(h, getClassMPC))),
-		"POST /set-class-mpc":            pipe.Join(mdware.Exec(exec(h, setClassMPC))),
-		"POST /del-class-mpc":            pipe.Join(mdware.Exec(exec(h, delClassMPC))),
-
-		"POST /get-class-csc-sync":       pipe.Join(mdware.Exec(exec(h, getClassCSCSync))),
-		"POST /get-class-csc-root":       pipe.Join(mdware.Exec(exec(h, getClassCSCRoot))),
-		"POST /get-class-csc-next":       pipe.Join(mdware.Exec(exec(h, getClassCSCNext))),
-		"POST /get-class-csc-next-by-id": pipe.Join(mdware.Exec(exec(h, getClassCSCNextByID))),
-		"POST /get-class-csc-path-by-id": pipe.Join(mdware.Exec(exec(h, getClassCSCPathByID))),
-		"POST /get-class-csc":            pipe.Join(mdware.Exec(exec(h, getClassCSC))),
-		"POST /set-class-csc":            pipe.Join(mdware.Exec(exec(h, setClassCSC))),
-		"POST /del-class-csc":            pipe.Join(mdware.Exec(exec(h, delClassCSC))),
-
-		"POST /get-class-icd-sync":       pipe.Join(mdware.Exec(exec(h, getClassICDSync))),
-		"POST /get-class-icd-root":       pipe.Join(mdware.Exec(exec(h, getClassICDRoot))),
-		"POST /get-class-icd-next":       pipe.Join(mdware.Exec(exec(h, getClassICDNext))),
-		"POST /get-class-icd-next-by-id": pipe.Join(mdware.Exec(exec(h, getClassICDNextByID))),
-		"POST /get-class-icd-path-by-id": pipe.Join(mdware.Exec(exec(h, getClassICDPathByID))),
-		"POST /get-class-icd":            pipe.Join(mdware.Exec(exec(h, getClassICD))),
-		"POST /set-class-icd":            pipe.Join(mdware.Exec(exec(h, setClassICD))),
-		"POST /del-class-icd":            pipe.Join(mdware.Exec(exec(h, delClassICD))),
-
-		"POST /get-inn-sync":    pipe.Join(mdware.Exec(exec(h, getINNSync))),
-		"POST /get-inn-abcd":    pipe.Join(mdware.Exec(exec(h, getINNAbcd))),
-		"POST /get-inn-abcd-ls": pipe.Join(mdware.Exec(exec(h, getINNAbcdLs))),
-		"POST /get-inn-list":    pipe.Join(mdware.Exec(exec(h, getINNList))),
-		"POST /get-inn-list-az": pipe.Join(mdware.Exec(exec(h, getINNListAZ))),
-		"POST /get-inn":         pipe.Join(mdware.Exec(exec(h, getINN))),
-		"POST /set-inn":         pipe.Join(mdware.Exec(exec(h, setINN))),
-		"POST /del-inn":         pipe.Join(mdware.Exec(exec(h, delINN))),
-
-		"POST /get-maker-sync":    pipe.Join(mdware.Exec(exec(h, getMakerSync))),
-		"POST /get-maker-abcd":    pipe.Join(mdware.Exec(exec(h, getMakerAbcd))),
-		"POST /get-maker-abcd-ls": pipe.Join(mdware.Exec(exec(h, getMakerAbcdLs))),
-		"POST /get-maker-list":    pipe.Join(mdware.Exec(exec(h, getMakerList))),
-		"POST /get-maker-list-az": pipe.Join(mdware.Exec(exec(h, getMakerListAZ))),
-		"POST /get-maker":         pipe.Join(mdware.Exec(exec(h, getMaker))),
-		"POST /set-maker":         pipe.Join(mdware.Exec(exec(h, setMaker))),
-		"POST /del-maker":         pipe.Join(mdware.Exec(exec(h, delMaker))),
-
-		"POST /get-drug-sync": pipe.Join(mdware.Exec(exec(h, getDrugSync))),
-		"POST /get-drug":      pipe.Join(mdware.Exec(exec(h, getDrug))),
-		"POST /get-drug-list": pipe.Join(mdware.Exec(exec(h, getDrugList))),
-		"POST /set-drug":      pipe.Join(mdware.Exec(exec(h, setDrug))),
-		"POST /set-drug-sale": pipe.Join(mdware.Exec(exec(h, setDrugSale))),
-		"POST /del-drug":      pipe.Join(mdware.Exec(exec(h, delDrug))),
-
-		"POST /get-spec-act-sync":      pipe.Join(mdware.Exec(exec(h, getSpecACTSync))),
-		"POST /get-spec-act-abcd":      pipe.Join(mdware.Exec(exec(h, getSpecACTAbcd))),
-		"POST /get-spec-act-abcd-ls":   pipe.Join(mdware.Exec(exec(h, getSpecACTAbcdLs))),
-		"POST /get-spec-act-list":      pipe.Join(mdware.Exec(exec(h, getSpecACTList))),
-		"POST /get-spec-act-list-az":   pipe.Join(mdware.Exec(exec(h, getSpecACTListAZ))),
-		"POST /get-spec-act":           pipe.Join(mdware.Exec(exec(h, getSpecACT))),
-		"POST /get-spec-act-with-deps": pipe.Join(mdware.Exec(exec(h, getSpecACTWithDeps))),
-		"POST /set-spec-act":           pipe.Join(mdware.Exec(exec(h, setSpecACT))),
-		"POST /del-spec-act":           pipe.Join(mdware.Exec(exec(h, delSpecACT))),
-
-		"POST /get-spec-inf-sync":                      pipe.Join(mdware.Exec(exec(h, getSpecINFSync))),
-		"POST /get-spec-inf-abcd":                      pipe.Join(mdware.Exec(exec(h, getSpecINFAbcd))),
-		"POST /get-spec-inf-abcd-ls":                   pipe.Join(mdware.Exec(exec(h, getSpecINFAbcdLs))),
-		"POST /get-spec-inf-list":                      pipe.Join(mdware.Exec(exec(h, getSpecINFList))),
-		"POST /get-spec-inf-list-az":                   pipe.Join(mdware.Exec(exec(h, getSpecINFListAZ))),
-		"POST /get-spec-inf-list-by-id-class-atc":      pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassATC))),
-		"POST /get-spec-inf-list-by-id-class-atc-deep": pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassATCDeep))),
-		"POST /get-spec-inf-list-by-id-class-nfc":      pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassNFC))),
-		"POST /get-spec-inf-list-by-id-class-nfc-deep": pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassNFCDeep))),
-		"POST /get-spec-inf-list-by-id-class-fsc":      pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassFSC))),
-		"POST /get-spec-inf-list-by-id-class-fsc-deep": pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassFSCDeep))),
-		"POST /get-spec-inf-list-by-id-class-bfc":      pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassBFC))),
-		"POST /get-spec-inf-list-by-id-class-bfc-deep": pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassBFCDeep))),
-		"POST /get-spec-inf-list-by-id-class-cfc":      pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassCFC))),
-		"POST /get-spec-inf-list-by-id-class-cfc-deep": pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassCFCDeep))),
-		"POST /get-spec-inf-list-by-id-class-mpc":      pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassMPC))),
-		"POST /get-spec-inf-list-by-id-class-mpc-deep": pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassMPCDeep))),
-		"POST /get-spec-inf-list-by-id-class-csc":      pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassCSC))),
-		"POST /get-spec-inf-list-by-id-class-csc-deep": pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassCSCDeep))),
-		"POST /get-spec-inf-list-by-id-class-icd":      pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassICD))),
-		"POST /get-spec-inf-list-by-id-class-icd-deep": pipe.Join(mdware.Exec(exec(h, getSpecINFListByClassICDDeep))),
-		"POST /get-spec-inf-list-by-id-inn":            pipe.Join(mdware.Exec(exec(h, getSpecINFListByINN))),
-		"POST /get-spec-inf-list-by-id-maker":          pipe.Join(mdware.Exec(exec(h, getSpecINFListByMaker))),
-		"POST /get-spec-inf-list-by-id-drug":           pipe.Join(mdware.Exec(exec(h, getSpecINFListByDrug))),
-		"POST /get-spec-inf-list-by-id-spec-act":       pipe.Join(mdware.Exec(exec(h, getSpecINFListBySpecACT))),
-		"POST /get-spec-inf-list-by-id-spec-dec":       pipe.Join(mdware.Exec(exec(h, getSpecINFListBySpecDEC))),
-		"POST /get-spec-inf":                           pipe.Join(mdware.Exec(exec(h, getSpecINF))),
-		"POST /get-spec-inf-with-deps":                 pipe.Join(mdware.Exec(exec(h, getSpecINFWithDeps))),
-		"POST /set-spec-inf":                           pipe.Join(mdware.Exec(exec(h, setSpecINF))),
-		"POST /set-spec-inf-sale":                      pipe.Join(mdware.Exec(exec(h, setSpecINFSale))),
-		"POST /del-spec-inf":                           pipe.Join(mdware.Exec(exec(h, delSpecINF))),
-
-		"POST /get-spec-dec-sync":                      pipe.Join(mdware.Exec(exec(h, getSpecDECSync))),
-		"POST /get-spec-dec-abcd":                      pipe.Join(mdware.Exec(exec(h, getSpecDECAbcd))),
-		"POST /get-spec-dec-abcd-ls":                   pipe.Join(mdware.Exec(exec(h, getSpecDECAbcdLs))),
-		"POST /get-spec-dec-list":                      pipe.Join(mdware.Exec(exec(h, getSpecDECList))),
-		"POST /get-spec-dec-list-az":                   pipe.Join(mdware.Exec(exec(h, getSpecDECListAZ))),
-		"POST /get-spec-dec-list-by-id-class-atc":      pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassATC))),
-		"POST /get-spec-dec-list-by-id-class-atc-deep": pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassATCDeep))),
-		"POST /get-spec-dec-list-by-id-class-nfc":      pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassNFC))),
-		"POST /get-spec-dec-list-by-id-class-nfc-deep": pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassNFCDeep))),
-		"POST /get-spec-dec-list-by-id-class-fsc":      pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassFSC))),
-		"POST /get-spec-dec-list-by-id-class-fsc-deep": pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassFSCDeep))),
-		"POST /get-spec-dec-list-by-id-class-bfc":      pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassBFC))),
-		"POST /get-spec-dec-list-by-id-class-bfc-deep": pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassBFCDeep))),
-		"POST /get-spec-dec-list-by-id-class-cfc":      pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassCFC))),
-		"POST /get-spec-dec-list-by-id-class-cfc-deep": pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassCFCDeep))),
-		"POST /get-spec-dec-list-by-id-class-mpc":      pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassMPC))),
-		"POST /get-spec-dec-list-by-id-class-mpc-deep": pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassMPCDeep))),
-		"POST /get-spec-dec-list-by-id-class-csc":      pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassCSC))),
-		"POST /get-spec-dec-list-by-id-class-csc-deep": pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassCSCDeep))),
-		"POST /get-spec-dec-list-by-id-class-icd":      pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassICD))),
-		"POST /get-spec-dec-list-by-id-class-icd-deep": pipe.Join(mdware.Exec(exec(h, getSpecDECListByClassICDDeep))),
-		"POST /get-spec-dec-list-by-id-inn":            pipe.Join(mdware.Exec(exec(h, getSpecDECListByINN))),
-		"POST /get-spec-dec-list-by-id-maker":          pipe.Join(mdware.Exec(exec(h, getSpecDECListByMaker))),
-		"POST /get-spec-dec-list-by-id-drug":           pipe.Join(mdware.Exec(exec(h, getSpecDECListByDrug))),
-		"POST /get-spec-dec-list-by-id-spec-act":       pipe.Join(mdware.Exec(exec(h, getSpecDECListBySpecACT))),
-		"POST /get-spec-dec-list-by-id-spec-inf":       pipe.Join(mdware.Exec(exec(h, getSpecDECListBySpecINF))),
-		"POST /get-spec-dec":                           pipe.Join(mdware.Exec(exec(h, getSpecDEC))),
-		"POST /get-spec-dec-with-deps":                 pipe.Join(mdware.Exec(exec(h, getSpecDECWithDeps))),
-		"POST /set-spec-dec":                           pipe.Join(mdware.Exec(exec(h, setSpecDEC))),
-		"POST /set-spec-dec-sale":                      pipe.Join(mdware.Exec(exec(h, setSpecDECSale))),
-		"POST /del-spec-dec":                           pipe.Join(mdware.Exec(exec(h, delSpecDEC))),
-
-		"POST /get-sugg-by-text": pipe.Join(mdware.Exec(exec(h, listSugg))),
-		"POST /get-list-by-sugg": pipe.Join(mdware.Exec(exec(h, findSugg))),
-
-		//"POST /run-hotfix": pipe.Join(mdware.Exec(exec(h, runHotfix))),
+		"POST /get-class-atc-sync":       pipe.Join(mdware.Exec(read(h, getClassATCSync))),
+		"POST /get-class-atc-root":       pipe.Join(mdware.Exec(read(h, getClassATCRoot))),
+		"POST /get-class-atc-next":       pipe.Join(mdware.Exec(read(h, getClassATCNext))),
+		"POST /get-class-atc-next-by-id": pipe.Join(mdware.Exec(read(h, getClassATCNextByID))),
+		"POST /get-class-atc-path-by-id": pipe.Join(mdware.Exec(read(h, getClassATCPathByID))),
+		"POST /get-class-atc":            pipe.Join(mdware.Exec(read(h, getClassATC))),
+		"POST /set-class-atc":            pipe.Join(mdware.Exec(write(h, setClassATC))),
+		"POST /del-class-atc":            pipe.Join(mdware.Exec(write(h, delClassATC))),
+		"POST /restore-class-atc":        pipe.Join(mdware.Exec(write(h, restoreClassATC))),
+
+		"POST /get-class-nfc-sync":       pipe.Join(mdware.Exec(read(h, getClassNFCSync))),
+		"POST /get-class-nfc-root":       pipe.Join(mdware.Exec(read(h, getClassNFCRoot))),
+		"POST /get-class-nfc-next":       pipe.Join(mdware.Exec(read(h, getClassNFCNext))),
+		"POST /get-class-nfc-next-by-id": pipe.Join(mdware.Exec(read(h, getClassNFCNextByID))),
+		"POST /get-class-nfc-path-by-id": pipe.Join(mdware.Exec(read(h, getClassNFCPathByID))),
+		"POST /get-class-nfc":            pipe.Join(mdware.Exec(read(h, getClassNFC))),
+		"POST /set-class-nfc":            pipe.Join(mdware.Exec(write(h, setClassNFC))),
+		"POST /del-class-nfc":            pipe.Join(mdware.Exec(write(h, delClassNFC))),
+		"POST /restore-class-nfc":        pipe.Join(mdware.Exec(write(h, restoreClassNFC))),
+
+		"POST /get-class-fsc-sync":       pipe.Join(mdware.Exec(read(h, getClassFSCSync))),
+		"POST /get-class-fsc-root":       pipe.Join(mdware.Exec(read(h, getClassFSCRoot))),
+		"POST /get-class-fsc-next":       pipe.Join(mdware.Exec(read(h, getClassFSCNext))),
+		"POST /get-class-fsc-next-by-id": pipe.Join(mdware.Exec(read(h, getClassFSCNextByID))),
+		"POST /get-class-fsc-path-by-id": pipe.Join(mdware.Exec(read(h, getClassFSCPathByID))),
+		"POST /get-class-fsc":            pipe.Join(mdware.Exec(read(h, getClassFSC))),
+		"POST /set-class-fsc":            pipe.Join(mdware.Exec(write(h, setClassFSC))),
+		"POST /del-class-fsc":            pipe.Join(mdware.Exec(write(h, delClassFSC))),
+		"POST /restore-class-fsc":        pipe.Join(mdware.Exec(write(h, restoreClassFSC))),
+
+		"POST /get-class-bfc-sync":       pipe.Join(mdware.Exec(read(h, getClassBFCSync))),
+		"POST /get-class-bfc-root":       pipe.Join(mdware.Exec(read(h, getClassBFCRoot))),
+		"POST /get-class-bfc-next":       pipe.Join(mdware.Exec(read(h, getClassBFCNext))),
+		"POST /get-class-bfc-next-by-id": pipe.Join(mdware.Exec(read(h, getClassBFCNextByID))),
+		"POST /get-class-bfc-path-by-id": pipe.Join(mdware.Exec(read(h, getClassBFCPathByID))),
+		"POST /get-class-bfc":            pipe.Join(mdware.Exec(read(h, getClassBFC))),
+		"POST /set-class-bfc":            pipe.Join(mdware.Exec(write(h, setClassBFC))),
+		"POST /del-class-bfc":            pipe.Join(mdware.Exec(write(h, delClassBFC))),
+		"POST /restore-class-bfc":        pipe.Join(mdware.Exec(write(h, restoreClassBFC))),
+
+		"POST /get-class-cfc-sync":       pipe.Join(mdware.Exec(read(h, getClassCFCSync))),
+		"POST /get-class-cfc-root":       pipe.Join(mdware.Exec(read(h, getClassCFCRoot))),
+		"POST /get-class-cfc-next":       pipe.Join(mdware.Exec(read(h, getClassCFCNext))),
+		"POST /get-class-cfc-next-by-id": pipe.Join(mdware.Exec(read(h, getClassCFCNextByID))),
+		"POST /get-class-cfc-path-by-id": pipe.Join(mdware.Exec(read(h, getClassCFCPathByID))),
+		"POST /get-class-cfc":            pipe.Join(mdware.Exec(read(h, getClassCFC))),
+		"POST /set-class-cfc":            pipe.Join(mdware.Exec(write(h, setClassCFC))),
+		"POST /del-class-cfc":            pipe.Join(mdware.Exec(write(h, delClassCFC))),
+		"POST /restore-class-cfc":        pipe.Join(mdware.Exec(write(h, restoreClassCFC))),
+
+		"POST /get-class-mpc-sync":       pipe.Join(mdware.Exec(read(h, getClassMPCSync))),
+		"POST /get-class-mpc-root":       pipe.Join(mdware.Exec(read(h, getClassMPCRoot))),
+		"POST /get-class-mpc-next":       pipe.Join(mdware.Exec(read(h, getClassMPCNext))),
+		"POST /get-class-mpc-next-by-id": pipe.Join(mdware.Exec(read(h, getClassMPCNextByID))),
+		"POST /get-class-mpc-path-by-id": pipe.Join(mdware.Exec(read(h, getClassMPCPathByID))),
+		"POST /get-class-mpc":            pipe.Join(mdware.Exec(read(h, getClassMPC))),
+		"POST /set-class-mpc":            pipe.Join(mdware.Exec(write(h, setClassMPC))),
+		"POST /del-class-mpc":            pipe.Join(mdware.Exec(write(h, delClassMPC))),
+		"POST /restore-class-mpc":        pipe.Join(mdware.Exec(write(h, restoreClassMPC))),
+
+		"POST /get-class-csc-sync":       pipe.Join(mdware.Exec(read(h, getClassCSCSync))),
+		"POST /get-class-csc-root":       pipe.Join(mdware.Exec(read(h, getClassCSCRoot))),
+		"POST /get-class-csc-next":       pipe.Join(mdware.Exec(read(h, getClassCSCNext))),
+		"POST /get-class-csc-next-by-id": pipe.Join(mdware.Exec(read(h, getClassCSCNextByID))),
+		"POST /get-class-csc-path-by-id": pipe.Join(mdware.Exec(read(h, getClassCSCPathByID))),
+		"POST /get-class-csc":            pipe.Join(mdware.Exec(read(h, getClassCSC))),
+		"POST /set-class-csc":            pipe.Join(mdware.Exec(write(h, setClassCSC))),
+		"POST /del-class-csc":            pipe.Join(mdware.Exec(write(h, delClassCSC))),
+		"POST /restore-class-csc":        pipe.Join(mdware.Exec(write(h, restoreClassCSC))),
+
+		"POST /get-class-icd-sync":       pipe.Join(mdware.Exec(read(h, getClassICDSync))),
+		"POST /get-class-icd-root":       pipe.Join(mdware.Exec(read(h, getClassICDRoot))),
+		"POST /get-class-icd-next":       pipe.Join(mdware.Exec(read(h, getClassICDNext))),
+		"POST /get-class-icd-next-by-id": pipe.Join(mdware.Exec(read(h, getClassICDNextByID))),
+		"POST /get-class-icd-path-by-id": pipe.Join(mdware.Exec(read(h, getClassICDPathByID))),
+		"POST /get-class-icd":            pipe.Join(mdware.Exec(read(h, getClassICD))),
+		"POST /set-class-icd":            pipe.Join(mdware.Exec(write(h, setClassICD))),
+		"POST /del-class-icd":            pipe.Join(mdware.Exec(write(h, delClassICD))),
+		"POST /restore-class-icd":        pipe.Join(mdware.Exec(write(h, restoreClassICD))),
+
+		"POST /get-inn-sync":    pipe.Join(mdware.Exec(read(h, getINNSync))),
+		"POST /get-inn-abcd":    pipe.Join(mdware.Exec(read(h, getINNAbcd))),
+		"POST /get-inn-abcd-ls": pipe.Join(mdware.Exec(read(h, getINNAbcdLs))),
+		"POST /get-inn-list":    pipe.Join(mdware.Exec(read(h, getINNList))),
+		"POST /get-inn-list-az": pipe.Join(mdware.Exec(read(h, getINNListAZ))),
+		"POST /get-inn":         pipe.Join(mdware.Exec(read(h, getINN))),
+		"POST /set-inn":         pipe.Join(mdware.Exec(write(h, setINN))),
+		"POST /del-inn":         pipe.Join(mdware.Exec(write(h, delINN))),
+		"POST /restore-inn":     pipe.Join(mdware.Exec(write(h, restoreINN))),
+
+		"POST /get-maker-sync":    pipe.Join(mdware.Exec(read(h, getMakerSync))),
+		"POST /get-maker-abcd":    pipe.Join(mdware.Exec(read(h, getMakerAbcd))),
+		"POST /get-maker-abcd-ls": pipe.Join(mdware.Exec(read(h, getMakerAbcdLs))),
+		"POST /get-maker-list":    pipe.Join(mdware.Exec(read(h, getMakerList))),
+		"POST /get-maker-list-az": pipe.Join(mdware.Exec(read(h, getMakerListAZ))),
+		"POST /get-maker":         pipe.Join(mdware.Exec(read(h, getMaker))),
+		"POST /set-maker":         pipe.Join(mdware.Exec(write(h, setMaker))),
+		"POST /del-maker":         pipe.Join(mdware.Exec(write(h, delMaker))),
+		"POST /restore-maker":     pipe.Join(mdware.Exec(write(h, restoreMaker))),
+
+		"POST /get-drug-sync": pipe.Join(mdware.Exec(read(h, getDrugSync))),
+		"POST /get-drug":      pipe.Join(mdware.Exec(read(h, getDrug))),
+		"POST /get-drug-list": pipe.Join(mdware.Exec(read(h, getDrugList))),
+		"POST /set-drug":      pipe.Join(mdware.Exec(write(h, setDrug))),
+		"POST /set-drug-sale": pipe.Join(mdware.Exec(write(h, setDrugSale))),
+		"POST /del-drug":      pipe.Join(mdware.Exec(write(h, delDrug))),
+		"POST /restore-drug":  pipe.Join(mdware.Exec(write(h, restoreDrug))),
+
+		"POST /get-spec-act-sync":      pipe.Join(mdware.Exec(read(h, getSpecACTSync))),
+		"POST /get-spec-act-abcd":      pipe.Join(mdware.Exec(read(h, getSpecACTAbcd))),
+		"POST /get-spec-act-abcd-ls":   pipe.Join(mdware.Exec(read(h, getSpecACTAbcdLs))),
+		"POST /get-spec-act-list":      pipe.Join(mdware.Exec(read(h, getSpecACTList))),
+		"POST /get-spec-act-list-az":   pipe.Join(mdware.Exec(read(h, getSpecACTListAZ))),
+		"POST /get-spec-act":           pipe.Join(mdware.Exec(read(h, getSpecACT))),
+		"POST /get-spec-act-with-deps": pipe.Join(mdware.Exec(read(h, getSpecACTWithDeps))),
+		"POST /set-spec-act":           pipe.Join(mdware.Exec(write(h, setSpecACT))),
+		"POST /del-spec-act":           pipe.Join(mdware.Exec(write(h, delSpecACT))),
+		"POST /restore-spec-act":       pipe.Join(mdware.Exec(write(h, restoreSpecACT))),
+
+		"POST /get-spec-inf-sync":                      pipe.Join(mdware.Exec(read(h, getSpecINFSync))),
+		"POST /get-spec-inf-abcd":                      pipe.Join(mdware.Exec(read(h, getSpecINFAbcd))),
+		"POST /get-spec-inf-abcd-ls":                   pipe.Join(mdware.Exec(read(h, getSpecINFAbcdLs))),
+		"POST /get-spec-inf-list":                      pipe.Join(mdware.Exec(read(h, getSpecINFList))),
+		"POST /get-spec-inf-list-az":                   pipe.Join(mdware.Exec(read(h, getSpecINFListAZ))),
+		"POST /get-spec-inf-list-by-id-class-atc":      pipe.Join(mdware.Exec(read(h, getSpecINFListByClassATC))),
+		"POST /get-spec-inf-list-by-id-class-atc-deep": pipe.Join(mdware.Exec(read(h, getSpecINFListByClassATCDeep))),
+		"POST /get-spec-inf-list-by-id-class-nfc":      pipe.Join(mdware.Exec(read(h, getSpecINFListByClassNFC))),
+		"POST /get-spec-inf-list-by-id-class-nfc-deep": pipe.Join(mdware.Exec(read(h, getSpecINFListByClassNFCDeep))),
+		"POST /get-spec-inf-list-by-id-class-fsc":      pipe.Join(mdware.Exec(read(h, getSpecINFListByClassFSC))),
+		"POST /get-spec-inf-list-by-id-class-fsc-deep": pipe.Join(mdware.Exec(read(h, getSpecINFListByClassFSCDeep))),
+		"POST /get-spec-inf-list-by-id-class-bfc":      pipe.Join(mdware.Exec(read(h, getSpecINFListByClassBFC))),
+		"POST /get-spec-inf-list-by-id-class-bfc-deep": pipe.Join(mdware.Exec(read(h, getSpecINFListByClassBFCDeep))),
+		"POST /get-spec-inf-list-by-id-class-cfc":      pipe.Join(mdware.Exec(read(h, getSpecINFListByClassCFC))),
+		"POST /get-spec-inf-list-by-id-class-cfc-deep": pipe.Join(mdware.Exec(read(h, getSpecINFListByClassCFCDeep))),
+		"POST /get-spec-inf-list-by-id-class-mpc":      pipe.Join(mdware.Exec(read(h, getSpecINFListByClassMPC))),
+		"POST /get-spec-inf-list-by-id-class-mpc-deep": pipe.Join(mdware.Exec(read(h, getSpecINFListByClassMPCDeep))),
+		"POST /get-spec-inf-list-by-id-class-csc":      pipe.Join(mdware.Exec(read(h, getSpecINFListByClassCSC))),
+		"POST /get-spec-inf-list-by-id-class-csc-deep": pipe.Join(mdware.Exec(read(h, getSpecINFListByClassCSCDeep))),
+		"POST /get-spec-inf-list-by-id-class-icd":      pipe.Join(mdware.Exec(read(h, getSpecINFListByClassICD))),
+		"POST /get-spec-inf-list-by-id-class-icd-deep": pipe.Join(mdware.Exec(read(h, getSpecINFListByClassICDDeep))),
+		"POST /get-spec-inf-list-by-id-inn":            pipe.Join(mdware.Exec(read(h, getSpecINFListByINN))),
+		"POST /get-spec-inf-list-by-id-maker":          pipe.Join(mdware.Exec(read(h, getSpecINFListByMaker))),
+		"POST /get-spec-inf-list-by-id-drug":           pipe.Join(mdware.Exec(read(h, getSpecINFListByDrug))),
+		"POST /get-spec-inf-list-by-id-spec-act":       pipe.Join(mdware.Exec(read(h, getSpecINFListBySpecACT))),
+		"POST /get-spec-inf-list-by-id-spec-dec":       pipe.Join(mdware.Exec(read(h, getSpecINFListBySpecDEC))),
+		"POST /get-spec-inf":                           pipe.Join(mdware.Exec(read(h, getSpecINF))),
+		"POST /get-spec-inf-with-deps":                 pipe.Join(mdware.Exec(read(h, getSpecINFWithDeps))),
+		"POST /set-spec-inf":                           pipe.Join(mdware.Exec(write(h, setSpecINF))),
+		"POST /set-spec-inf-sale":                      pipe.Join(mdware.Exec(write(h, setSpecINFSale))),
+		"POST /del-spec-inf":                           pipe.Join(mdware.Exec(write(h, delSpecINF))),
+		"POST /restore-spec-inf":                       pipe.Join(mdware.Exec(write(h, restoreSpecINF))),
+
+		"POST /get-spec-dec-sync":                      pipe.Join(mdware.Exec(read(h, getSpecDECSync))),
+		"POST /get-spec-dec-abcd":                      pipe.Join(mdware.Exec(read(h, getSpecDECAbcd))),
+		"POST /get-spec-dec-abcd-ls":                   pipe.Join(mdware.Exec(read(h, getSpecDECAbcdLs))),
+		"POST /get-spec-dec-list":                      pipe.Join(mdware.Exec(read(h, getSpecDECList))),
+		"POST /get-spec-dec-list-az":                   pipe.Join(mdware.Exec(read(h, getSpecDECListAZ))),
+		"POST /get-spec-dec-list-by-id-class-atc":      pipe.Join(mdware.Exec(read(h, getSpecDECListByClassATC))),
+		"POST /get-spec-dec-list-by-id-class-atc-deep": pipe.Join(mdware.Exec(read(h, getSpecDECListByClassATCDeep))),
+		"POST /get-spec-dec-list-by-id-class-nfc":      pipe.Join(mdware.Exec(read(h, getSpecDECListByClassNFC))),
+		"POST /get-spec-dec-list-by-id-class-nfc-deep": pipe.Join(mdware.Exec(read(h, getSpecDECListByClassNFCDeep))),
+		"POST /get-spec-dec-list-by-id-class-fsc":      pipe.Join(mdware.Exec(read(h, getSpecDECListByClassFSC))),
+		"POST /get-spec-dec-list-by-id-class-fsc-deep": pipe.Join(mdware.Exec(read(h, getSpecDECListByClassFSCDeep))),
+		"POST /get-spec-dec-list-by-id-class-bfc":      pipe.Join(mdware.Exec(read(h, getSpecDECListByClassBFC))),
+		"POST /get-spec-dec-list-by-id-class-bfc-deep": pipe.Join(mdware.Exec(read(h, getSpecDECListByClassBFCDeep))),
+		"POST /get-spec-dec-list-by-id-class-cfc":      pipe.Join(mdware.Exec(read(h, getSpecDECListByClassCFC))),
+		"POST /get-spec-dec-list-by-id-class-cfc-deep": pipe.Join(mdware.Exec(read(h, getSpecDECListByClassCFCDeep))),
+		"POST /get-spec-dec-list-by-id-class-mpc":      pipe.Join(mdware.Exec(read(h, getSpecDECListByClassMPC))),
+		"POST /get-spec-dec-list-by-id-class-mpc-deep": pipe.Join(mdware.Exec(read(h, getSpecDECListByClassMPCDeep))),
+		"POST /get-spec-dec-list-by-id-class-csc":      pipe.Join(mdware.Exec(read(h, getSpecDECListByClassCSC))),
+		"POST /get-spec-dec-list-by-id-class-csc-deep": pipe.Join(mdware.Exec(read(h, getSpecDECListByClassCSCDeep))),
+		"POST /get-spec-dec-list-by-id-class-icd":      pipe.Join(mdware.Exec(read(h, getSpecDECListByClassICD))),
+		"POST /get-spec-dec-list-by-id-class-icd-deep": pipe.Join(mdware.Exec(read(h, getSpecDECListByClassICDDeep))),
+		"POST /get-spec-dec-list-by-id-inn":            pipe.Join(mdware.Exec(read(h, getSpecDECListByINN))),
+		"POST /get-spec-dec-list-by-id-maker":          pipe.Join(mdware.Exec(read(h, getSpecDECListByMaker))),
+		"POST /get-spec-dec-list-by-id-drug":           pipe.Join(mdware.Exec(read(h, getSpecDECListByDrug))),
+		"POST /get-spec-dec-list-by-id-spec-act":       pipe.Join(mdware.Exec(read(h, getSpecDECListBySpecACT))),
+		"POST /get-spec-dec-list-by-id-spec-inf":       pipe.Join(mdware.Exec(read(h, getSpecDECListBySpecINF))),
+		"POST /get-spec-dec":                           pipe.Join(mdware.Exec(read(h, getSpecDEC))),
+		"POST /get-spec-dec-with-deps":                 pipe.Join(mdware.Exec(read(h, getSpecDECWithDeps))),
+		"POST /set-spec-dec":                           pipe.Join(mdware.Exec(write(h, setSpecDEC))),
+		"POST /set-spec-dec-sale":                      pipe.Join(mdware.Exec(write(h, setSpecDECSale))),
+		"POST /del-spec-dec":                           pipe.Join(mdware.Exec(write(h, delSpecDEC))),
+		"POST /restore-spec-dec":                       pipe.Join(mdware.Exec(write(h, restoreSpecDEC))),
+
+		"POST /get-sync":  pipe.Join(mdware.Exec(read(h, getSync))),
+		"GET /changes":    live.Join(mdware.Exec(stream(h, getChanges))),
+		"POST /get-audit": pipe.Join(mdware.Exec(read(h, getAudit))),
+
+		"POST /get-version-list": pipe.Join(mdware.Exec(read(h, getVersionList))),
+		"POST /get-version":      pipe.Join(mdware.Exec(read(h, getVersion))),
+		"POST /get-version-diff": pipe.Join(mdware.Exec(read(h, getVersionDiff))),
+		"POST /set-version":      pipe.Join(mdware.Exec(write(h, setVersion))),
+
+		"POST /get-trash": pipe.Join(mdware.Exec(read(h, getTrash))),
+
+		"POST /get-hook":      pipe.Join(mdware.Exec(admin(h, getHook))),
+		"POST /get-hook-list": pipe.Join(mdware.Exec(admin(h, getHookList))),
+		"POST /get-hook-dead": pipe.Join(mdware.Exec(admin(h, getHookDead))),
+		"POST /set-hook":      pipe.Join(mdware.Exec(admin(h, setHook))),
+		"POST /del-hook":      pipe.Join(mdware.Exec(admin(h, delHook))),
+
+		"POST /get-sugg-by-text": pipe.Join(mdware.Exec(read(h, listSugg))),
+		"POST /get-list-by-sugg": pipe.Join(mdware.Exec(read(h, findSugg))),
 
 		// => Debug mode only, when pref.Debug == true
 		"GET /debug/vars":               pipe.Join(mdware.Exec(mdware.Stdh)), // expvar
@@ -239,7 +289,7 @@ func (h *handler) prepareAPI() *handler {
 		"GET /debug/pprof/heap":         pipe.Join(mdware.Exec(mdware.Stdh)), // runtime/pprof
 		"GET /debug/pprof/block":        pipe.Join(mdware.Exec(mdware.Stdh)), // runtime/pprof
 
-		"GET /debug/check": pipe.Join(mdware.Exec(exec(h, getCheck))),
+		"GET /debug/check": pipe.Join(mdware.Exec(read(h, getCheck))),
 	}
 
 	h.err404 = mdware.Join(
@@ -305,6 +355,16 @@ func NewWithRouter(r router.Router, options ...func(*handler) error) (http.Handl
 		}
 	}
 
+	if h.rdr == nil {
+		h.rdr = h.rdb
+	}
+	if h.rds == nil {
+		h.rds = h.rdb
+	}
+	h.rdb = newNSRediser(h.rdb, h.ns)
+	h.rdr = newNSRediser(h.rdr, h.ns)
+	h.rds = newNSRediser(h.rds, h.ns)
+
 	return h.prepareAPI().withRouter(r)
 }
 
@@ -324,6 +384,44 @@ func Redis(r rediser) func(*handler) error {
 	}
 }
 
+// Replicas is interface for Redis Pool Connections used by read-only
+// endpoints, connections from Redis option are used if not set.
+func Replicas(r rediser) func(*handler) error {
+	return func(h *handler) error {
+		h.rdr = r
+		return nil
+	}
+}
+
+// Streams is interface for Redis Pool Connections used by the change feed,
+// each subscriber holds one connection for the life of the stream, so they
+// should not come from pools of other endpoints. Connections from Redis
+// option are used if not set.
+func Streams(r rediser) func(*handler) error {
+	return func(h *handler) error {
+		h.rds = r
+		return nil
+	}
+}
+
+// Namespace is prefix for all Redis keys, so several catalogs can share
+// one Redis database.
+func Namespace(ns string) func(*handler) error {
+	return func(h *handler) error {
+		h.ns = strings.Trim(ns, ":")
+		return nil
+	}
+}
+
+// AdminToken is bearer token of admin endpoints, they answer 403 if it is
+// empty.
+func AdminToken(s string) func(*handler) error {
+	return func(h *handler) error {
+		h.admin = s
+		return nil
+	}
+}
+
 func uuid() string {
 	return nuid.Next()
 }
@@ -343,60 +441,223 @@ type ctxHelper struct {
 	lang string
 	atag string
 	hack string
+
+	// replace makes writes drop fields missing in entities
+	replace bool
+	// match is expected revision of single written entity, see If-Match
+	match int64
+	// keepRev makes writes keep revisions of entities as given
+	keepRev bool
+	// quiet makes writes skip audit, versions and change events, see Import
+	quiet bool
+	// patch makes set-* apply items as merge patches, see Write-Mode
+	patch bool
+	// fields are fields of entities answered by get-*, see Content-Meta
+	fields projection
+	// page is paging of list answered by get-*, see pageResult
+	page *jsonMeta
+	// old are states of entities watched by writes, see watchOld
+	old map[string]*connState
+	// moved reports whether the live release is changed since the request
+	// started, it is nil for requests with Release header
+	moved func() bool
 }
 
 func (h *ctxHelper) getConn() redis.Conn {
 	return h.rdb.Get()
 }
 
+// path returns path of request, snapshots are imported without request.
+func (h *ctxHelper) path() string {
+	if h.r == nil {
+		return ""
+	}
+	return h.r.URL.Path
+}
+
 func (h *ctxHelper) delConn(c io.Closer) {
 	_ = c.Close
 }
 
 func (h *ctxHelper) clone() *ctxHelper {
 	return &ctxHelper{
-		h.ctx,
-		h.rdb,
-		h.log,
-		h.r,
-		h.w,
-		h.meta,
-		h.data,
-		h.lang,
-		h.atag,
-		h.hack,
+		ctx:     h.ctx,
+		rdb:     h.rdb,
+		log:     h.log,
+		r:       h.r,
+		w:       h.w,
+		meta:    h.meta,
+		data:    h.data,
+		lang:    h.lang,
+		atag:    h.atag,
+		hack:    h.hack,
+		replace: h.replace,
+		match:   h.match,
+		keepRev: h.keepRev,
+		quiet:   h.quiet,
+		patch:   h.patch,
+		fields:  h.fields,
+		moved:   h.moved,
 	}
 }
 
-func exec(h *handler, f func(*ctxHelper) (interface{}, error)) http.HandlerFunc {
+// read executes f with connections to replicas, entities are projected,
+// single ones are answered with ETag.
+func read(h *handler, f func(*ctxHelper) (interface{}, error)) http.HandlerFunc {
+	return exec(h, h.rdr, func(h *ctxHelper) (interface{}, error) {
+		m, _ := parseMeta(h.meta)
+		if m != nil {
+			h.fields = newProjection(m.Fields, h.lang)
+		}
+		res, err := f(h)
+		if err == nil {
+			setETag(h, res)
+		}
+		return res, err
+	})
+}
+
+// write executes f with connections to main Redis.
+func write(h *handler, f func(*ctxHelper) (interface{}, error)) http.HandlerFunc {
+	return exec(h, h.rdb, f)
+}
+
+// stream executes f with connections of the change feed.
+func stream(h *handler, f func(*ctxHelper) (interface{}, error)) http.HandlerFunc {
+	return exec(h, h.rds, f)
+}
+
+func exec(h *handler, rdb rediser, f func(*ctxHelper) (interface{}, error)) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		hlp := &ctxHelper{
-			ctx,
-			h.rdb,
-			h.log,
-			r,
-			w,
-			[]byte(r.Header.Get("Content-Meta")),
-			ctxutil.BodyFrom(ctx),
-			mineLang(r.Header.Get("Accept-Language")),
-			mineATag(r.Header.Get("User-Agent-Tag")),
-			"",
+		rel := r.Header.Get("Release")
+		if rel != "" {
+			rdb, err := withRelease(rdb, rel)
+			if err != nil {
+				ctx = ctxutil.WithCode(ctx, http.StatusBadRequest)
+				*r = *r.WithContext(ctxutil.WithError(ctx, err))
+				return
+			}
+			apply(h, rdb, f, w, r)
+			return
 		}
-		//FIXME temp workaround
-		if (hlp.atag != "") && (strToSHA1(hlp.atag) == "fe5fca9e408b3f3c2346ae5dafa6d57e1856ac2a") {
-			hlp.atag = ""
+
+		live, err := h.live.load(rdb)
+		if err != nil {
+			*r = *r.WithContext(ctxutil.WithError(ctx, err))
+			return
 		}
-		res, err := f(hlp)
-		ctx = hlp.ctx // get ctx from func f
+
+		root := rdb
+		apply(h, newNSRediser(rdb, releaseKeyspace(live)), func(x *ctxHelper) (interface{}, error) {
+			x.moved = func() bool {
+				cur, err := h.live.load(root)
+				return err == nil && cur != live
+			}
+			return f(x)
+		}, w, r)
+	})
+}
+
+// admin executes f with connections to main Redis in the root of namespace,
+// releases do not matter. Requests must have the admin token as bearer
+// token, admin endpoints are off without it, see AdminToken.
+func admin(h *handler, f func(*ctxHelper) (interface{}, error)) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if h.admin == "" {
+			ctx = ctxutil.WithCode(ctx, http.StatusForbidden)
+			*r = *r.WithContext(ctxutil.WithError(ctx, fmt.Errorf("admin endpoints are off")))
+			return
+		}
+
+		s := r.Header.Get("Authorization")
+		if !strings.HasPrefix(s, "Bearer ") || subtle.ConstantTimeCompare([]byte(s[len("Bearer "):]), []byte(h.admin)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			ctx = ctxutil.WithCode(ctx, http.StatusUnauthorized)
+			*r = *r.WithContext(ctxutil.WithError(ctx, fmt.Errorf("invalid admin token")))
+			return
+		}
+
+		apply(h, h.rdb, f, w, r)
+	})
+}
+
+func apply(h *handler, rdb rediser, f func(*ctxHelper) (interface{}, error), w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	match, err := parseMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		ctx = ctxutil.WithCode(ctx, http.StatusBadRequest)
+		*r = *r.WithContext(ctxutil.WithError(ctx, err))
+		return
+	}
+
+	mode, err := parseWriteMode(r.Header.Get("Write-Mode"), r.Header.Get("Content-Type"))
+	if err != nil {
+		ctx = ctxutil.WithCode(ctx, http.StatusBadRequest)
+		*r = *r.WithContext(ctxutil.WithError(ctx, err))
+		return
+	}
+
+	meta, err := parseMeta([]byte(r.Header.Get(headerMeta)))
+	if err != nil {
+		ctx = ctxutil.WithCode(ctx, http.StatusBadRequest)
+		*r = *r.WithContext(ctxutil.WithError(ctx, err))
+		return
+	}
+
+	hlp := &ctxHelper{
+		ctx:     ctx,
+		rdb:     rdb,
+		log:     h.log,
+		r:       r,
+		w:       w,
+		meta:    []byte(r.Header.Get(headerMeta)),
+		data:    ctxutil.BodyFrom(ctx),
+		lang:    mineLang(r.Header.Get("Accept-Language")),
+		atag:    mineATag(r.Header.Get("User-Agent-Tag")),
+		replace: mode != writeMerge, // patched entities are written as replaced
+		match:   match,
+		patch:   mode == writePatch,
+		page:    meta,
+	}
+	//FIXME temp workaround
+	if (hlp.atag != "") && (strToSHA1(hlp.atag) == "fe5fca9e408b3f3c2346ae5dafa6d57e1856ac2a") {
+		hlp.atag = ""
+	}
+	res, err := f(hlp)
+	ctx = hlp.ctx // get ctx from func f
+	switch err {
+	case errConflict:
+		ctx = ctxutil.WithCode(ctx, http.StatusConflict)
+	case errResync:
+		ctx = ctxutil.WithCode(ctx, http.StatusGone)
+	case errMatchMany:
+		ctx = ctxutil.WithCode(ctx, http.StatusBadRequest)
+	}
+	if e, ok := err.(*revisionError); ok {
+		ctx = ctxutil.WithCode(ctx, http.StatusConflict)
+		if len(e.ids) == 1 {
+			w.Header().Set("ETag", etag(e.rev[0]))
+		}
+	}
+	if err != nil {
+		ctx = ctxutil.WithError(ctx, err)
+	}
+
+	if err == nil {
+		res = pageResult(hlp, res)
+		res, err = projectResult(res, hlp.fields)
 		if err != nil {
 			ctx = ctxutil.WithError(ctx, err)
 		}
+	}
 
-		ctx = ctxutil.WithResult(ctx, res)
-		*r = *r.WithContext(ctx)
-	})
+	ctx = ctxutil.WithResult(ctx, res)
+	*r = *r.WithContext(ctx)
 }
 
 func mineATag(s string) string {
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"internal/redismem"
+	"internal/router"
+)
+
+// testAPI is api over in-memory store.
+type testAPI struct {
+	t *testing.T
+	h http.Handler
+	s *redismem.Store
+}
+
+func newTestAPI(t *testing.T, options ...func(*handler) error) *testAPI {
+	s := redismem.New()
+	h, err := NewWithRouter(
+		router.NewMuxVestigo(context.Background()),
+		append([]func(*handler) error{
+			Redis(s),
+			Logger(log.New(ioutil.Discard, "", 0)),
+		}, options...)...,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testAPI{t, h, s}
+}
+
+// call sends request with headers given as name, value pairs.
+func (a *testAPI) call(path, body string, hdr ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", path, bytes.NewReader([]byte(body)))
+	r.Header.Set("Accept-Language", "ru")
+	for i := 0; i+1 < len(hdr); i += 2 {
+		r.Header.Set(hdr[i], hdr[i+1])
+	}
+	w := httptest.NewRecorder()
+	a.h.ServeHTTP(w, r)
+	return w
+}
+
+// ok sends request, which must succeed, and decodes answer to v.
+func (a *testAPI) ok(v interface{}, path, body string, hdr ...string) {
+	a.t.Helper()
+	w := a.call(path, body, hdr...)
+	if w.Code != http.StatusOK {
+		a.t.Fatalf("%s: %d %s", path, w.Code, w.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		a.t.Fatalf("%s: %v: %s", path, err, w.Body.String())
+	}
+}
+
+// fsck fails the test on any problem of the catalog.
+func (a *testAPI) fsck() {
+	a.t.Helper()
+	_, err := Fsck(a.s, "", "", false, func(p Problem) error {
+		a.t.Errorf("fsck: %+v", p)
+		return nil
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+func TestSetGetDel(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол","slug":"paracetamol"}]`)
+	a.ok(nil, "/set-spec-inf", `[{"id":100,"name_ru":"Панадол","name_ru_src":"Панадол","id_inn":[1],"is_info":1}]`)
+
+	var inn jsonINNs
+	a.ok(&inn, "/get-inn", `[1]`)
+	if len(inn) != 1 || inn[0].Slug != "paracetamol" || !reflect.DeepEqual(inn[0].IDSpecINF, []int64{100}) {
+		t.Fatalf("get-inn: %+v", inn[0])
+	}
+
+	var spec jsonSpecs
+	a.ok(&spec, "/get-spec-inf-list-by-id-inn", `1`)
+	if len(spec) != 1 || spec[0].ID != 100 {
+		t.Fatalf("list-by-id-inn: %+v", spec)
+	}
+
+	var s jsonSync
+	a.ok(&s, "/get-spec-inf-sync", `{"cursor":0}`)
+	if !reflect.DeepEqual(s.ID, []int64{100}) {
+		t.Fatalf("sync: %+v", s)
+	}
+	a.fsck()
+
+	a.ok(nil, "/del-spec-inf", `[100]`)
+	inn = nil
+	a.ok(&inn, "/get-inn", `[1]`)
+	if len(inn[0].IDSpecINF) != 0 {
+		t.Fatalf("link is left: %+v", inn[0])
+	}
+	a.ok(&s, "/get-spec-inf-sync", fmt.Sprintf(`{"cursor":%d}`, -s.Cursor))
+	if !reflect.DeepEqual(s.ID, []int64{100}) {
+		t.Fatalf("sync after del: %+v", s)
+	}
+}
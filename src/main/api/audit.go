@@ -0,0 +1,430 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"internal/ctxutil"
+	"internal/redismem"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Audit entries are JSON values of hash audit:entry by entry ids, the ids are
+// members of sorted sets scored by time in milliseconds: all of them in audit,
+// of entity in audit:<prefix>:<id> and of user in audit:by:<user>.
+const (
+	keyAudit      = "audit"
+	keyAuditEntry = "audit:entry"
+
+	auditLimit    = 100
+	auditMaxLimit = 1000
+	auditBatch    = 1000
+)
+
+// jsonAudit is entry of audit log, diff holds old and new values of changed
+// fields, null for missing ones.
+type jsonAudit struct {
+	Time time.Time             `json:"time"`
+	User string                `json:"user"`
+	Req  string                `json:"req,omitempty"`
+	Path string                `json:"path,omitempty"`
+	Kind string                `json:"kind"`
+	ID   int64                 `json:"id"`
+	Op   string                `json:"op"`
+	Diff map[string][2]*string `json:"diff,omitempty"`
+}
+
+// jsonAuditQuery is request of get-audit.
+type jsonAuditQuery struct {
+	Kind  string    `json:"kind"`
+	ID    int64     `json:"id"`
+	User  string    `json:"user"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Limit int       `json:"limit"`
+}
+
+// jsonAuditList is response of get-audit, entries are ordered from the
+// newest.
+type jsonAuditList struct {
+	Data []jsonAudit `json:"data"`
+	More bool        `json:"more"`
+}
+
+func auditEntityKey(p string, id int64) string {
+	return genKey(keyAudit, p, id)
+}
+
+func auditUserKey(u string) string {
+	return genKey(keyAudit, "by", u)
+}
+
+// connState is state of entity loaded by watch, it follows writes of the
+// request.
+type connState struct {
+	hash   map[string]string
+	link   map[string][]int64
+	ver    int64
+	linked bool  // link follows writes of the request
+	rev    int64 // revision of deleted entity, see addRevision
+}
+
+// watchOld keeps current states of watched entities, so changes made by the
+// request can be audited and versioned.
+func (h *ctxHelper) watchOld(c redis.Conn, p string, ids []int64) error {
+	v, err := loadSnapshots(c, p, ids)
+	if err != nil {
+		return err
+	}
+
+	if h.old == nil {
+		h.old = make(map[string]*connState, len(ids))
+	}
+	for i := range v {
+		h.old[genKey(p, ids[i])] = &connState{hash: v[i].Hash, link: v[i].Link, ver: v[i].Ver, rev: v[i].rev}
+	}
+
+	return nil
+}
+
+// watchFields keeps current values of fields written by updates of derived
+// fields, they are audited but not versioned.
+func (h *ctxHelper) watchFields(c redis.Conn, p string, v ruler) error {
+	var keys []string
+	var fields [][]interface{}
+	for i := 0; i < v.len(); i++ {
+		if v.null(i) {
+			continue
+		}
+		x, ok := v.elem(i).(hasher)
+		if !ok {
+			continue
+		}
+		f := x.getFields(false)
+		a := []interface{}{genKey(p, x.getID()), fieldRev}
+		for j, val := range x.getValues() {
+			if !zeroValue(val) {
+				a = append(a, f[j])
+			}
+		}
+		err := c.Send("HMGET", a...)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, genKey(p, x.getID()))
+		fields = append(fields, a[1:])
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	res, err := redis.Values(c.Do(""))
+	if err != nil {
+		return err
+	}
+
+	if h.old == nil {
+		h.old = make(map[string]*connState, len(keys))
+	}
+	for i := range keys {
+		x, err := redis.Values(res[i], nil)
+		if err != nil {
+			return err
+		}
+		var m map[string]string
+		for j := range x {
+			if x[j] == nil {
+				continue
+			}
+			if m == nil {
+				m = make(map[string]string, len(x))
+			}
+			m[redisString(fields[i][j])], _ = redis.String(x[j], nil)
+		}
+		h.old[keys[i]] = &connState{hash: m}
+	}
+
+	return nil
+}
+
+// addAudit appends audit entry of hash change made by request, it is sent
+// with the change in one transaction. Values of hasher are merged into old
+// ones as by HMSET or replace them, nil hasher means deletion.
+func addAudit(hlp *ctxHelper, c redis.Conn, p string, id int64, h hasher, replace bool) error {
+	if hlp == nil || hlp.quiet {
+		return nil
+	}
+
+	s := hlp.old[genKey(p, id)]
+	var old map[string]string
+	if s != nil {
+		old = s.hash
+	}
+
+	e := jsonAudit{
+		Time: time.Now(),
+		User: ctxutil.AuthFrom(hlp.ctx),
+		Req:  ctxutil.UUIDFrom(hlp.ctx),
+		Path: hlp.path(),
+		Kind: p,
+		ID:   id,
+		Op:   "set",
+		Diff: make(map[string][2]*string),
+	}
+
+	var cur map[string]string
+	if h == nil {
+		e.Op = "del"
+		for k := range old {
+			x := old[k]
+			e.Diff[k] = [2]*string{&x, nil}
+		}
+	} else {
+		f := h.getFields(false)
+		v := h.getValues()
+		cur = make(map[string]string, len(old)+len(v))
+		for k := range old {
+			// revision is kept by replace, see addRevision
+			if replace && k != fieldRev {
+				x := old[k]
+				e.Diff[k] = [2]*string{&x, nil}
+				continue
+			}
+			cur[k] = old[k]
+		}
+		for i := range v {
+			if zeroValue(v[i]) {
+				continue
+			}
+			k, n := redisString(f[i]), redismem.ArgString(v[i])
+			x, ok := old[k]
+			if ok && x == n {
+				cur[k] = n
+				delete(e.Diff, k)
+				continue
+			}
+			if ok {
+				e.Diff[k] = [2]*string{&x, &n}
+			} else {
+				e.Diff[k] = [2]*string{nil, &n}
+			}
+			cur[k] = n
+		}
+	}
+
+	// writes without changes are not audited
+	if s != nil {
+		s.hash = cur
+		if len(e.Diff) == 0 {
+			return nil
+		}
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	x := uuid()
+	err = c.Send("HSET", keyAuditEntry, x, b)
+	if err != nil {
+		return err
+	}
+	return addAuditIndex(c, &e, x)
+}
+
+// addAuditIndex adds id of audit entry to sorted sets of all entries, of
+// entity and of user.
+func addAuditIndex(c redis.Conn, e *jsonAudit, x string) error {
+	t := e.Time.UnixNano() / int64(time.Millisecond)
+	err := c.Send("ZADD", keyAudit, t, x)
+	if err != nil {
+		return err
+	}
+	err = c.Send("ZADD", auditEntityKey(e.Kind, e.ID), t, x)
+	if err != nil {
+		return err
+	}
+	return c.Send("ZADD", auditUserKey(e.User), t, x)
+}
+
+// remAuditIndex is the reverse of addAuditIndex.
+func remAuditIndex(c redis.Conn, e *jsonAudit, x string) error {
+	err := c.Send("ZREM", keyAudit, x)
+	if err != nil {
+		return err
+	}
+	err = c.Send("ZREM", auditEntityKey(e.Kind, e.ID), x)
+	if err != nil {
+		return err
+	}
+	return c.Send("ZREM", auditUserKey(e.User), x)
+}
+
+// loadAudit returns entries by ids, missing ones are skipped.
+func loadAudit(c redis.Conn, ids []string) ([]string, []jsonAudit, error) {
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, keyAuditEntry)
+	for i := range ids {
+		args = append(args, ids[i])
+	}
+	v, err := redis.Values(c.Do("HMGET", args...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	x := make([]string, 0, len(ids))
+	res := make([]jsonAudit, 0, len(ids))
+	for i := range v {
+		if v[i] == nil {
+			continue
+		}
+		b, err := redis.Bytes(v[i], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		var e jsonAudit
+		err = json.Unmarshal(b, &e)
+		if err != nil {
+			return nil, nil, err
+		}
+		x = append(x, ids[i])
+		res = append(res, e)
+	}
+
+	return x, res, nil
+}
+
+// getAudit answers with audit entries of entity or user in time range, all
+// entries if neither is given.
+func getAudit(h *ctxHelper) (interface{}, error) {
+	var v jsonAuditQuery
+	err := json.Unmarshal(h.data, &v)
+	if err == nil && (v.Kind == "") != (v.ID == 0) {
+		err = fmt.Errorf("kind and id go together")
+	}
+	if err == nil && v.Kind != "" && !isDeltaPrefix(v.Kind) {
+		err = fmt.Errorf("unknown kind %q", v.Kind)
+	}
+	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, err
+	}
+
+	if v.Limit <= 0 {
+		v.Limit = auditLimit
+	}
+	if v.Limit > auditMaxLimit {
+		v.Limit = auditMaxLimit
+	}
+
+	min, max := "-inf", "+inf"
+	if !v.From.IsZero() {
+		min = strconv.FormatInt(v.From.UnixNano()/int64(time.Millisecond), 10)
+	}
+	if !v.To.IsZero() {
+		max = strconv.FormatInt(v.To.UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	key := keyAudit
+	switch {
+	case v.Kind != "":
+		key = auditEntityKey(v.Kind, v.ID)
+	case v.User != "":
+		key = auditUserKey(v.User)
+	}
+
+	c := h.getConn()
+	defer h.delConn(c)
+
+	// history of entity is short, so it is filtered by user as is
+	args := []interface{}{key, max, min}
+	if v.Kind == "" || v.User == "" {
+		args = append(args, "LIMIT", 0, v.Limit+1)
+	}
+	ids, err := redis.Strings(c.Do("ZREVRANGEBYSCORE", args...))
+	if err != nil {
+		return nil, err
+	}
+	_, res, err := loadAudit(c, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &jsonAuditList{Data: make([]jsonAudit, 0, len(res))}
+	for _, e := range res {
+		if v.User != "" && e.User != v.User {
+			continue
+		}
+		if len(out.Data) == v.Limit {
+			out.More = true
+			break
+		}
+		out.Data = append(out.Data, e)
+	}
+
+	return out, nil
+}
+
+// CompactAudit removes audit entries older than retention, it returns the
+// number of removed entries.
+func CompactAudit(r rediser, ns, rel string, retention time.Duration) (int, error) {
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return 0, err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	t := time.Now().Add(-retention).UnixNano() / int64(time.Millisecond)
+	var n int
+	for {
+		ids, err := redis.Strings(c.Do("ZRANGEBYSCORE", keyAudit, "-inf", "("+strconv.FormatInt(t, 10), "LIMIT", 0, auditBatch))
+		if err != nil {
+			return n, err
+		}
+		if len(ids) == 0 {
+			return n, nil
+		}
+		x, res, err := loadAudit(c, ids)
+		if err != nil {
+			return n, err
+		}
+
+		err = multiExec(c, func() error {
+			for i := range res {
+				err := c.Send("ZREM", auditEntityKey(res[i].Kind, res[i].ID), x[i])
+				if err == nil {
+					err = c.Send("ZREM", auditUserKey(res[i].User), x[i])
+				}
+				if err != nil {
+					return err
+				}
+			}
+			// ids without entries are dropped from the log as well
+			args := make([]interface{}, 0, len(ids))
+			for i := range ids {
+				args = append(args, ids[i])
+			}
+			err := c.Send("ZREM", append([]interface{}{keyAudit}, args...)...)
+			if err != nil {
+				return err
+			}
+			return c.Send("HDEL", append([]interface{}{keyAuditEntry}, args...)...)
+		})
+		if err != nil {
+			return n, err
+		}
+
+		n += len(ids)
+	}
+}
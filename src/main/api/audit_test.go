@@ -0,0 +1,82 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// TestAuditEntries checks that entries are kept once in audit:entry and the
+// logs hold their ids.
+func TestAuditEntries(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"}]`)
+	a.ok(nil, "/set-inn", `[{"id":1,"slug":"paracetamol"}]`)
+
+	c := a.s.Get()
+	defer c.Close()
+
+	for _, k := range []string{keyAudit, auditEntityKey(prefixINN, 1), auditUserKey("anonymous")} {
+		m, err := redis.Strings(c.Do("ZRANGE", k, 0, -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m) != 2 || strings.HasPrefix(m[0], "{") {
+			t.Fatalf("%s: %q", k, m)
+		}
+	}
+
+	var v jsonAuditList
+	a.ok(&v, "/get-audit", `{"kind":"inn","id":1}`)
+	if len(v.Data) != 2 || v.Data[0].Diff["slug"][1] == nil || *v.Data[0].Diff["slug"][1] != "paracetamol" {
+		t.Fatalf("get-audit: %+v", v)
+	}
+
+	n, err := CompactAudit(a.s, "", "", -time.Hour)
+	if err != nil || n != 2 {
+		t.Fatalf("compact: %d %v", n, err)
+	}
+	keys, err := redis.Strings(c.Do("KEYS", "audit*"))
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("keys are left: %q %v", keys, err)
+	}
+}
+
+// TestAuditSale checks that set-spec-inf-sale watches and audits only specs
+// with changed sale.
+func TestAuditSale(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-drug", `[{"id":1,"name_ru":"Панадол"},{"id":2,"name_ru":"Нурофен"}]`)
+	a.ok(nil, "/set-spec-inf", `[{"id":100,"name_ru":"Панадол","id_drug":[1],"is_info":1},{"id":101,"name_ru":"Нурофен","id_drug":[2],"is_info":1}]`)
+	a.ok(nil, "/set-drug-sale", `[{"id":1,"v":10},{"id":2,"v":20}]`)
+	a.ok(nil, "/set-spec-inf-sale", ``)
+	a.ok(nil, "/set-drug-sale", `[{"id":2,"v":30}]`)
+
+	var watched []string
+	r := hookRediser{a.s, func(cmd string, args []interface{}) error {
+		if cmd == "WATCH" {
+			for i := range args {
+				watched = append(watched, redisString(args[i]))
+			}
+		}
+		return nil
+	}}
+	b := newTestAPI(t, Redis(r))
+	b.ok(nil, "/set-spec-inf-sale", ``)
+	if !reflect.DeepEqual(watched, []string{genKey(prefixSpecINF, 101)}) {
+		t.Fatalf("watched: %q", watched)
+	}
+
+	var v jsonAuditList
+	a.ok(&v, "/get-audit", `{"kind":"spec:inf","id":101}`)
+	if len(v.Data) != 3 || v.Data[0].Diff["sale"][1] == nil || *v.Data[0].Diff["sale"][1] != "30" {
+		t.Fatalf("get-audit: %+v", v)
+	}
+	a.ok(&v, "/get-audit", `{"kind":"spec:inf","id":100}`)
+	if len(v.Data) != 2 {
+		t.Fatalf("get-audit: %+v", v)
+	}
+}
@@ -36,12 +36,17 @@ type jsonClass struct {
 	NameEN    string  `json:"name_en,omitempty"`
 	Slug      string  `json:"slug,omitempty"`
 	Full      bool    `json:"full,omitempty"`
+	Rev       int64   `json:"rev,omitempty"`
 }
 
 func (j *jsonClass) getID() int64 {
 	return j.ID
 }
 
+func (j *jsonClass) getRev() int64 {
+	return j.Rev
+}
+
 func (j *jsonClass) getSrchRU(_ string) ([]string, []rune) {
 	var s []string
 	var r []rune
@@ -93,6 +98,7 @@ func (j *jsonClass) getFields(_ bool) []interface{} {
 		"name_ua", // 5
 		"name_en", // 6
 		"slug",    // 7
+		"rev",     // 8
 	}
 }
 
@@ -106,6 +112,7 @@ func (j *jsonClass) getValues() []interface{} {
 		j.NameUA, // 5
 		j.NameEN, // 6
 		j.Slug,   // 7
+		j.Rev,    // 8
 	}
 }
 
@@ -131,6 +138,8 @@ func (j *jsonClass) setValues(_ bool, v ...interface{}) {
 			j.NameEN, _ = redis.String(v[i], nil)
 		case 7:
 			j.Slug, _ = redis.String(v[i], nil)
+		case 8:
+			j.Rev, _ = redis.Int64(v[i], nil)
 		}
 	}
 }
@@ -153,6 +162,10 @@ func (j jsonClasses) nill(i int) {
 	j[i] = nil
 }
 
+func (v jsonClasses) pageKey(i int, _ bool) pageKey {
+	return pageKey{Name: v[i].Slug}
+}
+
 func (v jsonClasses) sort(lang string) {
 	coll := newCollator(lang)
 	sort.Slice(v,
@@ -166,7 +179,10 @@ func (v jsonClasses) sort(lang string) {
 			if v[i] != nil && v[j] == nil {
 				return true
 			}
-			return coll.CompareString(v[i].Slug, v[j].Slug) < 0
+			if n := coll.CompareString(v[i].Slug, v[j].Slug); n != 0 {
+				return n < 0
+			}
+			return v[i].ID < v[j].ID
 		},
 	)
 }
@@ -192,36 +208,37 @@ func makeClassesFromIDs(v []int64, err error) (jsonClasses, error) {
 }
 
 func loadClassLinks(c redis.Conn, p string, v []*jsonClass) error {
-	var err error
+	ids := make([]int64, len(v))
 	for i := range v {
-		if v[i] == nil {
-			continue
+		if v[i] != nil {
+			ids[i] = v[i].ID
 		}
+	}
 
-		v[i].IDNext, err = loadLinkIDs(c, p, "next", v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDSpecDEC, err = loadLinkIDs(c, p, prefixSpecDEC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDSpecINF, err = loadLinkIDs(c, p, prefixSpecINF, v[i].ID)
-		if err != nil {
-			return err
+	x, err := newStorage(c).loadLinkSets(p, ids, "next", prefixSpecDEC, prefixSpecINF)
+	if err != nil {
+		return err
+	}
+
+	for i := range v {
+		if v[i] == nil {
+			continue
 		}
+		v[i].IDNext = x[i]["next"]
+		v[i].IDSpecDEC = x[i][prefixSpecDEC]
+		v[i].IDSpecINF = x[i][prefixSpecINF]
 	}
 	return nil
 }
 
-func saveClassLinks(c redis.Conn, p string, v ...*jsonClass) error {
+func saveClassLinks(h *ctxHelper, c redis.Conn, p string, v ...*jsonClass) error {
 	var err error
 	for i := range v {
 		if v[i] == nil {
 			continue
 		}
 
-		err = saveLinkIDs(c, "next", p, false, v[i].ID, v[i].IDNode)
+		err = newStorage(c).saveLinkIDs(h, "next", p, false, v[i].ID, v[i].IDNode)
 		if err != nil {
 			return err
 		}
@@ -229,14 +246,14 @@ func saveClassLinks(c redis.Conn, p string, v ...*jsonClass) error {
 	return nil
 }
 
-func freeClassLinks(c redis.Conn, p string, v ...*jsonClass) error {
+func freeClassLinks(h *ctxHelper, c redis.Conn, p string, v ...*jsonClass) error {
 	var err error
 	for i := range v {
 		if v[i] == nil {
 			continue
 		}
 
-		err = freeLinkIDs(c, "next", p, false, v[i].ID, v[i].IDNode)
+		err = newStorage(c).freeLinkIDs(h, "next", p, false, v[i].ID, v[i].IDNode)
 		if err != nil {
 			return err
 		}
@@ -244,17 +261,8 @@ func freeClassLinks(c redis.Conn, p string, v ...*jsonClass) error {
 	return nil
 }
 
-func getClassXSync(h *ctxHelper, p string) ([]int64, error) {
-	v, err := int64FromJSON(h.data)
-	if err != nil {
-		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
-		return nil, err
-	}
-
-	c := h.getConn()
-	defer h.delConn(c)
-
-	return loadSyncIDs(c, p, v)
+func getClassXSync(h *ctxHelper, p string) (interface{}, error) {
+	return getSyncX(h, p)
 }
 
 func mineClassRootIDs(c redis.Conn, p string, v []*jsonClass) ([]int64, error) {
@@ -306,12 +314,25 @@ func getClassXRoot(h *ctxHelper, p string) (jsonClasses, error) {
 }
 
 func getClassXNext(h *ctxHelper, p string) (jsonClasses, error) {
-	v, err := getClassX(h, p)
+	v, err := makeClassesFromIDs(int64sFromJSON(h.data))
 	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
 		return nil, err
 	}
 
-	v.sort(h.lang)
+	c := h.getConn()
+	defer h.delConn(c)
+
+	x, err := loadList(h, c, p, v)
+	if err != nil {
+		return nil, err
+	}
+	v = x.(jsonClasses)
+
+	err = loadClassLinks(c, p, v)
+	if err != nil {
+		return nil, err
+	}
 
 	return v, nil
 }
@@ -333,7 +354,7 @@ func getClassXNextByID(h *ctxHelper, p string) (jsonClasses, error) {
 
 	var r []int64
 	if v[0].ID != 0 {
-		r, err = loadLinkIDs(c, p, "next", v[0].ID)
+		r, err = newStorage(c).loadLinkIDs(p, "next", v[0].ID)
 		if err != nil {
 			return nil, err
 		}
@@ -371,7 +392,7 @@ func getClassXPathByID(h *ctxHelper, p string) (jsonClasses, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	r, err := minePath(c, p, "id_node", x)
+	r, err := newStorage(c).minePath(p, "id_node", x)
 	if err != nil {
 		return nil, err
 	}
@@ -405,7 +426,7 @@ func getClassX(h *ctxHelper, p string) (jsonClasses, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v)
+	err = newStorage(c).loadHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
@@ -430,43 +451,74 @@ func setClassX(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	x, err := makeClassesFromIDs(findExistsIDs(c, p, mineIDsFromHashers(v)...))
+	err = newStorage(c).watchHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(x) > 0 {
-		err = loadHashers(c, p, x)
+	if h.patch {
+		b, err := mergePatch(h, c, p, newClassHasher)
 		if err != nil {
 			return nil, err
 		}
-		err = loadClassLinks(c, p, x)
+		v, err = makeClassesFromJSON(b)
 		if err != nil {
+			h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
 			return nil, err
 		}
-		if p == prefixClassATC {
-			err = freeSearchers(c, p, x)
-			if err != nil {
-				return nil, err
-			}
+	}
+
+	x, err := makeClassesFromIDs(newStorage(c).findExistsIDs(p, mineIDsFromHashers(v)...))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(x) > 0 {
+		err = newStorage(c).loadHashers(h, p, x)
+		if err != nil {
+			return nil, err
 		}
-		err = freeClassLinks(c, p, x...)
+		err = loadClassLinks(c, p, x)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err = saveHashers(c, p, v)
+	d, err := classDeep(c, p, mineClassMoves(h, x, v))
 	if err != nil {
 		return nil, err
 	}
-	if p == prefixClassATC {
-		err = saveSearchers(c, p, v)
+
+	err = multiExec(c, func() error {
+		if len(x) > 0 {
+			if p == prefixClassATC {
+				err := newStorage(c).freeSearchers(p, x)
+				if err != nil {
+					return err
+				}
+			}
+			err := freeClassLinks(h, c, p, x...)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := newStorage(c).saveHashers(h, p, v)
 		if err != nil {
-			return nil, err
+			return err
 		}
-	}
-	err = saveClassLinks(c, p, v...)
+		if p == prefixClassATC {
+			err = newStorage(c).saveSearchers(p, v)
+			if err != nil {
+				return err
+			}
+		}
+		err = saveClassLinks(h, c, p, v...)
+		if err != nil {
+			return err
+		}
+		return d.save(c)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -474,6 +526,35 @@ func setClassX(h *ctxHelper, p string) (interface{}, error) {
 	return statusOK, nil
 }
 
+// mineClassMoves returns moves of classes x to nodes of classes v. In the
+// merge mode missing id_node of item is set to the current one, as the hash
+// keeps it, so the class stays in place.
+func mineClassMoves(h *ctxHelper, x, v []*jsonClass) []classMove {
+	old := make(map[int64]int64, len(x))
+	for i := range x {
+		if x[i] != nil {
+			old[x[i].ID] = x[i].IDNode
+		}
+	}
+
+	res := make([]classMove, 0, len(v))
+	for i := range v {
+		if v[i] == nil {
+			continue
+		}
+		from, ok := old[v[i].ID]
+		if !ok {
+			from = -1
+		}
+		if ok && !h.replace && v[i].IDNode == 0 {
+			v[i].IDNode = from
+		}
+		res = append(res, classMove{v[i].ID, from, v[i].IDNode})
+		old[v[i].ID] = v[i].IDNode
+	}
+	return res
+}
+
 func delClassX(h *ctxHelper, p string) (interface{}, error) {
 	v, err := makeClassesFromIDs(int64sFromJSON(h.data))
 	if err != nil {
@@ -484,24 +565,44 @@ func delClassX(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v)
+	err = newStorage(c).watchHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	err = freeHashers(c, p, v)
+	err = newStorage(c).loadHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	if p == prefixClassATC {
-		err = freeSearchers(c, p, v)
-		if err != nil {
-			return nil, err
+	m := make([]classMove, 0, len(v))
+	for i := range v {
+		if v[i] != nil {
+			m = append(m, classMove{v[i].ID, v[i].IDNode, -1})
 		}
 	}
+	d, err := classDeep(c, p, m)
+	if err != nil {
+		return nil, err
+	}
 
-	err = freeClassLinks(c, p, v...)
+	err = multiExec(c, func() error {
+		err := newStorage(c).freeHashers(h, p, v)
+		if err != nil {
+			return err
+		}
+		if p == prefixClassATC {
+			err = newStorage(c).freeSearchers(p, v)
+			if err != nil {
+				return err
+			}
+		}
+		err = freeClassLinks(h, c, p, v...)
+		if err != nil {
+			return err
+		}
+		return d.save(c)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -543,6 +644,10 @@ func delClassATC(h *ctxHelper) (interface{}, error) {
 	return delClassX(h, prefixClassATC)
 }
 
+func restoreClassATC(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixClassATC)
+}
+
 // NFC
 
 func getClassNFCSync(h *ctxHelper) (interface{}, error) {
@@ -577,6 +682,10 @@ func delClassNFC(h *ctxHelper) (interface{}, error) {
 	return delClassX(h, prefixClassNFC)
 }
 
+func restoreClassNFC(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixClassNFC)
+}
+
 // FSC
 
 func getClassFSCSync(h *ctxHelper) (interface{}, error) {
@@ -611,6 +720,10 @@ func delClassFSC(h *ctxHelper) (interface{}, error) {
 	return delClassX(h, prefixClassFSC)
 }
 
+func restoreClassFSC(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixClassFSC)
+}
+
 // BFC
 
 func getClassBFCSync(h *ctxHelper) (interface{}, error) {
@@ -645,6 +758,10 @@ func delClassBFC(h *ctxHelper) (interface{}, error) {
 	return delClassX(h, prefixClassBFC)
 }
 
+func restoreClassBFC(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixClassBFC)
+}
+
 // CFC
 
 func getClassCFCSync(h *ctxHelper) (interface{}, error) {
@@ -679,6 +796,10 @@ func delClassCFC(h *ctxHelper) (interface{}, error) {
 	return delClassX(h, prefixClassCFC)
 }
 
+func restoreClassCFC(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixClassCFC)
+}
+
 // MPC
 
 func getClassMPCSync(h *ctxHelper) (interface{}, error) {
@@ -713,6 +834,10 @@ func delClassMPC(h *ctxHelper) (interface{}, error) {
 	return delClassX(h, prefixClassMPC)
 }
 
+func restoreClassMPC(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixClassMPC)
+}
+
 // CSC
 
 func getClassCSCSync(h *ctxHelper) (interface{}, error) {
@@ -747,6 +872,10 @@ func delClassCSC(h *ctxHelper) (interface{}, error) {
 	return delClassX(h, prefixClassCSC)
 }
 
+func restoreClassCSC(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixClassCSC)
+}
+
 // ICD
 
 func getClassICDSync(h *ctxHelper) (interface{}, error) {
@@ -780,3 +909,7 @@ func setClassICD(h *ctxHelper) (interface{}, error) {
 func delClassICD(h *ctxHelper) (interface{}, error) {
 	return delClassX(h, prefixClassICD)
 }
+
+func restoreClassICD(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixClassICD)
+}
@@ -0,0 +1,506 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Classes keep materialized closure of spec links: sorted set
+// <class prefix>:<id>:deep:<spec prefix> has specs linked to the class or to
+// any class below it, scored by the number of such links, so unlinking from
+// one subclass keeps specs linked to another. The tree is given by id_node of
+// class hashes, 0 is the root of all classes. Deleted classes keep their deep
+// sets, so restore puts them back in the tree.
+const deepLink = "deep"
+
+// deepPrefixes are spec prefixes with deep sets.
+var deepPrefixes = []string{
+	prefixSpecACT,
+	prefixSpecINF,
+	prefixSpecDEC,
+}
+
+// classPrefixes are prefixes of class trees.
+var classPrefixes = []string{
+	prefixClassATC,
+	prefixClassNFC,
+	prefixClassFSC,
+	prefixClassBFC,
+	prefixClassCFC,
+	prefixClassMPC,
+	prefixClassCSC,
+	prefixClassICD,
+}
+
+func deepKey(p string, id int64, ps string) string {
+	return genKey(p, id, deepLink, ps)
+}
+
+// loadDeepIDs returns ids of specs of ps linked to class x or its subclasses.
+func loadDeepIDs(c redis.Conn, p string, x int64, ps string) ([]int64, error) {
+	return redis.Int64s(c.Do("ZRANGE", deepKey(p, x, ps), 0, -1))
+}
+
+// classTree is the part of class tree needed to update deep sets.
+type classTree struct {
+	p    string
+	node map[int64]int64 // id -> id_node, -1 for missing class
+}
+
+func newClassTree(p string) *classTree {
+	return &classTree{p: p, node: make(map[int64]int64)}
+}
+
+// load loads nodes of classes and their ancestors, a level of the tree per
+// round trip. Loaded class hashes are watched if watch is true.
+func (t *classTree) load(c redis.Conn, ids []int64, watch bool) error {
+	for len(ids) > 0 {
+		next := make([]int64, 0, len(ids))
+		for _, id := range uniqInt64(ids) {
+			if _, ok := t.node[id]; !ok && id > 0 {
+				next = append(next, id)
+			}
+		}
+		if len(next) == 0 {
+			return nil
+		}
+
+		for _, id := range next {
+			key := genKey(t.p, id)
+			if watch {
+				err := c.Send("WATCH", key)
+				if err != nil {
+					return err
+				}
+			}
+			err := c.Send("HMGET", key, "id", "id_node")
+			if err != nil {
+				return err
+			}
+		}
+
+		v, err := redis.Values(c.Do(""))
+		if err != nil {
+			return err
+		}
+		if watch {
+			for i := range next {
+				v[i] = v[2*i+1]
+			}
+		}
+
+		ids = ids[:0]
+		for i, id := range next {
+			r, err := redis.Values(v[i], nil)
+			if err != nil {
+				return err
+			}
+			if len(r) < 2 || r[0] == nil {
+				t.node[id] = -1
+				continue
+			}
+			t.node[id], _ = redis.Int64(r[1], nil)
+			ids = append(ids, t.node[id])
+		}
+	}
+
+	return nil
+}
+
+// path returns class id and its ancestors up to the root, the path stops at
+// missing class and before cycles.
+func (t *classTree) path(id int64) []int64 {
+	res := []int64{id}
+	for id > 0 {
+		n, ok := t.node[id]
+		if !ok || n < 0 {
+			break
+		}
+		for _, x := range res {
+			if x == n {
+				return res
+			}
+		}
+		res = append(res, n)
+		id = n
+	}
+	return res
+}
+
+// deepDelta is pending change of deep sets: key -> spec id -> delta.
+type deepDelta map[string]map[int64]int64
+
+// add adds n links of spec s to class y and its ancestors.
+func (d deepDelta) add(t *classTree, ps string, y, s, n int64) {
+	for _, a := range t.path(y) {
+		d.addKey(deepKey(t.p, a, ps), s, n)
+	}
+}
+
+func (d deepDelta) addKey(key string, s, n int64) {
+	if d[key] == nil {
+		d[key] = make(map[int64]int64)
+	}
+	d[key][s] += n
+}
+
+// save sends the change, specs left without links are removed.
+func (d deepDelta) save(c redis.Conn) error {
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		ids := make([]int64, 0, len(d[k]))
+		for s, n := range d[k] {
+			if n != 0 {
+				ids = append(ids, s)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		for _, s := range ids {
+			err := c.Send("ZINCRBY", k, d[k][s], s)
+			if err != nil {
+				return err
+			}
+		}
+		err := c.Send("ZREMRANGEBYSCORE", k, "-inf", 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// specDeep returns change of deep sets made by replacing class links of specs
+// old with links of specs v. Classes on the way to the root are watched, so
+// the change fails if they move meanwhile.
+func specDeep(c redis.Conn, p string, old, v []*jsonSpec) (deepDelta, error) {
+	d := make(deepDelta)
+	for _, pc := range classPrefixes {
+		var ids []int64
+		for _, x := range [][]*jsonSpec{old, v} {
+			for i := range x {
+				if x[i] != nil {
+					ids = append(ids, *x[i].classIDs(pc)...)
+				}
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		t := newClassTree(pc)
+		err := t.load(c, ids, true)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range old {
+			if old[i] != nil {
+				for _, y := range *old[i].classIDs(pc) {
+					d.add(t, p, y, old[i].ID, -1)
+				}
+			}
+		}
+		for i := range v {
+			if v[i] != nil {
+				for _, y := range *v[i].classIDs(pc) {
+					d.add(t, p, y, v[i].ID, 1)
+				}
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// classMove is change of class node, from -1 is a new class and to -1 is a
+// deleted one.
+type classMove struct {
+	id   int64
+	from int64
+	to   int64
+}
+
+// classDeep returns change of deep sets made by moves of classes: deep sets
+// of moved classes leave their old ancestors and join the new ones. Moved
+// deep sets and classes on the way to the root are watched.
+func classDeep(c redis.Conn, p string, m []classMove) (deepDelta, error) {
+	d := make(deepDelta)
+
+	var ids []int64
+	var keys []interface{}
+	for i := range m {
+		if m[i].from == m[i].to {
+			continue
+		}
+		ids = append(ids, m[i].id, m[i].from, m[i].to)
+		for _, ps := range deepPrefixes {
+			keys = append(keys, deepKey(p, m[i].id, ps))
+		}
+	}
+	if len(keys) == 0 {
+		return d, nil
+	}
+
+	t := newClassTree(p)
+	err := t.load(c, ids, true)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.Do("WATCH", keys...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		err = c.Send("ZRANGE", keys[i], 0, -1, "WITHSCORES")
+		if err != nil {
+			return nil, err
+		}
+	}
+	v, err := redis.Values(c.Do(""))
+	if err != nil {
+		return nil, err
+	}
+
+	// moves are applied in order, so deep sets and nodes changed by the
+	// previous ones are taken into account
+	cur := make(deepDelta, len(keys))
+	for i := range keys {
+		x, err := redis.Int64s(v[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		key := keys[i].(string)
+		cur[key] = make(map[int64]int64, len(x)/2)
+		for j := 0; j+1 < len(x); j += 2 {
+			cur[key][x[j]] = x[j+1]
+		}
+	}
+
+	for i := range m {
+		if m[i].from == m[i].to {
+			continue
+		}
+		for _, ps := range deepPrefixes {
+			key := deepKey(p, m[i].id, ps)
+			for s, n := range cur[key] {
+				if n == 0 {
+					continue
+				}
+				if m[i].from >= 0 {
+					moveDeep(d, cur, t, ps, m[i].id, m[i].from, s, -n)
+				}
+				if m[i].to >= 0 {
+					moveDeep(d, cur, t, ps, m[i].id, m[i].to, s, n)
+				}
+			}
+		}
+		t.node[m[i].id] = m[i].to
+	}
+
+	return d, nil
+}
+
+// moveDeep adds n links of spec s to class y and its ancestors up to class
+// id, which is moved.
+func moveDeep(d, cur deepDelta, t *classTree, ps string, id, y, s, n int64) {
+	for _, a := range t.path(y) {
+		if a == id {
+			break
+		}
+		key := deepKey(t.p, a, ps)
+		d.addKey(key, s, n)
+		if cur[key] != nil {
+			cur[key][s] += n
+		}
+	}
+}
+
+// RebuildClosure rebuilds deep sets of the given class prefixes, all if
+// empty, from spec links. The number of classes with deep sets of each
+// prefix is passed to report.
+func RebuildClosure(r rediser, ns, rel string, prefixes []string, report func(string, int) error) error {
+	if len(prefixes) == 0 {
+		prefixes = classPrefixes
+	}
+	for _, p := range prefixes {
+		if !isClassPrefix(p) {
+			return fmt.Errorf("closure: unknown prefix %q", p)
+		}
+	}
+
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	for _, p := range prefixes {
+		n, err := rebuildClosure(c, p)
+		if err != nil {
+			return fmt.Errorf("closure %s: %v", p, err)
+		}
+
+		err = report(p, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebuildClosure replaces deep sets of p in one transaction, it is retried if
+// classes or specs were changed meanwhile.
+func rebuildClosure(c redis.Conn, p string) (int, error) {
+	for i := 0; i < watchAttempts; i++ {
+		n, err := rebuildClosureOnce(c, p)
+		if err == errConflict {
+			continue
+		}
+		return n, err
+	}
+
+	return 0, errConflict
+}
+
+func rebuildClosureOnce(c redis.Conn, p string) (int, error) {
+	st := newStorage(c)
+	keys := []interface{}{genKey(p, "sync")}
+	for _, ps := range deepPrefixes {
+		keys = append(keys, genKey(ps, "sync"))
+	}
+	_, err := c.Do("WATCH", keys...)
+	if err != nil {
+		return 0, err
+	}
+
+	ids, err := st.loadSyncIDs(p, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	t := newClassTree(p)
+	err = t.load(c, ids, false)
+	if err != nil {
+		return 0, err
+	}
+
+	d := make(deepDelta)
+	for _, ps := range deepPrefixes {
+		s, err := st.loadSyncIDs(ps, 0)
+		if err != nil {
+			return 0, err
+		}
+		x, err := st.loadLinkSets(ps, s, p)
+		if err != nil {
+			return 0, err
+		}
+		for i := range s {
+			for _, y := range x[i][p] {
+				d.add(t, ps, y, s[i], 1)
+			}
+		}
+	}
+
+	old, err := scanKeys(c, genKey(p, "*", deepLink, "*"))
+	if err != nil {
+		return 0, err
+	}
+
+	err = multiExec(c, func() error {
+		if len(old) > 0 {
+			err := c.Send("DEL", old...)
+			if err != nil {
+				return err
+			}
+		}
+		return d.save(c)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := make(map[int64]bool)
+	for k := range d {
+		n[parseDeepKey(p, k)] = true
+	}
+
+	return len(n), nil
+}
+
+// parseDeepKey returns class id of deep set of p.
+func parseDeepKey(p, key string) int64 {
+	s := strings.SplitN(key[len(p)+1:], ":", 2)
+	id, _ := strconv.ParseInt(s[0], 10, 64)
+	return id
+}
+
+// scanKeys returns keys matching pattern.
+func scanKeys(c redis.Conn, pattern string) ([]interface{}, error) {
+	var res []interface{}
+	var next int
+	for done := false; !done; {
+		v, err := redis.Values(c.Do("SCAN", next, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return nil, err
+		}
+
+		next, _ = redis.Int(v[0], nil)
+		keys, err := redis.Strings(v[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		done = next == 0
+
+		for _, k := range keys {
+			res = append(res, k)
+		}
+	}
+
+	return res, nil
+}
+
+// buildClosure builds deep sets of all class prefixes.
+func buildClosure(c redis.Conn) error {
+	for _, p := range classPrefixes {
+		_, err := rebuildClosure(c, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropClosure removes deep sets of all class prefixes.
+func dropClosure(c redis.Conn) error {
+	for _, p := range classPrefixes {
+		keys, err := scanKeys(c, genKey(p, "*", deepLink, "*"))
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			_, err = c.Do("DEL", keys...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
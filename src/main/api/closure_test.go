@@ -0,0 +1,112 @@
+package api
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// checkClosure compares deep sets kept by writes with the ones rebuilt from
+// spec links.
+func checkClosure(t *testing.T, a *testAPI, step string) {
+	t.Helper()
+
+	deep := func() map[string]string {
+		res := make(map[string]string)
+		for k, v := range dumpStore(t, a.s) {
+			if strings.Contains(k, ":"+deepLink+":") {
+				res[k] = v
+			}
+		}
+		return res
+	}
+
+	want := deep()
+	err := RebuildClosure(a.s, "", "", []string{prefixClassATC}, func(string, int) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := deep()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: kept\n%v\nrebuilt\n%v", step, want, got)
+	}
+}
+
+func deepIDs(t *testing.T, a *testAPI, id int64) map[int64]int64 {
+	t.Helper()
+	c := a.s.Get()
+	defer c.Close()
+	v, err := redis.Int64Map(c.Do("ZRANGE", deepKey(prefixClassATC, id, prefixSpecINF), 0, -1, "WITHSCORES"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[int64]int64, len(v))
+	for k, n := range v {
+		x, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[x] = n
+	}
+	return res
+}
+
+// newClosureAPI writes tree 1 > 2 > 3 with class 4 at the root, spec 100 is
+// linked to classes 2 and 3, spec 101 to class 3.
+func newClosureAPI(t *testing.T) *testAPI {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-class-atc", `[{"id":1,"name_ru":"A"},{"id":4,"name_ru":"D"}]`)
+	a.ok(nil, "/set-class-atc", `[{"id":2,"id_node":1,"name_ru":"B"}]`)
+	a.ok(nil, "/set-class-atc", `[{"id":3,"id_node":2,"name_ru":"C"}]`)
+	a.ok(nil, "/set-spec-inf", `[{"id":100,"name_ru":"Панадол","id_class_atc":[2,3]},{"id":101,"name_ru":"Нурофен","id_class_atc":[3]}]`)
+
+	if v := deepIDs(t, a, 1); !reflect.DeepEqual(v, map[int64]int64{100: 2, 101: 1}) {
+		t.Fatalf("deep of 1: %v", v)
+	}
+	checkClosure(t, a, "write")
+	return a
+}
+
+func TestClosureMove(t *testing.T) {
+	a := newClosureAPI(t)
+
+	a.ok(nil, "/set-class-atc", `[{"id":2,"id_node":4,"name_ru":"B"}]`)
+	if v := deepIDs(t, a, 1); len(v) != 0 {
+		t.Errorf("deep of old parent: %v", v)
+	}
+	if v := deepIDs(t, a, 4); !reflect.DeepEqual(v, map[int64]int64{100: 2, 101: 1}) {
+		t.Errorf("deep of new parent: %v", v)
+	}
+	checkClosure(t, a, "move")
+}
+
+func TestClosureUnlink(t *testing.T) {
+	a := newClosureAPI(t)
+
+	a.ok(nil, "/set-spec-inf", `[{"id":100,"name_ru":"Панадол","id_class_atc":[2]}]`)
+	if v := deepIDs(t, a, 3); !reflect.DeepEqual(v, map[int64]int64{101: 1}) {
+		t.Errorf("deep of unlinked class: %v", v)
+	}
+	if v := deepIDs(t, a, 1); !reflect.DeepEqual(v, map[int64]int64{100: 1, 101: 1}) {
+		t.Errorf("deep of ancestor: %v", v)
+	}
+	checkClosure(t, a, "unlink")
+}
+
+func TestClosureRestore(t *testing.T) {
+	a := newClosureAPI(t)
+
+	a.ok(nil, "/del-class-atc", `[3]`)
+	if v := deepIDs(t, a, 1); !reflect.DeepEqual(v, map[int64]int64{100: 1}) {
+		t.Errorf("deep of ancestor after delete: %v", v)
+	}
+
+	a.ok(nil, "/restore-class-atc", `[3]`)
+	if v := deepIDs(t, a, 1); !reflect.DeepEqual(v, map[int64]int64{100: 2, 101: 1}) {
+		t.Errorf("deep of ancestor after restore: %v", v)
+	}
+	checkClosure(t, a, "restore")
+}
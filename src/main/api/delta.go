@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"internal/ctxutil"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	deltaLimit    = 500
+	deltaMaxLimit = 5000
+)
+
+// errCursorAhead is returned for cursor beyond the last change, such cursor
+// is not given by get-sync.
+var errCursorAhead = fmt.Errorf("sync cursor is ahead of the last change")
+
+type deltaKind struct {
+	name string
+	p    string
+	get  func(*ctxHelper, string) (interface{}, error)
+}
+
+// deltaKinds are ordered so that linked entities go before specs.
+var deltaKinds = []deltaKind{
+	{"class_atc", prefixClassATC, getClassXAny},
+	{"class_nfc", prefixClassNFC, getClassXAny},
+	{"class_fsc", prefixClassFSC, getClassXAny},
+	{"class_bfc", prefixClassBFC, getClassXAny},
+	{"class_cfc", prefixClassCFC, getClassXAny},
+	{"class_mpc", prefixClassMPC, getClassXAny},
+	{"class_csc", prefixClassCSC, getClassXAny},
+	{"class_icd", prefixClassICD, getClassXAny},
+	{"inn", prefixINN, getINNXAny},
+	{"maker", prefixMaker, getMakerXAny},
+	{"drug", prefixDrug, getDrugXAny},
+	{"spec_act", prefixSpecACT, getSpecXAny},
+	{"spec_inf", prefixSpecINF, getSpecXAny},
+	{"spec_dec", prefixSpecDEC, getSpecXAny},
+}
+
+// jsonDelta is request and response of get-sync. Cursors are values of change
+// counters of each kind, all kinds from the beginning if empty.
+type jsonDelta struct {
+	Cursor map[string]int64       `json:"cursor"`
+	Limit  int                    `json:"limit,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Del    map[string][]int64     `json:"del,omitempty"`
+	More   bool                   `json:"more"`
+}
+
+// getSync answers with changed entities and deleted ids of all kinds since
+// the given cursors, at most limit changes per response.
+func getSync(h *ctxHelper) (interface{}, error) {
+	var v jsonDelta
+	err := json.Unmarshal(h.data, &v)
+	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, err
+	}
+
+	if v.Limit <= 0 {
+		v.Limit = deltaLimit
+	}
+	if v.Limit > deltaMaxLimit {
+		v.Limit = deltaMaxLimit
+	}
+
+	kinds := deltaKinds
+	if len(v.Cursor) > 0 {
+		for k, cur := range v.Cursor {
+			if _, ok := findDeltaKind(k); !ok {
+				h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+				return nil, fmt.Errorf("unknown kind %q", k)
+			}
+			if cur < 0 {
+				h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+				return nil, fmt.Errorf("negative cursor of %s", k)
+			}
+		}
+
+		kinds = nil
+		for _, k := range deltaKinds {
+			if _, ok := v.Cursor[k.name]; ok {
+				kinds = append(kinds, k)
+			}
+		}
+	}
+
+	res := &jsonDelta{
+		Cursor: make(map[string]int64, len(kinds)),
+		Data:   make(map[string]interface{}),
+		Del:    make(map[string][]int64),
+	}
+
+	n := v.Limit
+	for _, k := range kinds {
+		cur := v.Cursor[k.name]
+		if n == 0 {
+			res.Cursor[k.name] = cur
+			res.More = true
+			continue
+		}
+
+		upd, del, next, more, err := loadDelta(h, k.p, cur, n)
+		if err == errCursorAhead {
+			h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+			return nil, fmt.Errorf("cursor of %s: %v", k.name, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.Cursor[k.name] = next
+		res.More = res.More || more
+		n -= len(upd) + len(del)
+
+		if len(upd) > 0 {
+			x := h.clone()
+			x.data = int64sToJSON(upd)
+			res.Data[k.name], err = k.get(x, k.p)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(del) > 0 {
+			res.Del[k.name] = del
+		}
+	}
+
+	return res, nil
+}
+
+// loadDelta returns at most n ids updated and deleted after cursor v in the
+// order of changes, the cursor for the next request and if there are more.
+// Cursor beyond the last change is rejected with errCursorAhead.
+func loadDelta(h *ctxHelper, p string, v int64, n int) ([]int64, []int64, int64, bool, error) {
+	c := h.getConn()
+	defer h.delConn(c)
+
+	return newStorage(c).loadSyncDelta(p, v, n)
+}
+
+func (st redisStorage) loadSyncDelta(p string, v int64, n int) ([]int64, []int64, int64, bool, error) {
+	c := st.c
+	key := genKey(p, "sync", "rev")
+	err := c.Send("MULTI")
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+	err = c.Send("GET", genKey(p, "sync", "rev", "horizon"))
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+	err = c.Send("GET", genKey(p, "sync", "seq"))
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+	err = c.Send("ZRANGEBYSCORE", key, "("+strconv.FormatInt(v, 10), "+inf", "WITHSCORES", "LIMIT", 0, n+1)
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+	// deleted ids are not needed by clients without data
+	if v > 0 {
+		err = c.Send("ZREVRANGEBYSCORE", key, "("+strconv.FormatInt(-v, 10), "-inf", "WITHSCORES", "LIMIT", 0, n+1)
+		if err != nil {
+			return nil, nil, 0, false, err
+		}
+	}
+
+	res, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+
+	t, err := redis.Int64(res[0], nil)
+	if err != nil && err != redis.ErrNil {
+		return nil, nil, 0, false, err
+	}
+	if v != 0 && v < t {
+		return nil, nil, 0, false, errResync
+	}
+
+	seq, err := redis.Int64(res[1], nil)
+	if err != nil && err != redis.ErrNil {
+		return nil, nil, 0, false, err
+	}
+	if v > seq {
+		return nil, nil, 0, false, errCursorAhead
+	}
+
+	a, err := redis.Int64s(res[2], nil)
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+	var b []int64
+	if v > 0 {
+		b, err = redis.Int64s(res[3], nil)
+		if err != nil {
+			return nil, nil, 0, false, err
+		}
+	}
+
+	// merge both lists by absolute value of score
+	var upd, del []int64
+	var last int64
+	for i, j := 0, 0; len(upd)+len(del) < n && (i < len(a) || j < len(b)); {
+		if j >= len(b) || (i < len(a) && a[i+1] < -b[j+1]) {
+			upd = append(upd, a[i])
+			last = a[i+1]
+			i += 2
+		} else {
+			del = append(del, b[j])
+			last = -b[j+1]
+			j += 2
+		}
+	}
+
+	// one more change is loaded to know if there are more
+	if len(a)/2+len(b)/2 <= n {
+		return upd, del, seq, false, nil
+	}
+
+	return upd, del, last, true, nil
+}
+
+func findDeltaKind(name string) (deltaKind, bool) {
+	for i := range deltaKinds {
+		if deltaKinds[i].name == name {
+			return deltaKinds[i], true
+		}
+	}
+	return deltaKind{}, false
+}
+
+func getClassXAny(h *ctxHelper, p string) (interface{}, error) {
+	return getClassX(h, p)
+}
+
+func getINNXAny(h *ctxHelper, p string) (interface{}, error) {
+	return getINNX(h, p)
+}
+
+func getMakerXAny(h *ctxHelper, p string) (interface{}, error) {
+	return getMakerX(h, p)
+}
+
+func getDrugXAny(h *ctxHelper, p string) (interface{}, error) {
+	return getDrugX(h, p)
+}
+
+func getSpecXAny(h *ctxHelper, p string) (interface{}, error) {
+	return getSpecX(h, p)
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// deltaIDs returns ids of entities of kind in answer of get-sync.
+func deltaIDs(t *testing.T, d jsonDelta, kind string) []int64 {
+	if d.Data[kind] == nil {
+		return nil
+	}
+	b, err := json.Marshal(d.Data[kind])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v []struct {
+		ID int64 `json:"id"`
+	}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make([]int64, len(v))
+	for i := range v {
+		res[i] = v[i].ID
+	}
+	return res
+}
+
+// TestDeltaMerge checks that updates and deletions are merged in the order
+// of changes and deletions are given after the first sync only.
+func TestDeltaMerge(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"}]`)            // 1
+	a.ok(nil, "/set-inn", `[{"id":2,"name_ru":"Аспирин"}]`)                // 2
+	a.ok(nil, "/set-inn", `[{"id":3,"name_ru":"Ибупрофен"}]`)              // 3
+	a.ok(nil, "/del-inn", `[2]`)                                           // 4
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол","slug":"p"}]`) // 5
+
+	var d jsonDelta
+	a.ok(&d, "/get-sync", `{"cursor":{"inn":1}}`)
+	if ids := deltaIDs(t, d, "inn"); !reflect.DeepEqual(ids, []int64{3, 1}) {
+		t.Errorf("updated %v", ids)
+	}
+	if !reflect.DeepEqual(d.Del["inn"], []int64{2}) || d.Cursor["inn"] != 5 || d.More {
+		t.Errorf("delta %+v", d)
+	}
+
+	// the limit cuts the page by the order of changes
+	d = jsonDelta{}
+	a.ok(&d, "/get-sync", `{"cursor":{"inn":2},"limit":2}`)
+	if ids := deltaIDs(t, d, "inn"); !reflect.DeepEqual(ids, []int64{3}) {
+		t.Errorf("updated with limit %v", ids)
+	}
+	if !reflect.DeepEqual(d.Del["inn"], []int64{2}) || d.Cursor["inn"] != 4 || !d.More {
+		t.Errorf("delta with limit %+v", d)
+	}
+
+	d = jsonDelta{}
+	a.ok(&d, "/get-sync", `{"cursor":{"inn":0}}`)
+	if ids := deltaIDs(t, d, "inn"); !reflect.DeepEqual(ids, []int64{3, 1}) {
+		t.Errorf("updated from the beginning %v", ids)
+	}
+	if len(d.Del) != 0 || d.Cursor["inn"] != 5 || d.More {
+		t.Errorf("delta from the beginning %+v", d)
+	}
+}
+
+// TestDeltaLimit splits the page between kinds and follows more cursors
+// until all changes are given once.
+func TestDeltaLimit(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"},{"id":2,"name_ru":"Аспирин"},{"id":3,"name_ru":"Ибупрофен"}]`)
+	a.ok(nil, "/set-maker", `[{"id":1,"name_ru":"Байер"},{"id":2,"name_ru":"Дарница"}]`)
+
+	var d jsonDelta
+	a.ok(&d, "/get-sync", `{"cursor":{"inn":0,"maker":0},"limit":4}`)
+	if ids := deltaIDs(t, d, "inn"); len(ids) != 3 {
+		t.Errorf("inn %v", ids)
+	}
+	if ids := deltaIDs(t, d, "maker"); len(ids) != 1 {
+		t.Errorf("maker %v", ids)
+	}
+	if d.Cursor["inn"] != 3 || d.Cursor["maker"] != 1 || !d.More {
+		t.Fatalf("first page %+v", d)
+	}
+
+	seen := map[string][]int64{
+		"inn":   deltaIDs(t, d, "inn"),
+		"maker": deltaIDs(t, d, "maker"),
+	}
+	for n := 0; d.More; n++ {
+		if n > 5 {
+			t.Fatal("more is never false")
+		}
+		b, _ := json.Marshal(jsonDelta{Cursor: d.Cursor, Limit: 1})
+		d = jsonDelta{}
+		a.ok(&d, "/get-sync", string(b))
+		for k := range seen {
+			seen[k] = append(seen[k], deltaIDs(t, d, k)...)
+		}
+	}
+
+	if len(seen["inn"]) != 3 || len(seen["maker"]) != 2 {
+		t.Errorf("seen %v", seen)
+	}
+	if d.Cursor["inn"] != 3 || d.Cursor["maker"] != 2 {
+		t.Errorf("last cursor %+v", d.Cursor)
+	}
+}
+
+func TestDeltaBadCursor(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"}]`)
+
+	for _, body := range []string{
+		`{"cursor":{"inn":-1}}`,
+		`{"cursor":{"inn":2}}`,
+		`{"cursor":{"inn":"1"}}`,
+		`{"cursor":{"inn":1.5}}`,
+		`{"cursor":{"nope":0}}`,
+	} {
+		w := a.call("/get-sync", body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", body, w.Code, w.Body.String())
+		}
+	}
+
+	var d jsonDelta
+	a.ok(&d, "/get-sync", `{"cursor":{"inn":1}}`)
+	if len(d.Data) != 0 || d.Cursor["inn"] != 1 || d.More {
+		t.Errorf("delta at the last change %+v", d)
+	}
+}
@@ -45,6 +45,7 @@ type jsonDrug struct {
 	MakeEN    string  `json:"make_en,omitempty"`
 	Quant     float64 `json:"q,omitempty"`
 	Value     float64 `json:"v,omitempty"`
+	Rev       int64   `json:"rev,omitempty"`
 }
 
 type jsonDrugSale struct {
@@ -60,6 +61,13 @@ func (j *jsonDrug) getID() int64 {
 	return j.ID
 }
 
+func (j *jsonDrug) getRev() int64 {
+	if j == nil {
+		return 0
+	}
+	return j.Rev
+}
+
 func (j *jsonDrug) lang(l, _ string) {
 	switch l {
 	case "ru":
@@ -136,6 +144,7 @@ func (j *jsonDrug) getFields(_ bool) []interface{} {
 		"make_en", // 19
 		"quant",   // 20
 		"value",   // 21
+		"rev",     // 22
 	}
 }
 
@@ -166,6 +175,7 @@ func (j *jsonDrug) getValues() []interface{} {
 		j.MakeEN, // 19
 		j.Quant,  // 20
 		j.Value,  // 21
+		j.Rev,    // 22
 	}
 }
 
@@ -222,6 +232,8 @@ func (j *jsonDrug) setValues(_ bool, v ...interface{}) {
 			j.Quant, _ = redis.Float64(v[i], nil)
 		case 21:
 			j.Value, _ = redis.Float64(v[i], nil)
+		case 22:
+			j.Rev, _ = redis.Int64(v[i], nil)
 		}
 	}
 }
@@ -244,6 +256,10 @@ func (j jsonDrugs) nill(i int) {
 	j[i] = nil
 }
 
+func (v jsonDrugs) pageKey(i int, _ bool) pageKey {
+	return pageKey{Rank: v[i].Value, Name: v[i].Name}
+}
+
 func (v jsonDrugs) sort(lang string) {
 	coll := newCollator(lang)
 	sort.Slice(v,
@@ -262,7 +278,10 @@ func (v jsonDrugs) sort(lang string) {
 			} else if v[i].Value < v[j].Value {
 				return false
 			}
-			return coll.CompareString(v[i].Name, v[j].Name) < 0
+			if n := coll.CompareString(v[i].Name, v[j].Name); n != 0 {
+				return n < 0
+			}
+			return v[i].ID < v[j].ID
 		},
 	)
 }
@@ -288,35 +307,30 @@ func makeDrugsFromIDs(v []int64, err error) (jsonDrugs, error) {
 }
 
 func loadDrugLinks(c redis.Conn, p string, v []*jsonDrug) error {
-	var err error
+	ids := make([]int64, len(v))
 	for i := range v {
-		if v[i] == nil {
-			continue
-		}
-
-		v[i].IDSpecDEC, err = loadLinkIDs(c, p, prefixSpecDEC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDSpecINF, err = loadLinkIDs(c, p, prefixSpecINF, v[i].ID)
-		if err != nil {
-			return err
+		if v[i] != nil {
+			ids[i] = v[i].ID
 		}
 	}
-	return nil
-}
 
-func getDrugXSync(h *ctxHelper, p string) ([]int64, error) {
-	v, err := int64FromJSON(h.data)
+	x, err := newStorage(c).loadLinkSets(p, ids, prefixSpecDEC, prefixSpecINF)
 	if err != nil {
-		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
-		return nil, err
+		return err
 	}
 
-	c := h.getConn()
-	defer h.delConn(c)
+	for i := range v {
+		if v[i] == nil {
+			continue
+		}
+		v[i].IDSpecDEC = x[i][prefixSpecDEC]
+		v[i].IDSpecINF = x[i][prefixSpecINF]
+	}
+	return nil
+}
 
-	return loadSyncIDs(c, p, v)
+func getDrugXSync(h *ctxHelper, p string) (interface{}, error) {
+	return getSyncX(h, p)
 }
 
 func getDrugX(h *ctxHelper, p string) (jsonDrugs, error) {
@@ -329,7 +343,7 @@ func getDrugX(h *ctxHelper, p string) (jsonDrugs, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v)
+	err = newStorage(c).loadHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
@@ -354,16 +368,12 @@ func getDrugXList(h *ctxHelper, p string) (jsonDrugs, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v, true)
+	x, err := loadList(h, c, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	normLang(h.lang, p, v)
-
-	v.sort(h.lang)
-
-	return v, nil
+	return x.(jsonDrugs), nil
 }
 
 func setDrugX(h *ctxHelper, p string) (interface{}, error) {
@@ -376,7 +386,26 @@ func setDrugX(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = saveHashers(c, p, v)
+	err = newStorage(c).watchHashers(h, p, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.patch {
+		b, err := mergePatch(h, c, p, newDrugHasher)
+		if err != nil {
+			return nil, err
+		}
+		v, err = makeDrugsFromJSON(b)
+		if err != nil {
+			h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+			return nil, err
+		}
+	}
+
+	err = multiExec(c, func() error {
+		return newStorage(c).saveHashers(h, p, v)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -394,7 +423,14 @@ func setDrugXSale(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = saveHashers(c, p, v, true)
+	err = newStorage(c).watchHashers(h, p, v, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = multiExec(c, func() error {
+		return newStorage(c).saveHashers(h, p, v, true)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -412,12 +448,19 @@ func delDrugX(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v)
+	err = newStorage(c).watchHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	err = freeHashers(c, p, v)
+	err = newStorage(c).loadHashers(h, p, v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = multiExec(c, func() error {
+		return newStorage(c).freeHashers(h, p, v)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -450,3 +493,7 @@ func setDrugSale(h *ctxHelper) (interface{}, error) {
 func delDrug(h *ctxHelper) (interface{}, error) {
 	return delDrugX(h, prefixDrug)
 }
+
+func restoreDrug(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixDrug)
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"internal/ctxutil"
+	"internal/mdware"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	keyChanges  = "changes"
+	feedMaxLen  = 100000
+	feedBatch   = 100
+	feedBlockMS = 1000
+)
+
+var feedIDRe = regexp.MustCompile(`^(\$|\d+(-\d+)?)$`)
+
+// jsonChange is event of change feed.
+type jsonChange struct {
+	ID   string `json:"-"`
+	Kind string `json:"kind"`
+	Item int64  `json:"id"`
+	Op   string `json:"op"`
+	User string `json:"user,omitempty"`
+}
+
+// addChange appends change event to the feed, it is sent with the change in
+// one transaction.
+func addChange(h *ctxHelper, c redis.Conn, p string, id int64, op string) error {
+	user := ""
+	if h != nil {
+		if h.quiet {
+			return nil
+		}
+		user = ctxutil.AuthFrom(h.ctx)
+	}
+
+	return c.Send("XADD", keyChanges, "MAXLEN", "~", feedMaxLen, "*",
+		"kind", p,
+		"id", id,
+		"op", op,
+		"user", user,
+	)
+}
+
+// addLinkChange appends change event of linked entity, root of class tree is
+// not an entity.
+func addLinkChange(h *ctxHelper, c redis.Conn, p string, id int64, op string) error {
+	if id == 0 {
+		return nil
+	}
+	return addChange(h, c, p, id, op)
+}
+
+// getChanges streams change events as server-sent events starting after the
+// stream ID from query parameter from or Last-Event-ID header, only new ones
+// by default. Event release ends the stream of the live release replaced by
+// publish or rollback.
+func getChanges(h *ctxHelper) (interface{}, error) {
+	last := h.r.URL.Query().Get("from")
+	if last == "" {
+		last = h.r.Header.Get("Last-Event-ID")
+	}
+	if last == "" {
+		last = "$"
+	}
+	if !feedIDRe.MatchString(last) {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, fmt.Errorf("invalid stream ID %q", last)
+	}
+
+	c := h.getConn()
+	defer h.delConn(c)
+
+	if last == "$" {
+		var err error
+		last, err = lastChangeID(c, keyChanges)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w := h.w
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, err := fmt.Fprintf(w, ": from %s\n\n", last)
+	if err != nil {
+		return mdware.Written, nil
+	}
+	flush(w)
+
+	// context of request is not canceled on disconnect, so failed write of
+	// ping after each empty block stops the loop; the stream of the live
+	// release ends after publish and rollback, so clients reconnect to the
+	// new one
+	for {
+		select {
+		case <-h.ctx.Done():
+			return mdware.Written, nil
+		default:
+		}
+
+		if h.moved != nil && h.moved() {
+			_, _ = fmt.Fprint(w, "event: release\ndata: {}\n\n")
+			flush(w)
+			return mdware.Written, nil
+		}
+
+		v, err := redis.Values(c.Do("XREAD", "COUNT", feedBatch, "BLOCK", feedBlockMS, "STREAMS", keyChanges, last))
+		if err == redis.ErrNil {
+			_, err = fmt.Fprint(w, ": ping\n\n")
+			if err != nil {
+				return mdware.Written, nil
+			}
+			flush(w)
+			continue
+		}
+		if err != nil {
+			h.log.Printf("changes: %v", err)
+			return mdware.Written, nil
+		}
+
+		for i := range v {
+			s, err := redis.Values(v[i], nil)
+			if err != nil || len(s) != 2 {
+				continue
+			}
+			x, err := redis.Values(s[1], nil)
+			if err != nil {
+				continue
+			}
+			e, err := parseChanges(x)
+			if err != nil {
+				h.log.Printf("changes: %v", err)
+				return mdware.Written, nil
+			}
+			for j := range e {
+				b, _ := json.Marshal(e[j])
+				_, err = fmt.Fprintf(w, "id: %s\nevent: change\ndata: %s\n\n", e[j].ID, b)
+				if err != nil {
+					return mdware.Written, nil
+				}
+				last = e[j].ID
+			}
+		}
+		flush(w)
+	}
+}
+
+func parseChanges(v []interface{}) ([]jsonChange, error) {
+	res := make([]jsonChange, 0, len(v))
+	for i := range v {
+		e, err := redis.Values(v[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) != 2 {
+			return nil, fmt.Errorf("invalid stream entry")
+		}
+
+		id, err := redis.String(e[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		f, err := redis.StringMap(e[1], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		x, _ := strconv.ParseInt(f["id"], 10, 64)
+		res = append(res, jsonChange{id, f["kind"], x, f["op"], f["user"]})
+	}
+
+	return res, nil
+}
+
+func lastChangeID(c redis.Conn, key string) (string, error) {
+	v, err := redis.Values(c.Do("XREVRANGE", key, "+", "-", "COUNT", 1))
+	if err != nil || len(v) == 0 {
+		return "0-0", err
+	}
+	e, err := parseChanges(v)
+	if err != nil {
+		return "", err
+	}
+	return e[0].ID, nil
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
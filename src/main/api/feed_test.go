@@ -0,0 +1,96 @@
+package api
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// countGets counts connections taken from r.
+type countGets struct {
+	r rediser
+	n *int64
+}
+
+func (r countGets) Get() redis.Conn {
+	atomic.AddInt64(r.n, 1)
+	return r.r.Get()
+}
+
+func TestChangesPool(t *testing.T) {
+	a := newTestAPI(t)
+	var main, streams int64
+	b := newTestAPI(t, Redis(countGets{a.s, &main}), Streams(countGets{a.s, &streams}))
+
+	w := &closedWriter{ResponseRecorder: httptest.NewRecorder(), n: 1}
+	b.h.ServeHTTP(w, httptest.NewRequest("GET", "/changes", nil))
+
+	if streams == 0 || main != 0 {
+		t.Fatalf("stream connections: %d, main: %d", streams, main)
+	}
+}
+
+func TestChangesGone(t *testing.T) {
+	a := newTestAPI(t)
+	w := &closedWriter{ResponseRecorder: httptest.NewRecorder()}
+	a.h.ServeHTTP(w, httptest.NewRequest("GET", "/changes", nil))
+
+	if w.headers != 1 {
+		t.Fatalf("header is written %d times", w.headers)
+	}
+}
+
+func TestChangesPublish(t *testing.T) {
+	a := newTestAPI(t)
+	err := OpenRelease(a.s, "", "r1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		a.h.ServeHTTP(w, httptest.NewRequest("GET", "/changes", nil))
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	err = PublishRelease(a.s, "", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream is not ended by publish")
+	}
+	if !strings.Contains(w.Body.String(), "event: release\n") {
+		t.Fatalf("stream: %q", w.Body.String())
+	}
+}
+
+// closedWriter fails writes after n ones, like connection of gone client.
+type closedWriter struct {
+	*httptest.ResponseRecorder
+	n       int
+	headers int
+}
+
+func (w *closedWriter) WriteHeader(code int) {
+	w.headers++
+	w.ResponseRecorder.WriteHeader(code)
+}
+
+func (w *closedWriter) Write(b []byte) (int, error) {
+	if w.n == 0 {
+		return 0, io.ErrClosedPipe
+	}
+	w.n--
+	return w.ResponseRecorder.Write(b)
+}
@@ -0,0 +1,560 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Problem is inconsistency of catalog found by Fsck.
+type Problem struct {
+	Check    string `json:"check"`
+	Key      string `json:"key"`
+	Member   string `json:"member,omitempty"`
+	Repaired bool   `json:"repaired,omitempty"`
+}
+
+var fsckPrefixes = []string{
+	prefixClassATC, prefixClassNFC, prefixClassFSC, prefixClassBFC,
+	prefixClassCFC, prefixClassMPC, prefixClassCSC, prefixClassICD,
+	prefixINN, prefixMaker, prefixDrug,
+	prefixSpecACT, prefixSpecINF, prefixSpecDEC,
+}
+
+func isSpecPrefix(p string) bool {
+	return p == prefixSpecACT || p == prefixSpecINF || p == prefixSpecDEC
+}
+
+func isClassPrefix(p string) bool {
+	return strings.HasPrefix(p, "class:")
+}
+
+type fsck struct {
+	c      redis.Conn
+	repair bool
+	report func(Problem) error
+	count  int
+
+	hashes map[string]map[int64]bool  // prefix -> ids
+	trash  map[string]map[int64]bool  // prefix -> ids in trash
+	links  map[string]map[string]bool // key -> members
+	owners map[string][2]string       // link key -> prefix of owner, prefix of members
+	nodes  map[string]map[int64]int64 // class prefix -> id -> id_node
+	makeGP map[string]map[int64]int64 // spec prefix -> id -> id_make_gp
+}
+
+// Fsck checks links, search keys, sync sets and class trees of catalog, each
+// found problem is passed to report. Problems are fixed if repair is true.
+// It returns the number of found problems.
+func Fsck(r rediser, ns, rel string, repair bool, report func(Problem) error) (int, error) {
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return 0, err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	return runFsck(c, repair, report)
+}
+
+func runFsck(c redis.Conn, repair bool, report func(Problem) error) (int, error) {
+	f := &fsck{
+		c:      c,
+		repair: repair,
+		report: report,
+		hashes: make(map[string]map[int64]bool),
+		trash:  make(map[string]map[int64]bool),
+		links:  make(map[string]map[string]bool),
+		owners: make(map[string][2]string),
+		nodes:  make(map[string]map[int64]int64),
+		makeGP: make(map[string]map[int64]int64),
+	}
+
+	for _, fn := range []func() error{
+		f.loadKeys,
+		f.loadTrash,
+		f.loadLinks,
+		f.loadFields,
+		f.checkLinks,
+		f.checkTree,
+		f.checkSearch,
+		f.checkSync,
+		f.checkSyncRev,
+	} {
+		err := fn()
+		if err != nil {
+			return f.count, err
+		}
+	}
+
+	return f.count, nil
+}
+
+// problem reports problem, which is fixed by the given command.
+func (f *fsck) problem(check, key, member string, fix ...interface{}) error {
+	var fn func() error
+	if len(fix) > 0 {
+		fn = func() error {
+			_, err := f.c.Do(fmt.Sprint(fix[0]), fix[1:]...)
+			return err
+		}
+	}
+	return f.problemFunc(check, key, member, fn)
+}
+
+// problemFunc reports problem, which is fixed by fn unless it is nil.
+func (f *fsck) problemFunc(check, key, member string, fn func() error) error {
+	f.count++
+
+	p := Problem{Check: check, Key: key, Member: member}
+	if f.repair && fn != nil {
+		err := fn()
+		if err != nil {
+			return err
+		}
+		p.Repaired = true
+	}
+
+	return f.report(p)
+}
+
+func (f *fsck) hasHash(p string, id int64) bool {
+	return f.hashes[p][id]
+}
+
+// isKept reports whether entity exists or can be restored from trash.
+func (f *fsck) isKept(p string, id int64) bool {
+	return f.hashes[p][id] || f.trash[p][id]
+}
+
+// loadKeys scans keyspace and sorts keys into hashes and links.
+func (f *fsck) loadKeys() error {
+	prefixes := append([]string(nil), fsckPrefixes...)
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	var next int
+	for done := false; !done; {
+		v, err := redis.Values(f.c.Do("SCAN", next, "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+
+		next, _ = redis.Int(v[0], nil)
+		keys, err := redis.Strings(v[1], nil)
+		if err != nil {
+			return err
+		}
+		done = next == 0
+
+		for _, k := range keys {
+			for _, p := range prefixes {
+				if !strings.HasPrefix(k, p+":") {
+					continue
+				}
+
+				s := strings.SplitN(k[len(p)+1:], ":", 2)
+				id, err := strconv.ParseInt(s[0], 10, 64)
+				if err != nil {
+					break // sync, srch, abcd, rune
+				}
+
+				if len(s) == 2 && strings.HasPrefix(s[1], deepLink+":") {
+					break // see RebuildClosure
+				}
+
+				if len(s) == 1 {
+					if f.hashes[p] == nil {
+						f.hashes[p] = make(map[int64]bool)
+					}
+					f.hashes[p][id] = true
+				} else {
+					f.owners[k] = [2]string{p, s[1]}
+				}
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *fsck) loadTrash() error {
+	for _, p := range fsckPrefixes {
+		ids, err := redis.Int64s(f.c.Do("ZRANGE", trashKey(p), 0, -1))
+		if err != nil {
+			return err
+		}
+		f.trash[p] = make(map[int64]bool, len(ids))
+		for _, id := range ids {
+			f.trash[p][id] = true
+		}
+	}
+
+	return nil
+}
+
+func (f *fsck) loadLinks() error {
+	keys := make([]string, 0, len(f.owners))
+	for k := range f.owners {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		err := f.c.Send("SMEMBERS", k)
+		if err != nil {
+			return err
+		}
+	}
+	err := f.c.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		v, err := redis.Strings(f.c.Receive())
+		if err != nil {
+			return err
+		}
+		f.links[k] = make(map[string]bool, len(v))
+		for i := range v {
+			f.links[k][v[i]] = true
+		}
+	}
+
+	return nil
+}
+
+// loadFields loads id_node of classes and id_make_gp of specs.
+func (f *fsck) loadFields() error {
+	for _, p := range fsckPrefixes {
+		field := ""
+		switch {
+		case isClassPrefix(p):
+			field = "id_node"
+			f.nodes[p] = make(map[int64]int64)
+		case isSpecPrefix(p):
+			field = "id_make_gp"
+			f.makeGP[p] = make(map[int64]int64)
+		default:
+			continue
+		}
+
+		ids := sortedIDs(f.hashes[p])
+		for _, id := range ids {
+			err := f.c.Send("HGET", genKey(p, id), field)
+			if err != nil {
+				return err
+			}
+		}
+		err := f.c.Flush()
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			v, err := redis.Int64(f.c.Receive())
+			if err != nil && err != redis.ErrNil {
+				return err
+			}
+			if isClassPrefix(p) {
+				f.nodes[p][id] = v
+			} else {
+				f.makeGP[p][id] = v
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkLinks checks that linked hashes exist or are in trash and links are
+// symmetric. Specs are the source of truth for links between specs and other
+// entities.
+func (f *fsck) checkLinks() error {
+	keys := make([]string, 0, len(f.links))
+	for k := range f.links {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p1, p2 := f.owners[k][0], f.owners[k][1]
+		if p2 == "next" {
+			continue // see checkTree
+		}
+
+		x, _ := strconv.ParseInt(strings.SplitN(k[len(p1)+1:], ":", 2)[0], 10, 64)
+		if !f.isKept(p1, x) {
+			err := f.problem("link-owner-missing", k, "", "DEL", k)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, m := range sortedMembers(f.links[k]) {
+			y, err := strconv.ParseInt(m, 10, 64)
+			if err != nil || !f.isKept(p2, y) {
+				err = f.problem("link-target-missing", k, m, "SREM", k, m)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			rev := genKey(p2, y, p1)
+			if f.links[rev][strconv.FormatInt(x, 10)] {
+				continue
+			}
+			if p1 == prefixMaker && isSpecPrefix(p2) && f.makeGP[p2][y] == x {
+				continue // id_make_gp is linked one way
+			}
+
+			if isSpecPrefix(p1) {
+				err = f.problem("link-asymmetric", rev, strconv.FormatInt(x, 10), "SADD", rev, x)
+			} else {
+				err = f.problem("link-asymmetric", k, m, "SREM", k, m)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkTree checks that every class is in next set of its node and next sets
+// have only children of the node. Classes of missing nodes are moved to the
+// root, so they are found in the tree and can be moved back by set-class.
+func (f *fsck) checkTree() error {
+	for _, p := range fsckPrefixes {
+		if !isClassPrefix(p) {
+			continue
+		}
+
+		for _, id := range sortedIDs(f.hashes[p]) {
+			node := f.nodes[p][id]
+			if node != 0 && !f.isKept(p, node) {
+				err := f.problem("class-node-missing", genKey(p, id), strconv.FormatInt(node, 10),
+					"HSET", genKey(p, id), "id_node", 0)
+				if err != nil {
+					return err
+				}
+				if f.repair {
+					// next sets are fixed below as for any moved class
+					node = 0
+					f.nodes[p][id] = node
+				}
+			}
+
+			key := genKey(p, node, "next")
+			if !f.links[key][strconv.FormatInt(id, 10)] {
+				err := f.problem("class-next-missing", key, strconv.FormatInt(id, 10), "SADD", key, id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		var keys []string
+		for k, o := range f.owners {
+			if o[0] == p && o[1] == "next" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			node, _ := strconv.ParseInt(strings.SplitN(k[len(p)+1:], ":", 2)[0], 10, 64)
+			for _, m := range sortedMembers(f.links[k]) {
+				id, err := strconv.ParseInt(m, 10, 64)
+				if err == nil && f.hasHash(p, id) && f.nodes[p][id] == node {
+					continue
+				}
+				err = f.problem("class-next-stale", k, m, "SREM", k, m)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkSearch checks that srch and abcd keys point to existing hashes and
+// rune counters are positive.
+func (f *fsck) checkSearch() error {
+	for _, p := range fsckPrefixes {
+		for _, l := range []string{"ru", "ua", "en"} {
+			key := genKey(p, "srch", l)
+			v, err := redis.Strings(f.c.Do("ZRANGE", key, 0, -1, "WITHSCORES"))
+			if err != nil {
+				return err
+			}
+			for i := 0; i+1 < len(v); i += 2 {
+				id, _ := strconv.ParseInt(v[i+1], 10, 64)
+				if f.hasHash(p, id) {
+					continue
+				}
+				err = f.problem("search-orphan", key, v[i], "ZREM", key, v[i])
+				if err != nil {
+					return err
+				}
+			}
+
+			key = genKey(p, "abcd", l)
+			v, err = redis.Strings(f.c.Do("ZRANGE", key, 0, -1))
+			if err != nil {
+				return err
+			}
+			for i := range v {
+				id, _ := strconv.ParseInt(v[i], 10, 64)
+				if f.hasHash(p, id) {
+					continue
+				}
+				err = f.problem("abcd-orphan", key, v[i], "ZREM", key, v[i])
+				if err != nil {
+					return err
+				}
+			}
+
+			key = genKey(p, "rune", l)
+			v, err = redis.Strings(f.c.Do("ZRANGEBYSCORE", key, "-inf", 0))
+			if err != nil {
+				return err
+			}
+			for i := range v {
+				err = f.problem("rune-empty", key, v[i], "ZREM", key, v[i])
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkSync checks that live entries of sync sets have hashes, missing ones
+// are marked as deleted.
+func (f *fsck) checkSync() error {
+	for _, p := range fsckPrefixes {
+		ids, err := newStorage(f.c).loadSyncIDs(p, 0)
+		if err != nil {
+			return err
+		}
+
+		key := genKey(p, "sync")
+		for _, id := range ids {
+			if f.hasHash(p, id) {
+				continue
+			}
+			err = f.problemFunc("sync-orphan", key, strconv.FormatInt(id, 10), f.markSync(p, id))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkSyncRev checks that sync set and revision sync set have the same ids
+// with the same signs. Ids which differ are marked in both sets as updated
+// or deleted by their hashes, so clients of both sets see them again.
+func (f *fsck) checkSyncRev() error {
+	for _, p := range fsckPrefixes {
+		ts, err := loadSyncSigns(f.c, genKey(p, "sync"))
+		if err != nil {
+			return err
+		}
+		rev, err := loadSyncSigns(f.c, genKey(p, "sync", "rev"))
+		if err != nil {
+			return err
+		}
+
+		ids := make(map[int64]bool, len(ts))
+		for id := range ts {
+			ids[id] = true
+		}
+		for id := range rev {
+			ids[id] = true
+		}
+
+		key := genKey(p, "sync", "rev")
+		for _, id := range sortedIDs(ids) {
+			if ts[id] == rev[id] {
+				continue
+			}
+			err = f.problemFunc("sync-rev-mismatch", key, strconv.FormatInt(id, 10), f.markSync(p, id))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// markSync returns fix, which marks entity as updated or deleted, as its
+// hash says, in sync set and in revision sync set in one transaction.
+func (f *fsck) markSync(p string, id int64) func() error {
+	return func() error {
+		del := !f.hasHash(p, id)
+		t := time.Now().Unix()
+		if del {
+			t = -t
+		}
+		return multiExec(f.c, func() error {
+			err := f.c.Send("ZADD", genKey(p, "sync"), t, id)
+			if err != nil {
+				return err
+			}
+			return newStorage(f.c).addSyncRev(p, id, del)
+		})
+	}
+}
+
+// loadSyncSigns returns signs of scores of ids in sync set.
+func loadSyncSigns(c redis.Conn, key string) (map[int64]int, error) {
+	v, err := redis.Int64s(c.Do("ZRANGE", key, 0, -1, "WITHSCORES"))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[int64]int, len(v)/2)
+	for i := 0; i+1 < len(v); i += 2 {
+		res[v[i]] = 1
+		if v[i+1] < 0 {
+			res[v[i]] = -1
+		}
+	}
+
+	return res, nil
+}
+
+func sortedIDs(m map[int64]bool) []int64 {
+	res := make([]int64, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+func sortedMembers(m map[string]bool) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"internal/redismem"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// fsckCases seed one kind of problems each, Fsck must find them and leave no
+// problems after repair.
+var fsckCases = []struct {
+	name string
+	seed [][]interface{}
+	want []string
+}{
+	{
+		"link owner missing",
+		[][]interface{}{
+			{"SADD", "inn:5:spec:inf", 10},
+		},
+		[]string{"link-owner-missing"},
+	},
+	{
+		"link target missing",
+		[][]interface{}{
+			{"HSET", "inn:1", "id", 1},
+			{"SADD", "inn:1:spec:inf", 99},
+		},
+		[]string{"link-target-missing"},
+	},
+	{
+		"link of spec without reverse",
+		[][]interface{}{
+			{"HSET", "inn:1", "id", 1},
+			{"HSET", "spec:inf:10", "id", 10},
+			{"SADD", "spec:inf:10:inn", 1},
+		},
+		[]string{"link-asymmetric"},
+	},
+	{
+		"link to spec without reverse",
+		[][]interface{}{
+			{"HSET", "inn:1", "id", 1},
+			{"HSET", "spec:inf:10", "id", 10},
+			{"SADD", "inn:1:spec:inf", 10},
+		},
+		[]string{"link-asymmetric"},
+	},
+	{
+		"class node missing",
+		[][]interface{}{
+			{"HMSET", "class:atc:2", "id", 2, "id_node", 7},
+			{"SADD", "class:atc:7:next", 2},
+		},
+		[]string{"class-node-missing"},
+	},
+	{
+		"class next missing",
+		[][]interface{}{
+			{"HMSET", "class:atc:1", "id", 1},
+			{"HMSET", "class:atc:2", "id", 2, "id_node", 1},
+			{"SADD", "class:atc:0:next", 1},
+		},
+		[]string{"class-next-missing"},
+	},
+	{
+		"class next stale",
+		[][]interface{}{
+			{"HMSET", "class:atc:1", "id", 1},
+			{"SADD", "class:atc:0:next", 1, 3},
+		},
+		[]string{"class-next-stale"},
+	},
+	{
+		"search orphan",
+		[][]interface{}{
+			{"ZADD", "inn:srch:ru", 5, "парацетамол"},
+		},
+		[]string{"search-orphan"},
+	},
+	{
+		"abcd orphan",
+		[][]interface{}{
+			{"ZADD", "inn:abcd:ru", 0, 5},
+		},
+		[]string{"abcd-orphan"},
+	},
+	{
+		"rune empty",
+		[][]interface{}{
+			{"ZADD", "inn:rune:ru", 0, "п"},
+		},
+		[]string{"rune-empty"},
+	},
+	{
+		"sync orphan",
+		[][]interface{}{
+			{"ZADD", "inn:sync", 100, 5},
+			{"ZADD", "inn:sync:rev", 1, 5},
+		},
+		[]string{"sync-orphan"},
+	},
+	{
+		"sync rev missing",
+		[][]interface{}{
+			{"HSET", "inn:1", "id", 1},
+			{"ZADD", "inn:sync", 100, 1},
+		},
+		[]string{"sync-rev-mismatch"},
+	},
+	{
+		"sync rev stale",
+		[][]interface{}{
+			{"ZADD", "inn:sync:rev", 1, 5},
+		},
+		[]string{"sync-rev-mismatch"},
+	},
+	{
+		"sync rev deleted",
+		[][]interface{}{
+			{"HSET", "inn:1", "id", 1},
+			{"ZADD", "inn:sync", 100, 1},
+			{"ZADD", "inn:sync:rev", -1, 1},
+		},
+		[]string{"sync-rev-mismatch"},
+	},
+}
+
+func TestFsck(t *testing.T) {
+	for _, x := range fsckCases {
+		c := redismem.New().Get()
+
+		for _, cmd := range x.seed {
+			_, err := c.Do(cmd[0].(string), cmd[1:]...)
+			if err != nil {
+				t.Fatalf("%s: %v %v", x.name, cmd, err)
+			}
+		}
+
+		var got []string
+		n, err := runFsck(c, false, func(p Problem) error {
+			got = append(got, p.Check)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", x.name, err)
+		}
+		if n != len(x.want) || !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%s: found %v, want %v", x.name, got, x.want)
+		}
+
+		_, err = runFsck(c, true, func(p Problem) error {
+			if !p.Repaired {
+				t.Errorf("%s: %s of %s is not repaired", x.name, p.Check, p.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", x.name, err)
+		}
+
+		n, err = runFsck(c, false, func(p Problem) error {
+			t.Errorf("%s: %+v is left after repair", x.name, p)
+			return nil
+		})
+		if err != nil || n != 0 {
+			t.Errorf("%s: %d problems after repair: %v", x.name, n, err)
+		}
+
+		c.Close()
+	}
+}
+
+func TestFsckClassNodeMissing(t *testing.T) {
+	c := redismem.New().Get()
+	defer c.Close()
+
+	for _, cmd := range [][]interface{}{
+		{"HMSET", "class:atc:2", "id", 2, "id_node", 7},
+		{"SADD", "class:atc:7:next", 2},
+	} {
+		_, err := c.Do(cmd[0].(string), cmd[1:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	_, err := runFsck(c, true, func(p Problem) error {
+		got = append(got, p.Check)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"class-node-missing", "class-next-missing", "class-next-stale"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("repaired %v, want %v", got, want)
+	}
+
+	node, err := redis.Int64(c.Do("HGET", "class:atc:2", "id_node"))
+	if err != nil || node != 0 {
+		t.Fatalf("id_node = %d %v", node, err)
+	}
+	next, err := redis.Int64s(c.Do("SMEMBERS", "class:atc:0:next"))
+	if err != nil || !reflect.DeepEqual(next, []int64{2}) {
+		t.Fatalf("root next set = %v %v", next, err)
+	}
+}
+
+// TestFsckSyncOrphan checks that repaired orphan is deleted in both sync sets
+// with the next revision.
+func TestFsckSyncOrphan(t *testing.T) {
+	c := redismem.New().Get()
+	defer c.Close()
+
+	for _, cmd := range [][]interface{}{
+		{"ZADD", "inn:sync", 100, 5},
+		{"ZADD", "inn:sync:rev", 1, 5},
+		{"SET", "inn:sync:seq", 1},
+	} {
+		_, err := c.Do(cmd[0].(string), cmd[1:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := runFsck(c, true, func(Problem) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := redis.Int64(c.Do("ZSCORE", "inn:sync", 5))
+	if err != nil || ts >= 0 {
+		t.Errorf("sync score = %d %v", ts, err)
+	}
+	rev, err := redis.Int64(c.Do("ZSCORE", "inn:sync:rev", 5))
+	if err != nil || rev != -2 {
+		t.Errorf("sync rev score = %d %v", rev, err)
+	}
+}
@@ -0,0 +1,663 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"internal/ctxutil"
+	"internal/logger"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Hooks live in the root of namespace, they are not part of releases and
+// follow the change feed of the live release.
+const (
+	prefixHook = "hook"
+
+	keyHookIDs    = "hook:ids"
+	keyHookCursor = "hook:cursor"
+	keyHookQueue  = "hook:queue"
+	keyHookDead   = "hook:dead"
+
+	hookBatch    = 100
+	hookAttempts = 10
+	hookDeadLen  = 1000
+)
+
+// jsonHook is webhook subscription, empty kind means all kinds of entities.
+type jsonHook struct {
+	ID     int64    `json:"id"`
+	URL    string   `json:"url"`
+	Kind   []string `json:"kind,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// jsonDelivery is item of delivery queue and of dead-letter list.
+type jsonDelivery struct {
+	Hook    int64      `json:"hook"`
+	Event   string     `json:"event"`
+	Change  jsonChange `json:"change"`
+	Attempt int        `json:"attempt"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// jsonHookEvent is body of delivery.
+type jsonHookEvent struct {
+	Event string `json:"event"`
+	jsonChange
+}
+
+func (v *jsonHook) match(kind string) bool {
+	if len(v.Kind) == 0 {
+		return true
+	}
+	for i := range v.Kind {
+		if v.Kind[i] == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *jsonHook) check() error {
+	if v.ID <= 0 {
+		return fmt.Errorf("invalid hook id %d", v.ID)
+	}
+	u, err := url.Parse(v.URL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("invalid hook url %q", v.URL)
+	}
+	err = checkHookHost(u.Hostname())
+	if err != nil {
+		return err
+	}
+	for i := range v.Kind {
+		if !isDeltaPrefix(v.Kind[i]) {
+			return fmt.Errorf("unknown kind %q", v.Kind[i])
+		}
+	}
+	return nil
+}
+
+// checkHookHost refuses loopback and link-local hosts, so hooks can not reach
+// services of the server and cloud metadata. Names are checked again by the
+// address they resolve to at delivery, see hookDialer.
+func checkHookHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("hook host %s is loopback", host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return checkHookIP(ip)
+	}
+	return nil
+}
+
+func checkHookIP(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("hook address %s is loopback or link-local", ip)
+	}
+	return nil
+}
+
+// hookDialer refuses connections to loopback and link-local addresses, they
+// are checked after names are resolved.
+var hookDialer = &net.Dialer{
+	Timeout: 10 * time.Second,
+	Control: func(_, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("hook address %s is not ip", host)
+		}
+		return checkHookIP(ip)
+	},
+}
+
+func setHook(h *ctxHelper) (interface{}, error) {
+	var v []*jsonHook
+	err := json.Unmarshal(h.data, &v)
+	if err == nil {
+		for i := range v {
+			err = v[i].check()
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, err
+	}
+
+	c := h.getConn()
+	defer h.delConn(c)
+
+	err = multiExec(c, func() error {
+		for i := range v {
+			err := c.Send("HMSET", genKey(prefixHook, v[i].ID),
+				"url", v[i].URL,
+				"kind", strings.Join(v[i].Kind, ","),
+				"secret", v[i].Secret,
+			)
+			if err != nil {
+				return err
+			}
+			err = c.Send("SADD", keyHookIDs, v[i].ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statusOK, nil
+}
+
+func getHook(h *ctxHelper) (interface{}, error) {
+	ids, err := int64sFromJSON(h.data)
+	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, err
+	}
+
+	c := h.getConn()
+	defer h.delConn(c)
+
+	v, err := loadHooks(c, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// secrets are write-only
+	for i := range v {
+		if v[i] != nil {
+			v[i].Secret = ""
+		}
+	}
+
+	return v, nil
+}
+
+func getHookList(h *ctxHelper) (interface{}, error) {
+	c := h.getConn()
+	defer h.delConn(c)
+
+	return redis.Int64s(c.Do("SMEMBERS", keyHookIDs))
+}
+
+func delHook(h *ctxHelper) (interface{}, error) {
+	ids, err := int64sFromJSON(h.data)
+	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, err
+	}
+
+	c := h.getConn()
+	defer h.delConn(c)
+
+	// queued deliveries of deleted hooks are dropped by worker
+	err = multiExec(c, func() error {
+		for i := range ids {
+			err := c.Send("DEL", genKey(prefixHook, ids[i]))
+			if err != nil {
+				return err
+			}
+			err = c.Send("SREM", keyHookIDs, ids[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statusOK, nil
+}
+
+// getHookDead returns failed deliveries, the last failed goes first.
+func getHookDead(h *ctxHelper) (interface{}, error) {
+	c := h.getConn()
+	defer h.delConn(c)
+
+	v, err := redis.Strings(c.Do("LRANGE", keyHookDead, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]json.RawMessage, len(v))
+	for i := range v {
+		res[i] = json.RawMessage(v[i])
+	}
+
+	return res, nil
+}
+
+// loadHooks returns hooks by ids, nil for missing ones.
+func loadHooks(c redis.Conn, ids []int64) ([]*jsonHook, error) {
+	for i := range ids {
+		err := c.Send("HGETALL", genKey(prefixHook, ids[i]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := make([]*jsonHook, len(ids))
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	v, err := redis.Values(c.Do(""))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range v {
+		m, err := redis.StringMap(v[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(m) == 0 {
+			continue
+		}
+		res[i] = &jsonHook{ID: ids[i], URL: m["url"], Secret: m["secret"]}
+		if m["kind"] != "" {
+			res[i].Kind = strings.Split(m["kind"], ",")
+		}
+	}
+
+	return res, nil
+}
+
+func isDeltaPrefix(p string) bool {
+	for i := range deltaKinds {
+		if deltaKinds[i].p == p {
+			return true
+		}
+	}
+	return false
+}
+
+type hooker struct {
+	rdb      rediser
+	log      logger.Logger
+	client   *http.Client
+	interval time.Duration
+	backoff  time.Duration
+	maxDelay time.Duration
+	lease    time.Duration
+}
+
+// RunHooks moves change events of the live release to the delivery queue and
+// delivers them to subscribers until ctx is done. Several workers may run at
+// once, each delivery is claimed for the time of HTTP request.
+func RunHooks(ctx context.Context, r rediser, ns string, options ...func(*hooker) error) error {
+	w, err := newHooker(r, ns, options...)
+	if err != nil {
+		return err
+	}
+
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+
+	for {
+		err := w.dispatch()
+		if err != nil && err != errConflict {
+			w.log.Printf("hook: %v", err)
+		}
+
+		err = w.deliver(ctx)
+		if err != nil && err != errConflict {
+			w.log.Printf("hook: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func newHooker(r rediser, ns string, options ...func(*hooker) error) (*hooker, error) {
+	w := &hooker{
+		rdb: newNSRediser(r, ns),
+		log: logger.NewDefault(),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: hookDialer.DialContext,
+			},
+		},
+		interval: time.Second,
+		backoff:  10 * time.Second,
+		maxDelay: time.Hour,
+	}
+
+	for i := range options {
+		err := options[i](w)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w.lease = 2 * w.client.Timeout
+	if w.lease <= 0 {
+		w.lease = time.Minute
+	}
+
+	return w, nil
+}
+
+// HookClient is option for HTTP client of deliveries, it replaces the default
+// client, which refuses loopback and link-local addresses.
+func HookClient(c *http.Client) func(*hooker) error {
+	return func(w *hooker) error {
+		w.client = c
+		return nil
+	}
+}
+
+// HookLogger is option for passing logger interface.
+func HookLogger(l logger.Logger) func(*hooker) error {
+	return func(w *hooker) error {
+		w.log = l
+		return nil
+	}
+}
+
+// HookInterval is option for polling interval of change feed and queue.
+func HookInterval(d time.Duration) func(*hooker) error {
+	return func(w *hooker) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid hook interval %s", d)
+		}
+		w.interval = d
+		return nil
+	}
+}
+
+// HookBackoff is option for delay before the first retry, it doubles with
+// each attempt up to max.
+func HookBackoff(d, max time.Duration) func(*hooker) error {
+	return func(w *hooker) error {
+		if d <= 0 || max < d {
+			return fmt.Errorf("invalid hook backoff %s-%s", d, max)
+		}
+		w.backoff = d
+		w.maxDelay = max
+		return nil
+	}
+}
+
+// dispatch queues one delivery per matching hook for a batch of new change
+// events, the cursor is moved in the same transaction.
+func (w *hooker) dispatch() error {
+	c := w.rdb.Get()
+	defer c.Close()
+
+	live, err := loadLiveRelease(c)
+	if err != nil {
+		return err
+	}
+	key := keyChanges
+	if live != "" {
+		key = genKey(releaseKeyspace(live), keyChanges)
+	}
+
+	_, err = c.Do("WATCH", keyHookCursor, keyHookIDs)
+	if err != nil {
+		return err
+	}
+	defer c.Do("UNWATCH")
+
+	// cursor is kept per release, new subscribers get only new events
+	last, err := redis.String(c.Do("HGET", keyHookCursor, live))
+	if err == redis.ErrNil {
+		last, err = lastChangeID(c, key)
+		if err != nil {
+			return err
+		}
+		_, err = c.Do("HSET", keyHookCursor, live, last)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	v, err := redis.Values(c.Do("XREAD", "COUNT", hookBatch, "STREAMS", key, last))
+	if err == redis.ErrNil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var e []jsonChange
+	for i := range v {
+		s, err := redis.Values(v[i], nil)
+		if err != nil || len(s) != 2 {
+			continue
+		}
+		x, err := redis.Values(s[1], nil)
+		if err != nil {
+			return err
+		}
+		e, err = parseChanges(x)
+		if err != nil {
+			return err
+		}
+	}
+	if len(e) == 0 {
+		return nil
+	}
+
+	ids, err := redis.Int64s(c.Do("SMEMBERS", keyHookIDs))
+	if err != nil {
+		return err
+	}
+	hooks, err := loadHooks(c, ids)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return multiExec(c, func() error {
+		for i := range e {
+			for _, k := range hooks {
+				if k == nil || !k.match(e[i].Kind) {
+					continue
+				}
+				b, _ := json.Marshal(jsonDelivery{Hook: k.ID, Event: e[i].ID, Change: e[i]})
+				err := c.Send("ZADD", keyHookQueue, now, b)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return c.Send("HSET", keyHookCursor, live, e[len(e)-1].ID)
+	})
+}
+
+// deliver claims due deliveries one by one and sends them, failed ones are
+// queued again with exponential backoff or moved to the dead-letter list.
+func (w *hooker) deliver(ctx context.Context) error {
+	c := w.rdb.Get()
+	defer c.Close()
+
+	for n := 0; n < hookBatch; n++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		v, err := w.claim(c)
+		if err == errConflict {
+			continue // other worker claimed it
+		}
+		if err != nil || v == "" {
+			return err
+		}
+
+		err = w.send(ctx, c, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send sends claimed delivery v and removes it from the queue or queues it
+// again.
+func (w *hooker) send(ctx context.Context, c redis.Conn, v string) error {
+	var d jsonDelivery
+	err := json.Unmarshal([]byte(v), &d)
+	if err != nil {
+		w.log.Printf("hook: invalid delivery %s: %v", v, err)
+		_, err = c.Do("ZREM", keyHookQueue, v)
+		return err
+	}
+
+	k, err := loadHooks(c, []int64{d.Hook})
+	if err != nil {
+		return err
+	}
+	if k[0] == nil {
+		_, err = c.Do("ZREM", keyHookQueue, v)
+		return err
+	}
+
+	err = w.post(ctx, k[0], &d)
+	if err == nil {
+		_, err = c.Do("ZREM", keyHookQueue, v)
+		return err
+	}
+
+	d.Attempt++
+	d.Error = err.Error()
+	b, _ := json.Marshal(d)
+
+	return multiExec(c, func() error {
+		err := c.Send("ZREM", keyHookQueue, v)
+		if err != nil {
+			return err
+		}
+		if d.Attempt >= hookAttempts {
+			err = c.Send("LPUSH", keyHookDead, b)
+			if err != nil {
+				return err
+			}
+			return c.Send("LTRIM", keyHookDead, 0, hookDeadLen-1)
+		}
+		due := time.Now().Add(w.delay(d.Attempt)).UnixNano() / int64(time.Millisecond)
+		return c.Send("ZADD", keyHookQueue, due, b)
+	})
+}
+
+// claim returns the first due delivery and postpones it for the lease time,
+// so other workers skip it while this one sends it. Empty string means
+// nothing is due.
+func (w *hooker) claim(c redis.Conn) (string, error) {
+	_, err := c.Do("WATCH", keyHookQueue)
+	if err != nil {
+		return "", err
+	}
+	defer c.Do("UNWATCH")
+
+	now := time.Now()
+	v, err := redis.Strings(c.Do("ZRANGEBYSCORE", keyHookQueue, "-inf",
+		now.UnixNano()/int64(time.Millisecond), "LIMIT", 0, 1))
+	if err != nil || len(v) == 0 {
+		return "", err
+	}
+
+	due := now.Add(w.lease).UnixNano() / int64(time.Millisecond)
+	err = multiExec(c, func() error {
+		return c.Send("ZADD", keyHookQueue, "XX", due, v[0])
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return v[0], nil
+}
+
+// delay returns backoff before the given attempt.
+func (w *hooker) delay(attempt int) time.Duration {
+	d := w.backoff
+	for i := 1; i < attempt && d < w.maxDelay; i++ {
+		d *= 2
+	}
+	if d > w.maxDelay {
+		d = w.maxDelay
+	}
+	return d
+}
+
+// post sends signed delivery, any status except 2xx is failure.
+func (w *hooker) post(ctx context.Context, k *jsonHook, d *jsonDelivery) error {
+	b, err := json.Marshal(jsonHookEvent{d.Event, d.Change})
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequest("POST", k.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Hook-ID", fmt.Sprint(k.ID))
+	r.Header.Set("X-Hook-Event", d.Event)
+	r.Header.Set("X-Hook-Attempt", fmt.Sprint(d.Attempt+1))
+	if k.Secret != "" {
+		r.Header.Set("X-Hook-Signature", "sha256="+signHook(k.Secret, b))
+	}
+
+	resp, err := w.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", k.URL, resp.Status)
+	}
+	return nil
+}
+
+// signHook returns hex of HMAC-SHA256 of body, receivers check it with the
+// shared secret.
+func signHook(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	_, _ = m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
@@ -24,12 +24,17 @@ type jsonINN struct {
 	NameUA    string  `json:"name_ua,omitempty"`
 	NameEN    string  `json:"name_en,omitempty"`
 	Slug      string  `json:"slug,omitempty"`
+	Rev       int64   `json:"rev,omitempty"`
 }
 
 func (j *jsonINN) getID() int64 {
 	return j.ID
 }
 
+func (j *jsonINN) getRev() int64 {
+	return j.Rev
+}
+
 func (j *jsonINN) getSrchRU(_ string) ([]string, []rune) {
 	var s []string
 	var r []rune
@@ -89,6 +94,7 @@ func (j *jsonINN) getFields(_ bool) []interface{} {
 		"name_ua", // 2
 		"name_en", // 3
 		"slug",    // 4
+		"rev",     // 5
 	}
 }
 
@@ -99,6 +105,7 @@ func (j *jsonINN) getValues() []interface{} {
 		j.NameUA, // 2
 		j.NameEN, // 3
 		j.Slug,   // 4
+		j.Rev,    // 5
 	}
 }
 
@@ -118,6 +125,8 @@ func (j *jsonINN) setValues(_ bool, v ...interface{}) {
 			j.NameEN, _ = redis.String(v[i], nil)
 		case 4:
 			j.Slug, _ = redis.String(v[i], nil)
+		case 5:
+			j.Rev, _ = redis.Int64(v[i], nil)
 		}
 	}
 }
@@ -140,6 +149,10 @@ func (j jsonINNs) nill(i int) {
 	j[i] = nil
 }
 
+func (v jsonINNs) pageKey(i int, _ bool) pageKey {
+	return pageKey{Name: v[i].Name}
+}
+
 func (v jsonINNs) sort(lang string) {
 	coll := newCollator(lang)
 	sort.Slice(v,
@@ -153,7 +166,10 @@ func (v jsonINNs) sort(lang string) {
 			if v[i] != nil && v[j] == nil {
 				return true
 			}
-			return coll.CompareString(v[i].Name, v[j].Name) < 0
+			if n := coll.CompareString(v[i].Name, v[j].Name); n != 0 {
+				return n < 0
+			}
+			return v[i].ID < v[j].ID
 		},
 	)
 }
@@ -179,42 +195,37 @@ func makeINNsFromIDs(v []int64, err error) (jsonINNs, error) {
 }
 
 func loadINNLinks(c redis.Conn, p string, v []*jsonINN) error {
-	var err error
+	ids := make([]int64, len(v))
 	for i := range v {
-		if v[i] == nil {
-			continue
-		}
-
-		v[i].IDSpecDEC, err = loadLinkIDs(c, p, prefixSpecDEC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDSpecINF, err = loadLinkIDs(c, p, prefixSpecINF, v[i].ID)
-		if err != nil {
-			return err
+		if v[i] != nil {
+			ids[i] = v[i].ID
 		}
 	}
-	return nil
-}
 
-func getINNXSync(h *ctxHelper, p string) ([]int64, error) {
-	v, err := int64FromJSON(h.data)
+	x, err := newStorage(c).loadLinkSets(p, ids, prefixSpecDEC, prefixSpecINF)
 	if err != nil {
-		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
-		return nil, err
+		return err
 	}
 
-	c := h.getConn()
-	defer h.delConn(c)
+	for i := range v {
+		if v[i] == nil {
+			continue
+		}
+		v[i].IDSpecDEC = x[i][prefixSpecDEC]
+		v[i].IDSpecINF = x[i][prefixSpecINF]
+	}
+	return nil
+}
 
-	return loadSyncIDs(c, p, v)
+func getINNXSync(h *ctxHelper, p string) (interface{}, error) {
+	return getSyncX(h, p)
 }
 
 func getINNXAbcd(h *ctxHelper, p string) ([]string, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	v, err := loadAbcd(c, p, h.lang)
+	v, err := newStorage(c).loadAbcd(p, h.lang)
 	if err != nil {
 		return nil, err
 	}
@@ -232,7 +243,7 @@ func getINNXAbcdLs(h *ctxHelper, p string) ([]int64, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	v, err := loadAbcdLs(c, p, s, h.lang)
+	v, err := newStorage(c).loadAbcdLs(p, s, h.lang)
 	if err != nil {
 		return nil, err
 	}
@@ -253,16 +264,12 @@ func getINNXList(h *ctxHelper, p string) (jsonINNs, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v, true)
+	x, err := loadList(h, c, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	normLang(h.lang, p, v)
-
-	v.sort(h.lang)
-
-	return v, nil
+	return x.(jsonINNs), nil
 }
 
 func getINNXListAZ(h *ctxHelper, p string) (jsonINNs, error) {
@@ -279,7 +286,7 @@ func getINNXListAZ(h *ctxHelper, p string) (jsonINNs, error) {
 	l := h.lang
 	h.lang = "en"
 	// <--
-	v, err := loadAbcdLs(c, p, s, h.lang)
+	v, err := newStorage(c).loadAbcdLs(p, s, h.lang)
 	if err != nil {
 		return nil, err
 	}
@@ -300,7 +307,7 @@ func getINNX(h *ctxHelper, p string) (jsonINNs, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v)
+	err = newStorage(c).loadHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
@@ -325,27 +332,49 @@ func setINNX(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	x, err := makeINNsFromIDs(findExistsIDs(c, p, mineIDsFromHashers(v)...))
+	err = newStorage(c).watchHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(x) > 0 {
-		err = loadHashers(c, p, x)
+	if h.patch {
+		b, err := mergePatch(h, c, p, newINNHasher)
 		if err != nil {
 			return nil, err
 		}
-		err = freeSearchers(c, p, x)
+		v, err = makeINNsFromJSON(b)
 		if err != nil {
+			h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
 			return nil, err
 		}
 	}
 
-	err = saveHashers(c, p, v)
+	x, err := makeINNsFromIDs(newStorage(c).findExistsIDs(p, mineIDsFromHashers(v)...))
 	if err != nil {
 		return nil, err
 	}
-	err = saveSearchers(c, p, v)
+
+	if len(x) > 0 {
+		err = newStorage(c).loadHashers(h, p, x)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = multiExec(c, func() error {
+		if len(x) > 0 {
+			err := newStorage(c).freeSearchers(p, x)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := newStorage(c).saveHashers(h, p, v)
+		if err != nil {
+			return err
+		}
+		return newStorage(c).saveSearchers(p, v)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -363,17 +392,23 @@ func delINNX(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v)
+	err = newStorage(c).watchHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	err = freeHashers(c, p, v)
+	err = newStorage(c).loadHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	err = freeSearchers(c, p, v)
+	err = multiExec(c, func() error {
+		err := newStorage(c).freeHashers(h, p, v)
+		if err != nil {
+			return err
+		}
+		return newStorage(c).freeSearchers(p, v)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -414,3 +449,7 @@ func setINN(h *ctxHelper) (interface{}, error) {
 func delINN(h *ctxHelper) (interface{}, error) {
 	return delINNX(h, prefixINN)
 }
+
+func restoreINN(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixINN)
+}
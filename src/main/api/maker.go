@@ -29,12 +29,17 @@ type jsonMaker struct {
 	MarkGP    bool    `json:"mark_gp,omitempty"`
 	Logo      string  `json:"logo,omitempty"`
 	Slug      string  `json:"slug,omitempty"`
+	Rev       int64   `json:"rev,omitempty"`
 }
 
 func (j *jsonMaker) getID() int64 {
 	return j.ID
 }
 
+func (j *jsonMaker) getRev() int64 {
+	return j.Rev
+}
+
 func (j *jsonMaker) getSrchRU(_ string) ([]string, []rune) {
 	var s []string
 	var r []rune
@@ -115,6 +120,7 @@ func (j *jsonMaker) getFields(list bool) []interface{} {
 		"mark_gp", // 7
 		"logo",    // 8
 		"slug",    // 9
+		"rev",     // 10
 	}
 }
 
@@ -130,6 +136,7 @@ func (j *jsonMaker) getValues() []interface{} {
 		j.MarkGP, // 7
 		j.Logo,   // 8
 		j.Slug,   // 9
+		j.Rev,    // 10
 	}
 }
 
@@ -176,6 +183,8 @@ func (j *jsonMaker) setValues(list bool, v ...interface{}) {
 			j.Logo, _ = redis.String(v[i], nil)
 		case 9:
 			j.Slug, _ = redis.String(v[i], nil)
+		case 10:
+			j.Rev, _ = redis.Int64(v[i], nil)
 		}
 	}
 }
@@ -198,6 +207,10 @@ func (j jsonMakers) nill(i int) {
 	j[i] = nil
 }
 
+func (v jsonMakers) pageKey(i int, _ bool) pageKey {
+	return pageKey{Name: v[i].Name}
+}
+
 func (v jsonMakers) sort(lang string) {
 	coll := newCollator(lang)
 	sort.Slice(v,
@@ -211,7 +224,10 @@ func (v jsonMakers) sort(lang string) {
 			if v[i] != nil && v[j] == nil {
 				return true
 			}
-			return coll.CompareString(v[i].Name, v[j].Name) < 0
+			if n := coll.CompareString(v[i].Name, v[j].Name); n != 0 {
+				return n < 0
+			}
+			return v[i].ID < v[j].ID
 		},
 	)
 }
@@ -237,42 +253,37 @@ func makeMakersFromIDs(v []int64, err error) (jsonMakers, error) {
 }
 
 func loadMakerLinks(c redis.Conn, p string, v []*jsonMaker) error {
-	var err error
+	ids := make([]int64, len(v))
 	for i := range v {
-		if v[i] == nil {
-			continue
-		}
-
-		v[i].IDSpecDEC, err = loadLinkIDs(c, p, prefixSpecDEC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDSpecINF, err = loadLinkIDs(c, p, prefixSpecINF, v[i].ID)
-		if err != nil {
-			return err
+		if v[i] != nil {
+			ids[i] = v[i].ID
 		}
 	}
-	return nil
-}
 
-func getMakerXSync(h *ctxHelper, p string) ([]int64, error) {
-	v, err := int64FromJSON(h.data)
+	x, err := newStorage(c).loadLinkSets(p, ids, prefixSpecDEC, prefixSpecINF)
 	if err != nil {
-		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
-		return nil, err
+		return err
 	}
 
-	c := h.getConn()
-	defer h.delConn(c)
+	for i := range v {
+		if v[i] == nil {
+			continue
+		}
+		v[i].IDSpecDEC = x[i][prefixSpecDEC]
+		v[i].IDSpecINF = x[i][prefixSpecINF]
+	}
+	return nil
+}
 
-	return loadSyncIDs(c, p, v)
+func getMakerXSync(h *ctxHelper, p string) (interface{}, error) {
+	return getSyncX(h, p)
 }
 
 func getMakerXAbcd(h *ctxHelper, p string) ([]string, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	v, err := loadAbcd(c, p, h.lang)
+	v, err := newStorage(c).loadAbcd(p, h.lang)
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +301,7 @@ func getMakerXAbcdLs(h *ctxHelper, p string) ([]int64, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	v, err := loadAbcdLs(c, p, s, h.lang)
+	v, err := newStorage(c).loadAbcdLs(p, s, h.lang)
 	if err != nil {
 		return nil, err
 	}
@@ -308,16 +319,12 @@ func getMakerXList(h *ctxHelper, p string) (jsonMakers, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v, true)
+	x, err := loadList(h, c, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	normLang(h.lang, p, v)
-
-	v.sort(h.lang)
-
-	return v, nil
+	return x.(jsonMakers), nil
 }
 
 func getMakerXListAZ(h *ctxHelper, p string) (jsonMakers, error) {
@@ -330,7 +337,7 @@ func getMakerXListAZ(h *ctxHelper, p string) (jsonMakers, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	v, err := loadAbcdLs(c, p, s, h.lang)
+	v, err := newStorage(c).loadAbcdLs(p, s, h.lang)
 	if err != nil {
 		return nil, err
 	}
@@ -349,7 +356,7 @@ func getMakerX(h *ctxHelper, p string) (jsonMakers, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v)
+	err = newStorage(c).loadHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
@@ -374,58 +381,49 @@ func setMakerX(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	x, err := makeMakersFromIDs(findExistsIDs(c, p, mineIDsFromHashers(v)...))
+	err = newStorage(c).watchHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(x) > 0 {
-		err = loadHashers(c, p, x)
+	if h.patch {
+		b, err := mergePatch(h, c, p, newMakerHasher)
 		if err != nil {
 			return nil, err
 		}
-		err = freeSearchers(c, p, x)
+		v, err = makeMakersFromJSON(b)
 		if err != nil {
+			h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
 			return nil, err
 		}
 	}
 
-	err = saveHashers(c, p, v)
-	if err != nil {
-		return nil, err
-	}
-	err = saveSearchers(c, p, v)
+	x, err := makeMakersFromIDs(newStorage(c).findExistsIDs(p, mineIDsFromHashers(v)...))
 	if err != nil {
 		return nil, err
 	}
 
-	return statusOK, nil
-}
-
-func runXHotfix(h *ctxHelper, p string) (interface{}, error) {
-	c := h.getConn()
-	defer h.delConn(c)
-
-	v, err := makeMakersFromIDs(loadSyncIDs(c, p, 0))
-	if err != nil {
-		return nil, err
-	}
-
-	err = loadHashers(c, p, v)
-	if err != nil {
-		return nil, err
+	if len(x) > 0 {
+		err = newStorage(c).loadHashers(h, p, x)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	_, err = c.Do("DEL",
-		genKey(p, "srch", "ru"), genKey(p, "abcd", "ru"), genKey(p, "rune", "ru"),
-		genKey(p, "srch", "ua"), genKey(p, "abcd", "ua"), genKey(p, "rune", "ua"),
-		genKey(p, "srch", "en"), genKey(p, "abcd", "en"), genKey(p, "rune", "en"),
-	)
-	if err != nil {
-		return nil, err
-	}
+	err = multiExec(c, func() error {
+		if len(x) > 0 {
+			err := newStorage(c).freeSearchers(p, x)
+			if err != nil {
+				return err
+			}
+		}
 
-	err = saveSearchers(c, p, v)
+		err := newStorage(c).saveHashers(h, p, v)
+		if err != nil {
+			return err
+		}
+		return newStorage(c).saveSearchers(p, v)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -443,17 +441,23 @@ func delMakerX(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v)
+	err = newStorage(c).watchHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	err = freeHashers(c, p, v)
+	err = newStorage(c).loadHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	err = freeSearchers(c, p, v)
+	err = multiExec(c, func() error {
+		err := newStorage(c).freeHashers(h, p, v)
+		if err != nil {
+			return err
+		}
+		return newStorage(c).freeSearchers(p, v)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -461,10 +465,6 @@ func delMakerX(h *ctxHelper, p string) (interface{}, error) {
 	return statusOK, nil
 }
 
-func runHotfix(h *ctxHelper) (interface{}, error) {
-	return runXHotfix(h, prefixMaker)
-}
-
 // MAKER
 
 func getMakerSync(h *ctxHelper) (interface{}, error) {
@@ -498,3 +498,7 @@ func setMaker(h *ctxHelper) (interface{}, error) {
 func delMaker(h *ctxHelper) (interface{}, error) {
 	return delMakerX(h, prefixMaker)
 }
+
+func restoreMaker(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixMaker)
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	keySchemaVersion = "schema:version"
+	keySchemaLock    = "schema:lock"
+
+	// migrateLockTTL bounds a single migration, the lock is renewed after
+	// each one and expires if the process dies.
+	migrateLockTTL = time.Hour
+)
+
+// Migration is versioned change of data layout. Migrations must be idempotent,
+// so the interrupted one can be run again.
+type Migration struct {
+	Version int
+	Name    string
+	up      func(redis.Conn) error
+	down    func(redis.Conn) error
+}
+
+// migrations are ordered by version, new ones are appended to the end.
+var migrations = []Migration{
+	{1, "rebuild maker search index", rebuildMakerSearchers, migrateNothing},
+	{2, "build sync revisions", buildSyncRevs, dropSyncRevs},
+	{3, "build class closure", buildClosure, dropClosure},
+}
+
+// MigrateStatus returns current schema version and all known migrations.
+func MigrateStatus(r rediser, ns, rel string) (int, []Migration, error) {
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	v, err := loadSchemaVersion(c)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return v, migrations, nil
+}
+
+// MigrateUp applies migrations up to the given version, zero means the latest.
+// Schema version is saved after each migration, so the work is resumable.
+func MigrateUp(r rediser, ns, rel string, to int) error {
+	if to == 0 && len(migrations) > 0 {
+		to = migrations[len(migrations)-1].Version
+	}
+
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	lock, err := lockMigrate(c)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	v, err := loadSchemaVersion(c)
+	if err != nil {
+		return err
+	}
+
+	for i := range migrations {
+		m := migrations[i]
+		if m.Version <= v || m.Version > to {
+			continue
+		}
+
+		err = m.up(c)
+		if err != nil {
+			return fmt.Errorf("migration %d up: %v", m.Version, err)
+		}
+
+		_, err = c.Do("SET", keySchemaVersion, m.Version)
+		if err != nil {
+			return err
+		}
+
+		err = lock.renew()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown reverts migrations down to the given version.
+func MigrateDown(r rediser, ns, rel string, to int) error {
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	lock, err := lockMigrate(c)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	v, err := loadSchemaVersion(c)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > v || m.Version <= to {
+			continue
+		}
+
+		err = m.down(c)
+		if err != nil {
+			return fmt.Errorf("migration %d down: %v", m.Version, err)
+		}
+
+		prev := 0
+		if i > 0 {
+			prev = migrations[i-1].Version
+		}
+		_, err = c.Do("SET", keySchemaVersion, prev)
+		if err != nil {
+			return err
+		}
+
+		err = lock.renew()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateLock is held by the process running migrations of keyspace.
+type migrateLock struct {
+	c     redis.Conn
+	token string
+}
+
+// lockMigrate takes the lock of migrations, it fails if other process holds it.
+func lockMigrate(c redis.Conn) (*migrateLock, error) {
+	l := &migrateLock{c, uuid()}
+	ok, err := redis.String(c.Do("SET", keySchemaLock, l.token, "NX", "PX", int64(migrateLockTTL/time.Millisecond)))
+	if err == redis.ErrNil {
+		return nil, fmt.Errorf("migration is run by other process")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ok != "OK" {
+		return nil, fmt.Errorf("unexpected reply %q", ok)
+	}
+	return l, nil
+}
+
+// renew prolongs the lock, it fails if the lock is expired and taken.
+func (l *migrateLock) renew() error {
+	return l.update("SET", keySchemaLock, l.token, "PX", int64(migrateLockTTL/time.Millisecond))
+}
+
+// unlock releases the lock if it is still held.
+func (l *migrateLock) unlock() {
+	_ = l.update("DEL", keySchemaLock)
+}
+
+func (l *migrateLock) update(cmd string, args ...interface{}) error {
+	_, err := l.c.Do("WATCH", keySchemaLock)
+	if err != nil {
+		return err
+	}
+
+	token, err := redis.String(l.c.Do("GET", keySchemaLock))
+	if err != nil && err != redis.ErrNil {
+		_, _ = l.c.Do("UNWATCH")
+		return err
+	}
+	if token != l.token {
+		_, _ = l.c.Do("UNWATCH")
+		return fmt.Errorf("lock of migration is lost")
+	}
+
+	return multiExec(l.c, func() error {
+		return l.c.Send(cmd, args...)
+	})
+}
+
+func loadSchemaVersion(c redis.Conn) (int, error) {
+	v, err := redis.Int(c.Do("GET", keySchemaVersion))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return v, err
+}
+
+func migrateNothing(_ redis.Conn) error {
+	return nil
+}
+
+// rebuildMakerSearchers recreates srch, abcd and rune keys of makers.
+func rebuildMakerSearchers(c redis.Conn) error {
+	_, err := reindexPrefix(c, prefixMaker)
+	return err
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestMigrateLock(t *testing.T) {
+	a := newTestAPI(t)
+	c := a.s.Get()
+	defer c.Close()
+
+	_, err := c.Do("SET", keySchemaLock, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MigrateUp(a.s, "", "", 0)
+	if err == nil {
+		t.Fatal("migration runs under the lock of other process")
+	}
+
+	_, err = c.Do("DEL", keySchemaLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MigrateUp(a.s, "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := redis.Int(c.Do("EXISTS", keySchemaLock))
+	if err != nil || n != 0 {
+		t.Fatalf("lock is left: %d %v", n, err)
+	}
+	v, err := loadSchemaVersion(c)
+	if err != nil || v != migrations[len(migrations)-1].Version {
+		t.Fatalf("schema version: %d %v", v, err)
+	}
+}
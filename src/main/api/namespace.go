@@ -0,0 +1,207 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// nsKeys lists positions of keys in arguments of commands, which are not
+// simply "the first argument is key": -1 means all arguments are keys.
+var nsKeys = map[string][]int{
+	"DEL":     {-1},
+	"EXISTS":  {-1},
+	"MGET":    {-1},
+	"WATCH":   {-1},
+	"RENAME":  {0, 1},
+	"RESTORE": {0},
+	"SINTER":  {-1},
+	"SUNION":  {-1},
+	"SDIFF":   {-1},
+
+	"PING":    nil,
+	"ECHO":    nil,
+	"AUTH":    nil,
+	"SELECT":  nil,
+	"MULTI":   nil,
+	"EXEC":    nil,
+	"DISCARD": nil,
+	"UNWATCH": nil,
+	"INFO":    nil,
+	"DBSIZE":  nil,
+	"FLUSHDB": nil,
+	"SCAN":    nil,
+	"KEYS":    nil,
+	"SCRIPT":  nil,
+}
+
+// nsRediser prefixes all keys of commands with namespace, so several catalogs
+// can share one Redis database.
+type nsRediser struct {
+	r  rediser
+	ns string
+}
+
+func newNSRediser(r rediser, ns string) rediser {
+	if ns == "" {
+		return r
+	}
+	return &nsRediser{r: r, ns: ns + ":"}
+}
+
+func (r *nsRediser) Get() redis.Conn {
+	return &nsConn{Conn: r.r.Get(), ns: r.ns}
+}
+
+type nsConn struct {
+	redis.Conn
+	ns   string
+	sent []string
+}
+
+func (c *nsConn) Send(cmd string, args ...interface{}) error {
+	cmd = strings.ToUpper(cmd)
+	err := c.Conn.Send(cmd, c.mixArgs(cmd, args)...)
+	if err != nil {
+		return err
+	}
+	c.sent = append(c.sent, cmd)
+	return nil
+}
+
+func (c *nsConn) Receive() (interface{}, error) {
+	cmd := ""
+	if len(c.sent) > 0 {
+		cmd, c.sent = c.sent[0], c.sent[1:]
+	}
+	res, err := c.Conn.Receive()
+	return c.fixReply(cmd, res), err
+}
+
+func (c *nsConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	cmd = strings.ToUpper(cmd)
+	c.sent = nil
+	res, err := c.Conn.Do(cmd, c.mixArgs(cmd, args)...)
+	return c.fixReply(cmd, res), err
+}
+
+func (c *nsConn) mixArgs(cmd string, args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return args
+	}
+
+	v := make([]interface{}, len(args))
+	copy(v, args)
+
+	switch cmd {
+	case "SCAN":
+		return c.mixMatch(v, 1)
+	case "KEYS":
+		v[0] = c.ns + redisString(v[0])
+		return v
+	case "XREAD":
+		return c.mixStreams(v)
+	case "EVAL", "EVALSHA":
+		return c.mixScript(v)
+	}
+
+	pos, ok := nsKeys[cmd]
+	if !ok {
+		pos = []int{0}
+	}
+
+	for _, i := range pos {
+		if i < 0 {
+			for j := range v {
+				v[j] = c.ns + redisString(v[j])
+			}
+			break
+		}
+		if i < len(v) {
+			v[i] = c.ns + redisString(v[i])
+		}
+	}
+
+	return v
+}
+
+// mixMatch prefixes MATCH pattern of SCAN or adds it if missing.
+func (c *nsConn) mixMatch(v []interface{}, from int) []interface{} {
+	for i := from; i < len(v)-1; i++ {
+		if strings.EqualFold(redisString(v[i]), "MATCH") {
+			v[i+1] = c.ns + redisString(v[i+1])
+			return v
+		}
+	}
+	return append(v, "MATCH", c.ns+"*")
+}
+
+// mixStreams prefixes keys of XREAD, they are the first half of arguments
+// after STREAMS.
+func (c *nsConn) mixStreams(v []interface{}) []interface{} {
+	for i := range v {
+		if strings.EqualFold(redisString(v[i]), "STREAMS") {
+			n := (len(v) - i - 1) / 2
+			for j := i + 1; j <= i+n; j++ {
+				v[j] = c.ns + redisString(v[j])
+			}
+			break
+		}
+	}
+	return v
+}
+
+// mixScript prefixes keys of EVAL and EVALSHA, they follow the number of keys.
+func (c *nsConn) mixScript(v []interface{}) []interface{} {
+	if len(v) < 2 {
+		return v
+	}
+	n, err := redis.Int(v[1], nil)
+	if err != nil {
+		n, _ = strconv.Atoi(redisString(v[1]))
+	}
+	for i := 2; i < 2+n && i < len(v); i++ {
+		v[i] = c.ns + redisString(v[i])
+	}
+	return v
+}
+
+// fixReply strips namespace from keys returned by SCAN and KEYS.
+func (c *nsConn) fixReply(cmd string, res interface{}) interface{} {
+	switch cmd {
+	case "SCAN":
+		v, ok := res.([]interface{})
+		if ok && len(v) == 2 {
+			v[1] = c.trimKeys(v[1])
+		}
+	case "KEYS":
+		res = c.trimKeys(res)
+	}
+	return res
+}
+
+func (c *nsConn) trimKeys(res interface{}) interface{} {
+	v, ok := res.([]interface{})
+	if !ok {
+		return res
+	}
+	for i := range v {
+		switch k := v[i].(type) {
+		case []byte:
+			v[i] = []byte(strings.TrimPrefix(string(k), c.ns))
+		case string:
+			v[i] = strings.TrimPrefix(k, c.ns)
+		}
+	}
+	return v
+}
+
+func redisString(v interface{}) string {
+	s, err := redis.String(v, nil)
+	if err == nil {
+		return s
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// nsCases are all commands sent by the package with the arguments expected
+// after namespace "ns" is added.
+var nsCases = []struct {
+	cmd  string
+	args []interface{}
+	want []interface{}
+}{
+	{"PING", nil, nil},
+	{"MULTI", nil, nil},
+	{"EXEC", nil, nil},
+	{"DISCARD", nil, nil},
+	{"UNWATCH", nil, nil},
+	{"WATCH", []interface{}{"a", "b"}, []interface{}{"ns:a", "ns:b"}},
+	{"DEL", []interface{}{"a", "b"}, []interface{}{"ns:a", "ns:b"}},
+	{"EXISTS", []interface{}{"a", "b"}, []interface{}{"ns:a", "ns:b"}},
+	{"MGET", []interface{}{"a", "b"}, []interface{}{"ns:a", "ns:b"}},
+	{"RENAME", []interface{}{"a", "b"}, []interface{}{"ns:a", "ns:b"}},
+	{"DUMP", []interface{}{"a"}, []interface{}{"ns:a"}},
+	{"RESTORE", []interface{}{"a", 0, "{}"}, []interface{}{"ns:a", 0, "{}"}},
+	{"KEYS", []interface{}{"a:*"}, []interface{}{"ns:a:*"}},
+	{"SCAN", []interface{}{0, "COUNT", 10}, []interface{}{0, "COUNT", 10, "MATCH", "ns:*"}},
+	{"SCAN", []interface{}{0, "MATCH", "a:*", "COUNT", 10}, []interface{}{0, "MATCH", "ns:a:*", "COUNT", 10}},
+	{"EVAL", []interface{}{"return 1", 2, "a", "b", "c"}, []interface{}{"return 1", 2, "ns:a", "ns:b", "c"}},
+	{"EVAL", []interface{}{"return 1", "1", "a", "b"}, []interface{}{"return 1", "1", "ns:a", "b"}},
+	{"GET", []interface{}{"a"}, []interface{}{"ns:a"}},
+	{"SET", []interface{}{"a", "b"}, []interface{}{"ns:a", "b"}},
+	{"HGET", []interface{}{"a", "b"}, []interface{}{"ns:a", "b"}},
+	{"HGETALL", []interface{}{"a"}, []interface{}{"ns:a"}},
+	{"HMGET", []interface{}{"a", "b", "c"}, []interface{}{"ns:a", "b", "c"}},
+	{"HSET", []interface{}{"a", "b", "c"}, []interface{}{"ns:a", "b", "c"}},
+	{"HMSET", []interface{}{"a", "b", "c"}, []interface{}{"ns:a", "b", "c"}},
+	{"HDEL", []interface{}{"a", "b"}, []interface{}{"ns:a", "b"}},
+	{"HINCRBY", []interface{}{"a", "b", 1}, []interface{}{"ns:a", "b", 1}},
+	{"LPUSH", []interface{}{"a", "b"}, []interface{}{"ns:a", "b"}},
+	{"LRANGE", []interface{}{"a", 0, -1}, []interface{}{"ns:a", 0, -1}},
+	{"LTRIM", []interface{}{"a", 0, 9}, []interface{}{"ns:a", 0, 9}},
+	{"SADD", []interface{}{"a", "b"}, []interface{}{"ns:a", "b"}},
+	{"SREM", []interface{}{"a", "b"}, []interface{}{"ns:a", "b"}},
+	{"SMEMBERS", []interface{}{"a"}, []interface{}{"ns:a"}},
+	{"ZADD", []interface{}{"a", 1, "b"}, []interface{}{"ns:a", 1, "b"}},
+	{"ZREM", []interface{}{"a", "b"}, []interface{}{"ns:a", "b"}},
+	{"ZCARD", []interface{}{"a"}, []interface{}{"ns:a"}},
+	{"ZSCORE", []interface{}{"a", "b"}, []interface{}{"ns:a", "b"}},
+	{"ZINCRBY", []interface{}{"a", 1, "b"}, []interface{}{"ns:a", 1, "b"}},
+	{"ZRANGE", []interface{}{"a", 0, -1}, []interface{}{"ns:a", 0, -1}},
+	{"ZREVRANGE", []interface{}{"a", 0, -1}, []interface{}{"ns:a", 0, -1}},
+	{"ZRANGEBYSCORE", []interface{}{"a", "-inf", "+inf"}, []interface{}{"ns:a", "-inf", "+inf"}},
+	{"ZREVRANGEBYSCORE", []interface{}{"a", "+inf", "-inf"}, []interface{}{"ns:a", "+inf", "-inf"}},
+	{"ZREMRANGEBYRANK", []interface{}{"a", 0, 1}, []interface{}{"ns:a", 0, 1}},
+	{"ZREMRANGEBYSCORE", []interface{}{"a", 0, 1}, []interface{}{"ns:a", 0, 1}},
+	{"ZSCAN", []interface{}{"a", 0, "MATCH", "b*"}, []interface{}{"ns:a", 0, "MATCH", "b*"}},
+	{"XADD", []interface{}{"a", "MAXLEN", "~", 10, "*", "b", "c"}, []interface{}{"ns:a", "MAXLEN", "~", 10, "*", "b", "c"}},
+	{"XRANGE", []interface{}{"a", "-", "+"}, []interface{}{"ns:a", "-", "+"}},
+	{"XREVRANGE", []interface{}{"a", "+", "-"}, []interface{}{"ns:a", "+", "-"}},
+	{"XREAD", []interface{}{"COUNT", 10, "BLOCK", 0, "STREAMS", "a", "b", "0", "$"}, []interface{}{"COUNT", 10, "BLOCK", 0, "STREAMS", "ns:a", "ns:b", "0", "$"}},
+}
+
+func TestNSKeys(t *testing.T) {
+	c := &nsConn{ns: "ns:"}
+	for _, x := range nsCases {
+		got := c.mixArgs(x.cmd, x.args)
+		if len(got) != len(x.want) {
+			t.Errorf("%s %v: got %v, want %v", x.cmd, x.args, got, x.want)
+			continue
+		}
+		for i := range got {
+			if redisString(got[i]) != redisString(x.want[i]) {
+				t.Errorf("%s %v: got %v, want %v", x.cmd, x.args, got, x.want)
+				break
+			}
+		}
+	}
+}
+
+// TestNSKeysCoverage fails if the package sends a command, which is not in
+// nsCases, so keys of every new command are checked by TestNSKeys.
+func TestNSKeysCoverage(t *testing.T) {
+	known := make(map[string]bool, len(nsCases))
+	for _, x := range nsCases {
+		known[x.cmd] = true
+	}
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pkg := range pkgs {
+		ast.Inspect(pkg, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "Send" && sel.Sel.Name != "Do") {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			cmd, err := strconv.Unquote(lit.Value)
+			if err != nil || cmd == "" {
+				return true
+			}
+			if !known[strings.ToUpper(cmd)] {
+				t.Errorf("%s: command %s is not in nsCases", fset.Position(lit.Pos()), cmd)
+			}
+			return true
+		})
+	}
+}
+
+func TestNSFixReply(t *testing.T) {
+	c := &nsConn{ns: "ns:"}
+
+	got := c.fixReply("KEYS", []interface{}{[]byte("ns:a"), "ns:b"})
+	want := []interface{}{[]byte("a"), "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("KEYS: got %v, want %v", got, want)
+	}
+
+	got = c.fixReply("SCAN", []interface{}{[]byte("0"), []interface{}{[]byte("ns:a")}})
+	want = []interface{}{[]byte("0"), []interface{}{[]byte("a")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SCAN: got %v, want %v", got, want)
+	}
+}
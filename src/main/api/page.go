@@ -0,0 +1,276 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/garyburd/redigo/redis"
+	"golang.org/x/text/collate"
+)
+
+// Lists are paged by Content-Meta header of request {"limit":100,"cursor":""},
+// the answer has Content-Meta {"total":1234,"cursor":"..."} with size of the
+// whole list and cursor of the next page, which is missing on the last page.
+// Cursor keeps id and sort keys of the last item, so pages do not shift if
+// items before it are added or removed, and the next page starts after its
+// place in the order if the item itself is gone. Lists are sorted with id as
+// the last key, so the order is the same on every page. Lists of entities
+// load sort keys of all items and the rest for the page only, see loadList.
+const headerMeta = "Content-Meta"
+
+// jsonMeta is paging and projection of request.
+type jsonMeta struct {
+	Limit  int      `json:"limit,omitempty"`
+	Cursor string   `json:"cursor,omitempty"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// jsonPage is paging of answer.
+type jsonPage struct {
+	Total  int    `json:"total"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// pageCursor is decoded cursor: id and sort keys of the last item.
+type pageCursor struct {
+	ID  int64    `json:"id,omitempty"`
+	Key *pageKey `json:"key,omitempty"`
+}
+
+// pageKey is sort keys of list item in order of comparison: full ones go
+// first, then greater rank (value of drug, sale of spec) and name by
+// collation, id is compared last, see comparePageKeys.
+type pageKey struct {
+	Full bool    `json:"full,omitempty"`
+	Rank float64 `json:"rank,omitempty"`
+	Name string  `json:"name,omitempty"`
+}
+
+// pager is list, which items have sort keys, sale is taken into account by
+// lists of specs sorted for hack.
+type pager interface {
+	ruler
+	pageKey(i int, sale bool) pageKey
+}
+
+// parseMeta returns paging of request, nil if the header is empty.
+func parseMeta(b []byte) (*jsonMeta, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	m := &jsonMeta{}
+	err := json.Unmarshal(b, m)
+	if err == nil && m.Limit < 0 {
+		err = fmt.Errorf("negative limit")
+	}
+	if err == nil && m.Cursor != "" {
+		_, err = decodeCursor(m.Cursor)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", headerMeta, err)
+	}
+
+	return m, nil
+}
+
+func encodeCursor(c pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// loadList loads list v of p sorted by sortList, the page of request is
+// taken by the first loaded list: only sort keys are loaded for all items,
+// the rest of list fields for the page.
+func loadList(h *ctxHelper, c redis.Conn, p string, v ruler) (ruler, error) {
+	st := newStorage(c)
+	if h.page == nil {
+		err := st.loadHashers(h, p, v, true)
+		if err != nil {
+			return nil, err
+		}
+		normLang(h.lang, p, v)
+		sortList(h, v)
+		return v, nil
+	}
+
+	// projectKeep has sort keys of all lists
+	x := h.clone()
+	x.fields = projection{}
+	err := st.loadHashers(x, p, v, true)
+	if err != nil {
+		return nil, err
+	}
+	normLang(h.lang, p, v)
+	sortList(h, v)
+
+	v = pageResult(h, v).(ruler)
+
+	err = st.loadHashers(h, p, v, true)
+	if err != nil {
+		return nil, err
+	}
+	normLang(h.lang, p, v)
+
+	return v, nil
+}
+
+// sortList sorts lists of entities as their get-*-list do, it reports
+// whether the type of list is known.
+func sortList(h *ctxHelper, v interface{}) bool {
+	switch x := v.(type) {
+	case jsonINNs:
+		x.sort(h.lang)
+	case jsonMakers:
+		x.sort(h.lang)
+	case jsonDrugs:
+		x.sort(h.lang)
+	case jsonClasses:
+		x.sort(h.lang)
+	case jsonSpecs:
+		x.sort(h.lang, h.hack != "")
+	default:
+		return false
+	}
+	return true
+}
+
+// pageResult returns page of list res by the paging of request and sets
+// paging of answer to header, other results are returned as is. The paging
+// is taken, so lists loaded later by the request are not paged.
+func pageResult(h *ctxHelper, res interface{}) interface{} {
+	v := reflect.ValueOf(res)
+	m := h.page
+	if m == nil || v.Kind() != reflect.Slice {
+		return res
+	}
+	h.page = nil
+
+	n := v.Len()
+	from := 0
+	if m.Cursor != "" {
+		c, _ := decodeCursor(m.Cursor)
+		from = pageFrom(h, res, c)
+	}
+
+	to := n
+	if m.Limit > 0 && from+m.Limit < n {
+		to = from + m.Limit
+	}
+
+	p := &jsonPage{Total: n}
+	if to < n {
+		var c pageCursor
+		if to > 0 {
+			c = makeCursor(h, res, to-1)
+		}
+		p.Cursor = encodeCursor(c)
+	}
+
+	b, _ := json.Marshal(p)
+	h.w.Header().Set(headerMeta, string(b))
+
+	return v.Slice(from, to).Interface()
+}
+
+// makeCursor returns cursor of item i of list: its id and sort keys.
+func makeCursor(h *ctxHelper, res interface{}, i int) pageCursor {
+	var c pageCursor
+	c.ID, _ = itemID(reflect.ValueOf(res).Index(i))
+	if x, ok := res.(pager); ok && !x.null(i) {
+		k := x.pageKey(i, h.hack != "")
+		c.Key = &k
+	}
+	return c
+}
+
+// pageFrom returns position of the item next to the cursor in sorted list v:
+// the item of cursor is placed in the list by its sort keys if it is gone.
+// Lists without sort keys are taken as sorted by id.
+func pageFrom(h *ctxHelper, res interface{}, c pageCursor) int {
+	v := reflect.ValueOf(res)
+	n := v.Len()
+	if c.ID == 0 {
+		return 0
+	}
+	for i := 0; i < n; i++ {
+		if id, ok := itemID(v.Index(i)); ok && id == c.ID {
+			return i + 1
+		}
+	}
+
+	if x, ok := res.(pager); ok && c.Key != nil {
+		coll := newCollator(h.lang)
+		sale := h.hack != ""
+		// null items are sorted last
+		return sort.Search(n, func(i int) bool {
+			if x.null(i) {
+				return true
+			}
+			id, _ := itemID(v.Index(i))
+			return comparePageKeys(coll, x.pageKey(i, sale), id, *c.Key, c.ID) > 0
+		})
+	}
+
+	for i := 0; i < n; i++ {
+		if id, ok := itemID(v.Index(i)); ok && id > c.ID {
+			return i
+		}
+	}
+	return n
+}
+
+// comparePageKeys compares sort keys of items a and b with their ids as it
+// is done by sort of lists.
+func comparePageKeys(coll *collate.Collator, a pageKey, aID int64, b pageKey, bID int64) int {
+	switch {
+	case a.Full && !b.Full:
+		return -1
+	case !a.Full && b.Full:
+		return 1
+	case a.Rank > b.Rank:
+		return -1
+	case a.Rank < b.Rank:
+		return 1
+	}
+	if n := coll.CompareString(a.Name, b.Name); n != 0 {
+		return n
+	}
+	switch {
+	case aID < bID:
+		return -1
+	case aID > bID:
+		return 1
+	}
+	return 0
+}
+
+// itemID returns id of list item: entity or id itself.
+func itemID(v reflect.Value) (int64, bool) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return 0, false
+	}
+	switch x := v.Interface().(type) {
+	case ider:
+		return x.getID(), true
+	case int64:
+		return x, true
+	}
+	return 0, false
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// page requests page of list and returns ids of items with paging of answer.
+func (a *testAPI) page(path, body, cursor string, limit int) ([]int64, jsonPage) {
+	a.t.Helper()
+	m, _ := json.Marshal(jsonMeta{Limit: limit, Cursor: cursor})
+	w := a.call(path, body, headerMeta, string(m))
+	if w.Code != http.StatusOK {
+		a.t.Fatalf("%s: %d %s", path, w.Code, w.Body.String())
+	}
+
+	var v []*struct {
+		ID int64 `json:"id"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &v)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	ids := make([]int64, 0, len(v))
+	for i := range v {
+		if v[i] != nil {
+			ids = append(ids, v[i].ID)
+		}
+	}
+
+	var p jsonPage
+	err = json.Unmarshal([]byte(w.Header().Get(headerMeta)), &p)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return ids, p
+}
+
+// TestPageGone deletes the last item of the page, the next page must start
+// after its place in the order.
+func TestPageGone(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Г"},{"id":2,"name_ru":"Б"},{"id":3,"name_ru":"Д"},{"id":4,"name_ru":"В"},{"id":5,"name_ru":"А"}]`)
+
+	ids, p := a.page("/get-inn-list", `[1,2,3,4,5]`, "", 2)
+	if !reflect.DeepEqual(ids, []int64{5, 2}) || p.Total != 5 || p.Cursor == "" {
+		t.Fatalf("page 1: %v %+v", ids, p)
+	}
+
+	a.ok(nil, "/del-inn", `[2]`)
+	ids, p = a.page("/get-inn-list", `[1,2,3,4,5]`, p.Cursor, 2)
+	if !reflect.DeepEqual(ids, []int64{4, 1}) {
+		t.Fatalf("page 2: %v %+v", ids, p)
+	}
+
+	ids, p = a.page("/get-inn-list", `[1,2,3,4,5]`, p.Cursor, 2)
+	if !reflect.DeepEqual(ids, []int64{3}) || p.Cursor != "" {
+		t.Fatalf("page 3: %v %+v", ids, p)
+	}
+}
+
+// TestPageLoad checks that makers of specs are loaded for the page only.
+func TestPageLoad(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-maker", `[{"id":1,"name_ru":"Байер"}]`)
+	a.ok(nil, "/set-spec-inf", `[{"id":101,"name_ru":"А","id_make":[1]},{"id":102,"name_ru":"Б","id_make":[1]},{"id":103,"name_ru":"В","id_make":[1]},{"id":104,"name_ru":"Г","id_make":[1]}]`)
+
+	var n int
+	r := hookRediser{a.s, func(cmd string, args []interface{}) error {
+		if cmd == "HMGET" && strings.HasPrefix(redisString(args[0]), prefixMaker+":") {
+			n++
+		}
+		return nil
+	}}
+	b := newTestAPI(t, Redis(r))
+
+	ids, p := b.page("/get-spec-inf-list", `[104,103,102,101]`, "", 2)
+	if !reflect.DeepEqual(ids, []int64{101, 102}) || p.Total != 4 || n != 2 {
+		t.Fatalf("page: %v %+v, makers loaded %d times", ids, p, n)
+	}
+}
+
+// TestPageFromKeys places gone items of cursor in lists of specs by their
+// sort keys: full ones first, then by sale for hack and by name.
+func TestPageFromKeys(t *testing.T) {
+	v := jsonSpecs{
+		{ID: 1, Full: true, Name: "Б", Sale: 1},
+		{ID: 2, Full: true, Name: "Г", Sale: 3},
+		{ID: 3, Name: "А", Sale: 2},
+		{ID: 4, Name: "В"},
+		nil,
+	}
+	h := &ctxHelper{lang: "ru"}
+
+	for _, x := range []struct {
+		key  pageKey
+		id   int64
+		from int
+	}{
+		{pageKey{Full: true, Name: "В"}, 9, 1},
+		{pageKey{Full: true, Name: "Б"}, 9, 1},
+		{pageKey{Name: "Б"}, 9, 3},
+		{pageKey{Name: "Я"}, 9, 4},
+	} {
+		if n := pageFrom(h, v, pageCursor{ID: x.id, Key: &x.key}); n != x.from {
+			t.Errorf("%+v %d: from %d, want %d", x.key, x.id, n, x.from)
+		}
+	}
+
+	h.hack = "1"
+	v[0], v[1] = v[1], v[0]
+	if n := pageFrom(h, v, pageCursor{ID: 9, Key: &pageKey{Full: true, Rank: 2}}); n != 1 {
+		t.Errorf("from %d by sale", n)
+	}
+	if c := makeCursor(h, v, 0); c.ID != 2 || c.Key == nil || *c.Key != (pageKey{Full: true, Rank: 3, Name: "Г"}) {
+		t.Errorf("cursor %+v", c)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"internal/ctxutil"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Write modes of set-* endpoints are given by Write-Mode header: merge (the
+// default) sets non zero fields of items, replace drops fields missing in
+// items, patch applies items as JSON Merge Patch (RFC 7386) to the current
+// entities, so null removes the field.
+const (
+	writeMerge   = "merge"
+	writeReplace = "replace"
+	writePatch   = "patch"
+
+	mimeMergePatch = "application/merge-patch+json"
+)
+
+// parseWriteMode returns write mode of request, merge patch content type
+// means the patch mode.
+func parseWriteMode(mode, mime string) (string, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" && strings.HasPrefix(mime, mimeMergePatch) {
+		mode = writePatch
+	}
+	switch mode {
+	case "", writeMerge:
+		return writeMerge, nil
+	case writeReplace, writePatch:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown Write-Mode %q", mode)
+}
+
+// mergePatch applies items of request as merge patches to current states of
+// entities, it returns the resulting entities, which are written as is by
+// the replace mode. Entities of p are made by newHasher and must be watched.
+func mergePatch(h *ctxHelper, c redis.Conn, p string, newHasher func(int64) hasher) ([]byte, error) {
+	k := versionKind{make: newHasher}
+	var v []map[string]interface{}
+	err := decodeNumbers(h.data, &v)
+	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, err
+	}
+
+	ids := make([]int64, len(v))
+	for i := range v {
+		if v[i] == nil {
+			continue
+		}
+		if n, ok := v[i]["id"].(json.Number); ok {
+			ids[i], _ = n.Int64()
+		}
+	}
+
+	x, err := loadSnapshots(c, p, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]interface{}, len(v))
+	for i := range v {
+		if v[i] == nil {
+			continue
+		}
+
+		cur := make(map[string]interface{})
+		if x[i].Hash != nil {
+			b, err := json.Marshal(makeVersionHasher(k, ids[i], x[i]))
+			if err != nil {
+				return nil, err
+			}
+			err = decodeNumbers(b, &cur)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		res[i] = mergeObject(cur, v[i])
+	}
+
+	return json.Marshal(res)
+}
+
+// decodeNumbers decodes JSON keeping numbers as is, so ids do not lose
+// precision.
+func decodeNumbers(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// mergeObject applies patch to target as described by RFC 7386.
+func mergeObject(target, patch map[string]interface{}) map[string]interface{} {
+	for k, v := range patch {
+		if v == nil {
+			delete(target, k)
+			continue
+		}
+		if p, ok := v.(map[string]interface{}); ok {
+			t, _ := target[k].(map[string]interface{})
+			if t == nil {
+				t = make(map[string]interface{})
+			}
+			target[k] = mergeObject(t, p)
+			continue
+		}
+		target[k] = v
+	}
+	return target
+}
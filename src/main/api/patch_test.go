@@ -0,0 +1,30 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"internal/ctxutil"
+)
+
+// TestMergePatchMalformed checks that malformed patches are answered as bad
+// requests.
+func TestMergePatchMalformed(t *testing.T) {
+	a := newTestAPI(t)
+	c := a.s.Get()
+	defer c.Close()
+
+	for _, s := range []string{`[{"id":1}`, `{"id":1}`, `[1]`} {
+		h := &ctxHelper{ctx: context.Background(), data: []byte(s)}
+		_, err := mergePatch(h, c, prefixINN, newINNHasher)
+		if err == nil || ctxutil.CodeFrom(h.ctx) != http.StatusBadRequest {
+			t.Fatalf("%s: %d %v", s, ctxutil.CodeFrom(h.ctx), err)
+		}
+	}
+
+	w := a.call("/set-inn", `{"id":1,"slug":"paracetamol"}`, "Content-Type", mimeMergePatch)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("set-inn: %d %s", w.Code, w.Body.String())
+	}
+}
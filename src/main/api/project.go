@@ -0,0 +1,222 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Answers of get-* are projected by Content-Meta header of request
+// {"fields":["name","slug"]}, only these fields of entities are loaded and
+// marshalled, id is always kept. Language fields follow Accept-Language:
+// text is loaded as text_ua and answered as text after normLang, all
+// languages are kept if there is no language.
+type projection map[string]bool
+
+// projectKeep are hash fields, which are loaded anyway: reads sort, filter
+// and link entities by them, rev is answered as ETag.
+var projectKeep = []string{
+	"id",
+	"rev",
+	"id_node",
+	"id_make_gp",
+	"name_ru",
+	"name_ua",
+	"name_en",
+	"slug",
+	"full",
+	"sale",
+	"value",
+}
+
+// projectAlias maps JSON fields to hash fields named otherwise.
+var projectAlias = map[string]string{
+	"q": "quant",
+	"v": "value",
+}
+
+// projectLink maps link sets to JSON fields of linked entities named
+// otherwise, ids of them are answered as id_<field>.
+var projectLink = map[string]string{
+	prefixMaker: "make",
+}
+
+var projectLangs = []string{"ru", "ua", "en"}
+
+// newProjection returns JSON fields of answer, nil if fields are not given.
+func newProjection(fields []string, lang string) projection {
+	if fields == nil {
+		return nil
+	}
+
+	f := projection{"id": true}
+	for _, s := range fields {
+		f[s] = true
+		if lang != "" {
+			f[s+"_"+lang] = true
+			continue
+		}
+		for _, l := range projectLangs {
+			f[s+"_"+l] = true
+		}
+	}
+
+	return f
+}
+
+// pick returns indexes of hash fields to load.
+func (f projection) pick(fields []interface{}) []int {
+	keep := make(map[string]bool, len(f)+len(projectKeep))
+	for s := range f {
+		keep[s] = true
+		if a, ok := projectAlias[s]; ok {
+			keep[a] = true
+		}
+	}
+	for _, s := range projectKeep {
+		keep[s] = true
+	}
+
+	var res []int
+	for i := range fields {
+		if s, ok := fields[i].(string); ok && keep[s] {
+			res = append(res, i)
+		}
+	}
+
+	return res
+}
+
+// links returns link sets of ls, which ids or linked entities are in f, all
+// of them if f is nil.
+func (f projection) links(ls []string) []string {
+	if f == nil {
+		return ls
+	}
+
+	var res []string
+	for _, l := range ls {
+		s, ok := projectLink[l]
+		if !ok {
+			s = strings.Replace(l, ":", "_", -1)
+		}
+		if f[s] || f["id_"+s] {
+			res = append(res, l)
+		}
+	}
+
+	return res
+}
+
+// projectField is JSON field of entity: index of struct field and its name.
+type projectField struct {
+	index     int
+	name      string
+	omitEmpty bool
+}
+
+// projectFields caches JSON fields of entity types.
+var projectFields sync.Map
+
+// typeFields returns JSON fields of struct type t in order of marshalling.
+func typeFields(t reflect.Type) []projectField {
+	if x, ok := projectFields.Load(t); ok {
+		return x.([]projectField)
+	}
+
+	var res []projectField
+	for i := 0; i < t.NumField(); i++ {
+		s := t.Field(i)
+		tag := s.Tag.Get("json")
+		if s.PkgPath != "" || tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.IndexByte(tag, ','); j >= 0 {
+			name, opts = tag[:j], tag[j+1:]
+		}
+		if name == "" {
+			name = s.Name
+		}
+		res = append(res, projectField{index: i, name: name, omitEmpty: strings.Contains(opts, "omitempty")})
+	}
+
+	projectFields.Store(t, res)
+	return res
+}
+
+// projectResult returns entities of res with fields of f only, other
+// results are returned as is. Only projected fields are marshalled, they go
+// in the order of struct fields as by json.Marshal.
+func projectResult(res interface{}, f projection) (interface{}, error) {
+	v := reflect.ValueOf(res)
+	if f == nil || v.Kind() != reflect.Slice {
+		return res, nil
+	}
+	t := v.Type().Elem()
+	if _, ok := reflect.Zero(t).Interface().(hasher); !ok || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return res, nil
+	}
+
+	var fields []projectField
+	for _, x := range typeFields(t.Elem()) {
+		if f[x.name] {
+			fields = append(fields, x)
+		}
+	}
+
+	x := make([]json.RawMessage, v.Len())
+	for i := range x {
+		e := v.Index(i)
+		if e.IsNil() {
+			x[i] = json.RawMessage("null")
+			continue
+		}
+		e = e.Elem()
+
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		for _, p := range fields {
+			val := e.Field(p.index)
+			if p.omitEmpty && emptyValue(val) {
+				continue
+			}
+			b, err := json.Marshal(val.Interface())
+			if err != nil {
+				return nil, err
+			}
+			if buf.Len() > 1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('"')
+			buf.WriteString(p.name)
+			buf.WriteString(`":`)
+			buf.Write(b)
+		}
+		buf.WriteByte('}')
+		x[i] = buf.Bytes()
+	}
+
+	return x, nil
+}
+
+// emptyValue reports whether v is omitted by omitempty of encoding/json.
+func emptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
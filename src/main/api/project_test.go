@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newProjectAPI writes spec 101 linked to inn 1 and maker 2, loads of specs
+// by API with hook are reported to hook.
+func newProjectAPI(t *testing.T, hook func(cmd string, args []interface{})) *testAPI {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"}]`)
+	a.ok(nil, "/set-maker", `[{"id":2,"name_ru":"Байер"}]`)
+	a.ok(nil, "/set-spec-inf", `[{"id":101,"name_ru":"Панадол","text_ru":"Текст","id_inn":[1],"id_make":[2]}]`)
+
+	r := hookRediser{a.s, func(cmd string, args []interface{}) error {
+		if len(args) > 0 && strings.HasPrefix(redisString(args[0]), prefixSpecINF+":") {
+			hook(cmd, args)
+		}
+		return nil
+	}}
+	return newTestAPI(t, Redis(r))
+}
+
+// TestProjectLoad checks that fields and link sets dropped by projection are
+// not loaded.
+func TestProjectLoad(t *testing.T) {
+	var sets, fields []string
+	a := newProjectAPI(t, func(cmd string, args []interface{}) {
+		switch cmd {
+		case "SMEMBERS":
+			sets = append(sets, redisString(args[0]))
+		case "HMGET":
+			for _, x := range args[1:] {
+				fields = append(fields, redisString(x))
+			}
+		}
+	})
+
+	w := a.call("/get-spec-inf", `[101]`, headerMeta, `{"fields":["name","id_inn"]}`)
+	if s := strings.TrimSpace(w.Body.String()); s != `[{"id":101,"id_inn":[1],"name":"Панадол"}]` {
+		t.Errorf("answer %s", s)
+	}
+	if !reflect.DeepEqual(sets, []string{genKey(prefixSpecINF, 101, prefixINN)}) {
+		t.Errorf("link sets %v", sets)
+	}
+	for _, s := range fields {
+		if strings.HasPrefix(s, "text") {
+			t.Errorf("fields %v", fields)
+			break
+		}
+	}
+
+	// linked entities need link sets as well
+	sets = nil
+	var v []*jsonSpec
+	a.ok(&v, "/get-spec-inf-with-deps", `[101]`, headerMeta, `{"fields":["make"]}`)
+	if len(v) != 1 || len(v[0].Make) != 1 || v[0].Make[0].ID != 2 || v[0].IDMake != nil || v[0].INN != nil {
+		t.Errorf("answer with deps %+v", v)
+	}
+	if !reflect.DeepEqual(sets, []string{genKey(prefixSpecINF, 101, prefixMaker)}) {
+		t.Errorf("link sets with deps %v", sets)
+	}
+
+	// all link sets are loaded without projection
+	sets = nil
+	a.ok(nil, "/get-spec-inf", `[101]`)
+	if len(sets) != len(specLinks) {
+		t.Errorf("link sets without projection %v", sets)
+	}
+}
+
+// TestProjectResult checks that projected entities are marshalled as by
+// json.Marshal with fields missing in projection dropped.
+func TestProjectResult(t *testing.T) {
+	v := jsonSpecs{
+		{ID: 1, IDINN: []int64{2}, IDMake: []int64{}, Name: "А", Full: true, Sale: 1.5, INN: jsonINNs{{ID: 2, Name: "Б"}}},
+		nil,
+		{ID: 3},
+	}
+
+	for _, fields := range [][]string{
+		nil,
+		{},
+		{"name", "id_make", "inn"},
+		{"full", "sale", "slug"},
+	} {
+		f := newProjection(fields, "ru")
+		if f == nil {
+			f = projection{}
+			for _, x := range typeFields(reflect.TypeOf(jsonSpec{})) {
+				f[x.name] = true
+			}
+		}
+
+		res, err := projectResult(v, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := json.Marshal(res)
+
+		// the same answer is made by json.Marshal and dropping of fields
+		var all []map[string]json.RawMessage
+		b, _ := json.Marshal(v)
+		_ = json.Unmarshal(b, &all)
+		for _, m := range all {
+			for k := range m {
+				if !f[k] {
+					delete(m, k)
+				}
+			}
+		}
+		want, _ := json.Marshal(all)
+
+		var x, y interface{}
+		_ = json.Unmarshal(got, &x)
+		_ = json.Unmarshal(want, &y)
+		if !reflect.DeepEqual(x, y) {
+			t.Errorf("%v: %s, want %s", fields, got, want)
+		}
+	}
+
+	// fields go in the order of struct fields
+	res, _ := projectResult(v[:1], projection{"sale": true, "name": true, "id": true})
+	got, _ := json.Marshal(res)
+	if string(got) != `[{"id":1,"name":"А","sale":1.5}]` {
+		t.Errorf("order %s", got)
+	}
+
+	// other results are kept
+	ids := []int64{1, 2}
+	if res, _ := projectResult(ids, projection{}); !reflect.DeepEqual(res, ids) {
+		t.Errorf("ids %v", res)
+	}
+}
@@ -12,7 +12,11 @@ import (
 	"golang.org/x/text/language"
 )
 
-var statusOK = http.StatusText(http.StatusOK)
+var (
+	statusOK    = http.StatusText(http.StatusOK)
+	errConflict = fmt.Errorf("concurrent modification, try again")
+	errResync   = fmt.Errorf("sync cursor is older than retention horizon, resync required")
+)
 
 type rediser interface {
 	Get() redis.Conn
@@ -50,7 +54,100 @@ type searcher interface {
 	getSrchEN(string) ([]string, []rune)
 }
 
-func freeLinkIDs(c redis.Conn, p1, p2 string, s bool, x int64, v ...int64) error {
+// multiExec runs commands sent by fn as one MULTI/EXEC transaction. Keys should
+// be watched before data is read, so concurrent changes abort the transaction.
+func multiExec(c redis.Conn, fn func() error) error {
+	err := c.Send("MULTI")
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	if err != nil {
+		_, _ = c.Do("DISCARD")
+		return err
+	}
+
+	res, err := redis.Values(c.Do("EXEC"))
+	if err == redis.ErrNil {
+		return errConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range res {
+		if e, ok := res[i].(redis.Error); ok {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// watchLinks are link sets held by entities of prefix, writes read them and
+// replace, so they are watched with hashes.
+var watchLinks = map[string][]string{
+	prefixSpecACT: specLinks,
+	prefixSpecINF: specLinks,
+	prefixSpecDEC: specLinks,
+}
+
+// watchHashers watches hashes and link sets of p, sync counter is not
+// watched, saveHashers and freeHashers take its values by addSyncRev. Values
+// of hashes are kept for audit and versions of request, only the written
+// fields are kept and link sets are not watched for updates of derived fields
+// if onlyUpdate is set.
+func (st redisStorage) watchHashers(h *ctxHelper, p string, v ruler, onlyUpdate ...bool) error {
+	c := st.c
+	if v.len() == 0 {
+		return nil
+	}
+
+	// updates of fields leave links as they are
+	links := watchLinks[p]
+	if len(onlyUpdate) > 0 {
+		links = nil
+	}
+	keys := make([]interface{}, 0, v.len()*(1+len(links)))
+	for i := 0; i < v.len(); i++ {
+		if v.null(i) {
+			continue
+		}
+		if x, ok := v.elem(i).(ider); ok {
+			keys = append(keys, genKey(p, x.getID()))
+			for _, l := range links {
+				keys = append(keys, genKey(p, x.getID(), l))
+			}
+		}
+	}
+
+	_, err := c.Do("WATCH", keys...)
+	if err != nil {
+		return err
+	}
+
+	if h != nil && !h.quiet {
+		if len(onlyUpdate) > 0 {
+			err = h.watchFields(c, p, v)
+		} else {
+			err = h.watchOld(c, p, mineIDsFromHashers(v))
+		}
+		if err != nil {
+			return err
+		}
+		err = h.checkRevisions(p, v)
+		if err != nil {
+			_, _ = c.Do("UNWATCH")
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (st redisStorage) freeLinkIDs(h *ctxHelper, p1, p2 string, s bool, x int64, v ...int64) error {
+	c := st.c
 	if len(v) == 0 {
 		return nil
 	}
@@ -63,6 +160,10 @@ func freeLinkIDs(c redis.Conn, p1, p2 string, s bool, x int64, v ...int64) error
 		if err != nil {
 			return err
 		}
+		err = addLinkChange(h, c, p2, v[i], "unlink")
+		if err != nil {
+			return err
+		}
 	}
 
 	if s { // symmetrically
@@ -76,7 +177,8 @@ func freeLinkIDs(c redis.Conn, p1, p2 string, s bool, x int64, v ...int64) error
 	return c.Flush()
 }
 
-func saveLinkIDs(c redis.Conn, p1, p2 string, s bool, x int64, v ...int64) error {
+func (st redisStorage) saveLinkIDs(h *ctxHelper, p1, p2 string, s bool, x int64, v ...int64) error {
+	c := st.c
 	if len(v) == 0 {
 		return nil
 	}
@@ -90,6 +192,10 @@ func saveLinkIDs(c redis.Conn, p1, p2 string, s bool, x int64, v ...int64) error
 		if err != nil {
 			return err
 		}
+		err = addLinkChange(h, c, p2, v[i], "link")
+		if err != nil {
+			return err
+		}
 		val[i+1] = v[i]
 	}
 
@@ -105,7 +211,8 @@ func saveLinkIDs(c redis.Conn, p1, p2 string, s bool, x int64, v ...int64) error
 	return c.Flush()
 }
 
-func loadLinkIDs(c redis.Conn, p1, p2 string, x int64) ([]int64, error) {
+func (st redisStorage) loadLinkIDs(p1, p2 string, x int64) ([]int64, error) {
+	c := st.c
 	key := genKey(p1, x, p2)
 	res, err := redis.Values(c.Do("SMEMBERS", key))
 	if err != nil {
@@ -120,40 +227,57 @@ func loadLinkIDs(c redis.Conn, p1, p2 string, x int64) ([]int64, error) {
 	return out, nil
 }
 
-func loadLinkIDsForClass(c redis.Conn, p1, p2 string, x int64) ([]int64, error) {
-	res := make([]int64, 0, 100)
-	res = append(res, x)
-	var i int
-	for done := false; !done; {
-		pre := len(res)
-		for _, v := range res[i:] {
-			tmp, err := loadLinkIDs(c, p1, "next", v)
+// loadLinkSets loads link sets of entities in one round trip, res[i][l] are
+// ids linked to ids[i] by link l.
+func (st redisStorage) loadLinkSets(p string, ids []int64, links ...string) ([]map[string][]int64, error) {
+	c := st.c
+	res := make([]map[string][]int64, len(ids))
+	if len(ids) == 0 || len(links) == 0 {
+		return res, nil
+	}
+
+	for _, x := range ids {
+		for _, l := range links {
+			err := c.Send("SMEMBERS", genKey(p, x, l))
 			if err != nil {
 				return nil, err
 			}
-			if len(tmp) > 0 {
-				res = append(res, tmp...)
+		}
+	}
+
+	v, err := redis.Values(c.Do(""))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range ids {
+		res[i] = make(map[string][]int64, len(links))
+		for j, l := range links {
+			res[i][l], err = redis.Int64s(v[i*len(links)+j], nil)
+			if err != nil {
+				return nil, err
 			}
 		}
-		i = pre
-		done = len(res)-i == 0
 	}
 
-	out := make([]int64, 0, len(res))
-	for i := range res {
-		tmp, err := loadLinkIDs(c, p1, p2, res[i])
+	return res, nil
+}
+
+// loadSyncIDs returns ids changed since v, deleted ones for negative v.
+// Tombstones older than the horizon are compacted, so such cursors are
+// rejected with errResync.
+func (st redisStorage) loadSyncIDs(p string, v int64) ([]int64, error) {
+	c := st.c
+	if v != 0 {
+		t, err := loadSyncHorizon(c, p)
 		if err != nil {
 			return nil, err
 		}
-		if len(tmp) > 0 {
-			out = append(out, tmp...)
+		if abs64(v) < t {
+			return nil, errResync
 		}
 	}
 
-	return uniqInt64(out), nil
-}
-
-func loadSyncIDs(c redis.Conn, p string, v int64) ([]int64, error) {
 	val := make([]interface{}, 0, 3)
 	val = append(val, genKey(p, "sync"))
 	if v >= 0 {
@@ -196,6 +320,26 @@ func mixKeyAndFields(p string, list bool, h hasher) []interface{} {
 	return append(append(r, genKey(p, h.getID())), f...)
 }
 
+// pickFields appends fields at indexes x to r.
+func pickFields(r, fields []interface{}, x []int) []interface{} {
+	for _, i := range x {
+		r = append(r, fields[i])
+	}
+	return r
+}
+
+// spreadFields returns n values with values of fields at indexes x, the
+// rest are nil as missing.
+func spreadFields(v []interface{}, n int, x []int) []interface{} {
+	r := make([]interface{}, n)
+	for j, i := range x {
+		if j < len(v) {
+			r[i] = v[j]
+		}
+	}
+	return r
+}
+
 func mixKeyAndFieldsAndValues(p string, h hasher) []interface{} {
 	f := h.getFields(false)
 	v := h.getValues()
@@ -209,11 +353,17 @@ func mixKeyAndFieldsAndValues(p string, h hasher) []interface{} {
 	return r
 }
 
-func saveHashers(c redis.Conn, p string, v ruler, onlyUpdate ...bool) error {
+// saveHashers saves hashes and marks them in sync sets with the next values
+// of sync counter, sync sets are kept if onlyUpdate is set.
+func (st redisStorage) saveHashers(hlp *ctxHelper, p string, v ruler, onlyUpdate ...bool) error {
+	c := st.c
 	if v.len() == 0 {
 		return nil
 	}
 
+	// derived fields are updated in place
+	replace := hlp != nil && hlp.replace && len(onlyUpdate) == 0
+
 	var err error
 	for i := 0; i < v.len(); i++ {
 		if v.null(i) {
@@ -230,15 +380,50 @@ func saveHashers(c redis.Conn, p string, v ruler, onlyUpdate ...bool) error {
 			//		return err
 			//	}
 			//}
+			err = addVersion(hlp, c, p, h.getID(), len(onlyUpdate) > 0)
+			if err != nil {
+				return err
+			}
+			if len(onlyUpdate) == 0 {
+				hlp.followLinks(p, h.getID(), h)
+			}
+			if replace {
+				err = c.Send("DEL", genKey(p, h.getID()))
+				if err != nil {
+					return err
+				}
+			}
 			err = c.Send("HMSET", mixKeyAndFieldsAndValues(p, h)...)
 			if err != nil {
 				return err
 			}
 			if len(onlyUpdate) == 0 {
+				err = addRevision(hlp, c, p, h.getID())
+				if err != nil {
+					return err
+				}
+			}
+			err = addAudit(hlp, c, p, h.getID(), h, replace)
+			if err != nil {
+				return err
+			}
+			err = addChange(hlp, c, p, h.getID(), "set")
+			if err != nil {
+				return err
+			}
+			if len(onlyUpdate) == 0 {
+				err = c.Send("ZREM", trashKey(p), h.getID())
+				if err != nil {
+					return err
+				}
 				err = c.Send("ZADD", genKey(p, "sync"), "CH", time.Now().Unix(), h.getID())
 				if err != nil {
 					return err
 				}
+				err = st.addSyncRev(p, h.getID(), false)
+				if err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -258,12 +443,18 @@ func loadHashFieldAsString(c redis.Conn, p, s string, x int64) (string, error) {
 	return res[0], nil
 }
 
-func loadHashers(c redis.Conn, p string, v ruler, mustBeList ...bool) error {
+func (st redisStorage) loadHashers(hlp *ctxHelper, p string, v ruler, mustBeList ...bool) error {
+	c := st.c
 	if v.len() == 0 {
 		return nil
 	}
 
 	l := len(mustBeList) > 0
+	var f projection
+	if hlp != nil {
+		f = hlp.fields
+	}
+
 	var err error
 	for i := 0; i < v.len(); i++ {
 		if v.null(i) {
@@ -271,7 +462,11 @@ func loadHashers(c redis.Conn, p string, v ruler, mustBeList ...bool) error {
 		}
 
 		if h, ok := v.elem(i).(hasher); ok {
-			err = c.Send("HMGET", mixKeyAndFields(p, l, h)...)
+			a := mixKeyAndFields(p, l, h)
+			if f != nil {
+				a = pickFields(a[:1], a[1:], f.pick(a[1:]))
+			}
+			err = c.Send("HMGET", a...)
 			if err != nil {
 				return err
 			}
@@ -293,6 +488,9 @@ func loadHashers(c redis.Conn, p string, v ruler, mustBeList ...bool) error {
 		if err != nil {
 			return err
 		}
+		if h, ok := v.elem(i).(hasher); ok && f != nil {
+			r = spreadFields(r, len(h.getFields(l)), f.pick(h.getFields(l)))
+		}
 		if len(r) > 0 && r[0] == nil {
 			if n, ok := v.(niller); ok {
 				n.nill(i)
@@ -307,7 +505,11 @@ func loadHashers(c redis.Conn, p string, v ruler, mustBeList ...bool) error {
 	return nil
 }
 
-func freeHashers(c redis.Conn, p string, v ruler) error {
+// freeHashers deletes hashes and marks them in sync sets with the next values
+// of sync counter. Values must be loaded before by loadHashers, nil values
+// are treated as missing.
+func (st redisStorage) freeHashers(hlp *ctxHelper, p string, v ruler) error {
+	c := st.c
 	if v.len() == 0 {
 		return nil
 	}
@@ -319,32 +521,27 @@ func freeHashers(c redis.Conn, p string, v ruler) error {
 		}
 
 		if h, ok := v.elem(i).(hasher); ok {
+			err = addVersion(hlp, c, p, h.getID(), false)
+			if err != nil {
+				return err
+			}
+			hlp.followLinks(p, h.getID(), nil)
+			err = addTrash(hlp, c, p, h.getID())
+			if err != nil {
+				return err
+			}
 			err = c.Send("DEL", genKey(p, h.getID()))
 			if err != nil {
 				return err
 			}
-		}
-	}
-
-	err = c.Flush()
-	if err != nil {
-		return err
-	}
-
-	var r bool
-	for i := 0; i < v.len(); i++ {
-		if v.null(i) {
-			continue
-		}
-
-		r, err = redis.Bool(c.Receive())
-		if err != nil {
-			return err
-		}
-		if !r {
-			continue
-		}
-		if h, ok := v.elem(i).(hasher); ok {
+			err = addAudit(hlp, c, p, h.getID(), nil, false)
+			if err != nil {
+				return err
+			}
+			err = addChange(hlp, c, p, h.getID(), "del")
+			if err != nil {
+				return err
+			}
 			err = c.Send("ZREM", genKey(p, "sync"), h.getID())
 			if err != nil {
 				return err
@@ -353,6 +550,10 @@ func freeHashers(c redis.Conn, p string, v ruler) error {
 			if err != nil {
 				return err
 			}
+			err = st.addSyncRev(p, h.getID(), true)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -372,7 +573,8 @@ func mineIDsFromHashers(v ruler) []int64 {
 	return x
 }
 
-func findExistsIDs(c redis.Conn, p string, v ...int64) ([]int64, error) {
+func (st redisStorage) findExistsIDs(p string, v ...int64) ([]int64, error) {
+	c := st.c
 	if len(v) == 0 {
 		return nil, nil
 	}
@@ -405,7 +607,13 @@ func findExistsIDs(c redis.Conn, p string, v ...int64) ([]int64, error) {
 	return res, nil
 }
 
-func saveSearchers(c redis.Conn, p string, v ruler) error {
+func (st redisStorage) saveSearchers(p string, v ruler) error {
+	return st.saveSearchersTo(p, p, v)
+}
+
+// saveSearchersTo saves searchers of p under keys of dst.
+func (st redisStorage) saveSearchersTo(p, dst string, v ruler) error {
+	c := st.c
 	if v.len() == 0 {
 		return nil
 	}
@@ -428,51 +636,51 @@ func saveSearchers(c redis.Conn, p string, v ruler) error {
 			nameEN, abcdEN = s.getSrchEN(p)
 
 			for _, v := range nameRU {
-				err = c.Send("ZADD", genKey(p, "srch", "ru"), id, v+sx)
+				err = c.Send("ZADD", genKey(dst, "srch", "ru"), id, v+sx)
 				if err != nil {
 					return err
 				}
 			}
 			for _, v := range abcdRU {
-				err = c.Send("ZADD", genKey(p, "abcd", "ru"), v, id)
+				err = c.Send("ZADD", genKey(dst, "abcd", "ru"), v, id)
 				if err != nil {
 					return err
 				}
-				err = c.Send("ZINCRBY", genKey(p, "rune", "ru"), 1, v)
+				err = c.Send("ZINCRBY", genKey(dst, "rune", "ru"), 1, v)
 				if err != nil {
 					return err
 				}
 			}
 
 			for _, v := range nameUA {
-				err = c.Send("ZADD", genKey(p, "srch", "ua"), id, v+sx)
+				err = c.Send("ZADD", genKey(dst, "srch", "ua"), id, v+sx)
 				if err != nil {
 					return err
 				}
 			}
 			for _, v := range abcdUA {
-				err = c.Send("ZADD", genKey(p, "abcd", "ua"), v, id)
+				err = c.Send("ZADD", genKey(dst, "abcd", "ua"), v, id)
 				if err != nil {
 					return err
 				}
-				err = c.Send("ZINCRBY", genKey(p, "rune", "ua"), 1, v)
+				err = c.Send("ZINCRBY", genKey(dst, "rune", "ua"), 1, v)
 				if err != nil {
 					return err
 				}
 			}
 
 			for _, v := range nameEN {
-				err = c.Send("ZADD", genKey(p, "srch", "en"), id, v+sx)
+				err = c.Send("ZADD", genKey(dst, "srch", "en"), id, v+sx)
 				if err != nil {
 					return err
 				}
 			}
 			for _, v := range abcdEN {
-				err = c.Send("ZADD", genKey(p, "abcd", "en"), v, id)
+				err = c.Send("ZADD", genKey(dst, "abcd", "en"), v, id)
 				if err != nil {
 					return err
 				}
-				err = c.Send("ZINCRBY", genKey(p, "rune", "en"), 1, v)
+				err = c.Send("ZINCRBY", genKey(dst, "rune", "en"), 1, v)
 				if err != nil {
 					return err
 				}
@@ -483,7 +691,8 @@ func saveSearchers(c redis.Conn, p string, v ruler) error {
 	return c.Flush()
 }
 
-func freeSearchers(c redis.Conn, p string, v ruler) error {
+func (st redisStorage) freeSearchers(p string, v ruler) error {
+	c := st.c
 	if v.len() == 0 {
 		return nil
 	}
@@ -569,7 +778,8 @@ func newCollator(lang string) *collate.Collator {
 	}
 }
 
-func loadAbcd(c redis.Conn, p, lang string) ([]string, error) {
+func (st redisStorage) loadAbcd(p, lang string) ([]string, error) {
+	c := st.c
 	res, err := redis.Ints(c.Do("ZRANGEBYSCORE", genKey(p, "rune", lang), "-inf", "+inf"))
 	if err != nil {
 		return nil, err
@@ -586,7 +796,8 @@ func loadAbcd(c redis.Conn, p, lang string) ([]string, error) {
 	return out, nil
 }
 
-func loadAbcdLs(c redis.Conn, p, a, lang string) ([]int64, error) {
+func (st redisStorage) loadAbcdLs(p, a, lang string) ([]int64, error) {
+	c := st.c
 	r := []rune(normName(a))
 	if len(r) == 0 {
 		return nil, fmt.Errorf("someting wrong with abcd %s", p)
@@ -611,7 +822,8 @@ type findRes struct {
 }
 
 //
-func findIn(c redis.Conn, p, lang, text string, conj bool) ([]*findRes, error) {
+func (st redisStorage) findIn(p, lang, text string, conj bool) ([]*findRes, error) {
+	c := st.c
 	text = strings.ToLower(text)
 	var flds []string
 	if conj {
@@ -666,7 +878,8 @@ func findIn(c redis.Conn, p, lang, text string, conj bool) ([]*findRes, error) {
 	return res, nil
 }
 
-func minePath(c redis.Conn, p, fld string, x int64) ([]int64, error) {
+func (st redisStorage) minePath(p, fld string, x int64) ([]int64, error) {
+	c := st.c
 	res := []int64{x}
 	var err error
 	for done := false; !done; {
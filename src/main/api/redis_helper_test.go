@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// hookRediser calls hook before every command sent by its connections,
+// error of hook fails the command.
+type hookRediser struct {
+	r    rediser
+	hook func(cmd string, args []interface{}) error
+}
+
+func (r hookRediser) Get() redis.Conn {
+	return hookConn{r.r.Get(), r.hook}
+}
+
+type hookConn struct {
+	redis.Conn
+	hook func(string, []interface{}) error
+}
+
+func (c hookConn) Send(cmd string, args ...interface{}) error {
+	err := c.hook(cmd, args)
+	if err != nil {
+		return err
+	}
+	return c.Conn.Send(cmd, args...)
+}
+
+func (c hookConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" {
+		err := c.hook(cmd, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.Conn.Do(cmd, args...)
+}
+
+// dumpStore returns all keys of store with values in comparable form.
+func dumpStore(t *testing.T, r rediser) map[string]string {
+	c := r.Get()
+	defer c.Close()
+
+	keys, err := redis.Strings(c.Do("KEYS", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := make(map[string]string, len(keys))
+	for _, k := range keys {
+		b, err := redis.Bytes(c.Do("DUMP", k))
+		if err != nil {
+			t.Fatal(k, err)
+		}
+		var v map[string]interface{}
+		err = json.Unmarshal(b, &v)
+		if err != nil {
+			t.Fatal(k, err)
+		}
+		if m, ok := v["set"].([]interface{}); ok {
+			sort.Slice(m, func(i, j int) bool { return fmt.Sprint(m[i]) < fmt.Sprint(m[j]) })
+		}
+		b, _ = json.Marshal(v)
+		res[k] = string(b)
+	}
+
+	return res
+}
+
+// TestWriteFailure fails every command of set-spec-inf and del-spec-inf
+// transactions one by one and checks that nothing is written.
+func TestWriteFailure(t *testing.T) {
+	for _, path := range []string{"/set-spec-inf", "/del-spec-inf"} {
+		for n := 1; ; n++ {
+			var multi bool
+			var i int
+			a := newTestAPI(t)
+			r := hookRediser{a.s, func(cmd string, _ []interface{}) error {
+				switch cmd {
+				case "MULTI":
+					multi = true
+				case "EXEC", "DISCARD":
+					multi = false
+				}
+				if multi {
+					i++
+					if i == n {
+						return fmt.Errorf("failure of %s", cmd)
+					}
+				}
+				return nil
+			}}
+			b := newTestAPI(t, Redis(r))
+
+			a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"},{"id":2,"name_ru":"Ибупрофен"}]`)
+			a.ok(nil, "/set-spec-inf", `[{"id":100,"name_ru":"Панадол","name_ru_src":"Панадол","id_inn":[1],"is_info":1}]`)
+			want := dumpStore(t, a.s)
+
+			body := `[100]`
+			if path == "/set-spec-inf" {
+				body = `[{"id":100,"name_ru":"Нурофен","name_ru_src":"Нурофен","id_inn":[2],"is_info":1}]`
+			}
+			w := b.call(path, body)
+			if i < n {
+				if w.Code != http.StatusOK {
+					t.Fatalf("%s: %d %s", path, w.Code, w.Body.String())
+				}
+				break
+			}
+			if w.Code == http.StatusOK {
+				t.Fatalf("%s: failure %d is not reported", path, n)
+			}
+
+			got := dumpStore(t, a.s)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: failure %d leaves changes:\n%v\nwant:\n%v", path, n, got, want)
+			}
+			a.fsck()
+		}
+	}
+}
+
+// TestWatchLinks edits links of spec between the read and the write of
+// set-spec-inf, the write must fail and keep the edit.
+func TestWatchLinks(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"},{"id":2,"name_ru":"Ибупрофен"}]`)
+	a.ok(nil, "/set-spec-inf", `[{"id":100,"name_ru":"Панадол","name_ru_src":"Панадол","id_inn":[1],"is_info":1}]`)
+
+	edited := false
+	r := hookRediser{a.s, func(cmd string, _ []interface{}) error {
+		if cmd != "MULTI" || edited {
+			return nil
+		}
+		edited = true
+		c := a.s.Get()
+		defer c.Close()
+		_, err := c.Do("SADD", "spec:inf:100:inn", 2)
+		return err
+	}}
+	b := newTestAPI(t, Redis(r))
+
+	w := b.call("/set-spec-inf", `[{"id":100,"slug":"panadol"}]`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("set-spec-inf: %d %s", w.Code, w.Body.String())
+	}
+
+	var v jsonSpecs
+	a.ok(&v, "/get-spec-inf", `[100]`)
+	if len(v) != 1 || !reflect.DeepEqual(v[0].IDINN, []int64{1, 2}) || v[0].Slug != "" {
+		t.Fatalf("get-spec-inf: %+v", v[0])
+	}
+}
+
+// TestSyncSeq writes other entity of the prefix between the read and the
+// write of set-inn, both writes must succeed with distinct sync cursors.
+func TestSyncSeq(t *testing.T) {
+	for _, ns := range []string{"", "test"} {
+		a := newTestAPI(t, Namespace(ns))
+		written := false
+		r := hookRediser{a.s, func(cmd string, _ []interface{}) error {
+			if cmd != "MULTI" || written {
+				return nil
+			}
+			written = true
+			w := a.call("/set-inn", `[{"id":2,"name_ru":"Ибупрофен"}]`)
+			if w.Code != http.StatusOK {
+				t.Errorf("set-inn 2: %d %s", w.Code, w.Body.String())
+			}
+			return nil
+		}}
+		b := newTestAPI(t, Redis(r), Namespace(ns))
+
+		b.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"}]`)
+
+		var s jsonSync
+		a.ok(&s, "/get-inn-sync", `{"cursor":0}`)
+		if s.Cursor != 2 || !reflect.DeepEqual(s.ID, []int64{2, 1}) {
+			t.Fatalf("%q: sync: %+v", ns, s)
+		}
+	}
+}
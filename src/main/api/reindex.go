@@ -0,0 +1,187 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const watchAttempts = 3
+
+// searcherPrefixes are prefixes with srch, abcd and rune keys.
+var searcherPrefixes = []string{
+	prefixClassATC,
+	prefixINN,
+	prefixMaker,
+	prefixSpecACT,
+	prefixSpecINF,
+	prefixSpecDEC,
+}
+
+// Reindex rebuilds search and alphabet indexes of the given prefixes, all
+// searcher prefixes if empty. The number of indexed entities of each prefix
+// is passed to report.
+func Reindex(r rediser, ns, rel string, prefixes []string, report func(string, int) error) error {
+	if len(prefixes) == 0 {
+		prefixes = searcherPrefixes
+	}
+	for _, p := range prefixes {
+		if !isSearcherPrefix(p) {
+			return fmt.Errorf("reindex: unknown prefix %q", p)
+		}
+	}
+
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	for _, p := range prefixes {
+		n, err := reindexPrefix(c, p)
+		if err != nil {
+			return fmt.Errorf("reindex %s: %v", p, err)
+		}
+
+		err = report(p, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isSearcherPrefix(p string) bool {
+	for i := range searcherPrefixes {
+		if searcherPrefixes[i] == p {
+			return true
+		}
+	}
+	return false
+}
+
+// reindexPrefix builds new indexes under temporary keys and renames them over
+// the live ones, so search keeps working during the rebuild. The swap is
+// retried if entities of the prefix were changed meanwhile.
+func reindexPrefix(c redis.Conn, p string) (int, error) {
+	for i := 0; i < watchAttempts; i++ {
+		n, err := reindexPrefixOnce(c, p)
+		if err == errConflict {
+			continue
+		}
+		return n, err
+	}
+
+	return 0, errConflict
+}
+
+// reindexPrefixOnce builds indexes under a unique temporary prefix, so
+// concurrent reindexes do not mix their keys, the keys are deleted if the
+// swap fails.
+func reindexPrefixOnce(c redis.Conn, p string) (int, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return 0, err
+	}
+	tmp := genKey("reindex", p, hex.EncodeToString(b))
+
+	n, err := swapSearchers(c, p, tmp)
+	if err != nil {
+		_, _ = c.Do("DEL", searcherKeys(tmp)...)
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// searcherKeys returns srch, abcd and rune keys of all languages.
+func searcherKeys(p string) []interface{} {
+	var keys []interface{}
+	for _, l := range []string{"ru", "ua", "en"} {
+		for _, k := range []string{"srch", "abcd", "rune"} {
+			keys = append(keys, genKey(p, k, l))
+		}
+	}
+	return keys
+}
+
+func swapSearchers(c redis.Conn, p, tmp string) (int, error) {
+	st := newStorage(c)
+	keys, tmpKeys := searcherKeys(p), searcherKeys(tmp)
+
+	_, err := c.Do("WATCH", genKey(p, "sync"))
+	if err != nil {
+		return 0, err
+	}
+
+	ids, err := st.loadSyncIDs(p, 0)
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	k, _ := findSnapshotKind(p)
+	n := 0
+	for len(ids) > 0 {
+		m := snapshotBatch
+		if m > len(ids) {
+			m = len(ids)
+		}
+
+		v, err := k.load(c, p, ids[:m])
+		if err != nil {
+			return 0, err
+		}
+		ids = ids[m:]
+
+		for i := 0; i < v.len(); i++ {
+			if !v.null(i) {
+				n++
+			}
+		}
+
+		err = st.saveSearchersTo(p, tmp, v)
+		if err != nil {
+			return 0, err
+		}
+		_, err = c.Do("")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	exists := make([]bool, len(tmpKeys))
+	for i := range tmpKeys {
+		exists[i], err = redis.Bool(c.Do("EXISTS", tmpKeys[i]))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = multiExec(c, func() error {
+		for i := range keys {
+			var err error
+			if exists[i] {
+				err = c.Send("RENAME", tmpKeys[i], keys[i])
+			} else {
+				err = c.Send("DEL", keys[i])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// searcherDump returns search keys of prefix, the rest of the store is
+// dropped.
+func searcherDump(t *testing.T, a *testAPI, p string) map[string]string {
+	res := make(map[string]string)
+	for k, v := range dumpStore(t, a.s) {
+		for _, x := range searcherKeys(p) {
+			if k == x.(string) {
+				res[k] = v
+			}
+		}
+	}
+	return res
+}
+
+func checkNoReindexKeys(t *testing.T, a *testAPI) {
+	t.Helper()
+	for k := range dumpStore(t, a.s) {
+		if strings.HasPrefix(k, "reindex:") {
+			t.Errorf("%s is left", k)
+		}
+	}
+}
+
+func TestReindex(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол","name_en":"Paracetamol"},{"id":2,"name_ru":"Аспирин"}]`)
+	want := searcherDump(t, a, prefixINN)
+
+	c := a.s.Get()
+	defer c.Close()
+	_, err := c.Do("DEL", genKey(prefixINN, "abcd", "ru"), genKey(prefixINN, "rune", "en"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Do("ZADD", genKey(prefixINN, "srch", "ru"), 9, "орфан")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	err = Reindex(a.s, "", "", []string{prefixINN}, func(_ string, x int) error {
+		n = x
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("reindexed %d", n)
+	}
+
+	got := searcherDump(t, a, prefixINN)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for k := range want {
+		if got[k] != want[k] {
+			t.Errorf("%s = %s, want %s", k, got[k], want[k])
+		}
+	}
+	checkNoReindexKeys(t, a)
+}
+
+// TestReindexFailure fails the swap and checks that live keys are kept and
+// temporary ones are deleted.
+func TestReindexFailure(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"}]`)
+
+	c := a.s.Get()
+	defer c.Close()
+	_, err := c.Do("DEL", genKey(prefixINN, "abcd", "ru"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := searcherDump(t, a, prefixINN)
+
+	r := hookRediser{a.s, func(cmd string, _ []interface{}) error {
+		if cmd == "RENAME" {
+			return fmt.Errorf("rename failed")
+		}
+		return nil
+	}}
+	err = Reindex(r, "", "", []string{prefixINN}, func(string, int) error { return nil })
+	if err == nil {
+		t.Fatal("reindex did not fail")
+	}
+
+	got := searcherDump(t, a, prefixINN)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	checkNoReindexKeys(t, a)
+}
+
+// TestReindexConflict changes the prefix during the first attempt, the
+// second one must use its own temporary keys.
+func TestReindexConflict(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"}]`)
+
+	tmp := make(map[string]bool)
+	multi := 0
+	r := hookRediser{a.s, func(cmd string, args []interface{}) error {
+		if cmd == "ZADD" || cmd == "SADD" {
+			k := fmt.Sprint(args[0])
+			if strings.HasPrefix(k, "reindex:") {
+				tmp[strings.Join(strings.SplitN(k, ":", 4)[:3], ":")] = true
+			}
+		}
+		if cmd == "MULTI" {
+			multi++
+			if multi == 1 {
+				a.ok(nil, "/set-inn", `[{"id":2,"name_ru":"Аспирин"}]`)
+			}
+		}
+		return nil
+	}}
+
+	var n int
+	err := Reindex(r, "", "", []string{prefixINN}, func(_ string, x int) error {
+		n = x
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("reindexed %d after conflict", n)
+	}
+	if len(tmp) != 2 {
+		t.Errorf("temporary prefixes %v", tmp)
+	}
+	checkNoReindexKeys(t, a)
+}
@@ -0,0 +1,397 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	prefixRelease = "rel"
+
+	keyReleaseList = "release:list"
+	keyReleaseLive = "release:live"
+	keyReleasePrev = "release:prev"
+)
+
+// Release is catalog build in its own keyspace.
+type Release struct {
+	Name    string
+	Created time.Time
+	Live    bool
+	Prev    bool
+}
+
+// OpenRelease creates release, which is a copy of live catalog or empty. The
+// release is removed if the copy fails.
+func OpenRelease(r rediser, ns, name string, empty bool) error {
+	err := checkReleaseName(name)
+	if err != nil {
+		return err
+	}
+
+	c := newNSRediser(r, ns).Get()
+	defer c.Close()
+
+	ok, err := redis.Bool(c.Do("ZADD", keyReleaseList, "NX", "CH", time.Now().Unix(), name))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("release %s already exists", name)
+	}
+
+	if empty {
+		return nil
+	}
+
+	live, err := loadLiveRelease(c)
+	if err == nil {
+		err = copyKeyspace(c, releaseKeyspace(live), releaseKeyspace(name))
+	}
+	if err != nil {
+		_ = dropRelease(c, name)
+		return err
+	}
+
+	return nil
+}
+
+// dropRelease removes release from the list and deletes its keys.
+func dropRelease(c redis.Conn, name string) error {
+	_, err := c.Do("ZREM", keyReleaseList, name)
+	if err != nil {
+		return err
+	}
+
+	from := releaseKeyspace(name) + ":"
+	var next int
+	for done := false; !done; {
+		v, err := redis.Values(c.Do("SCAN", next, "MATCH", from+"*", "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+
+		next, _ = redis.Int(v[0], nil)
+		keys, err := redis.Values(v[1], nil)
+		if err != nil {
+			return err
+		}
+
+		done = next == 0
+
+		if len(keys) == 0 {
+			continue
+		}
+		_, err = c.Do("DEL", keys...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PublishRelease makes release live, the live one is kept for rollback. The
+// release must pass Fsck and its schema version must not be behind the live
+// one.
+func PublishRelease(r rediser, ns, name string) error {
+	r = newNSRediser(r, ns)
+	c := r.Get()
+	defer c.Close()
+
+	_, err := c.Do("WATCH", keyReleaseLive)
+	if err != nil {
+		return err
+	}
+
+	_, err = redis.Int64(c.Do("ZSCORE", keyReleaseList, name))
+	if err == redis.ErrNil {
+		return fmt.Errorf("release %s not found", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	live, err := loadLiveRelease(c)
+	if err != nil {
+		return err
+	}
+	if live == name {
+		return fmt.Errorf("release %s is already live", name)
+	}
+
+	err = checkRelease(r, live, name)
+	if err != nil {
+		_, _ = c.Do("UNWATCH")
+		return err
+	}
+
+	// the root keyspace is kept as the empty name
+	return multiExec(c, func() error {
+		err := c.Send("SET", keyReleasePrev, live)
+		if err != nil {
+			return err
+		}
+		return c.Send("SET", keyReleaseLive, name)
+	})
+}
+
+// checkRelease checks release before it replaces the live one.
+func checkRelease(r rediser, live, name string) error {
+	lc := newNSRediser(r, releaseKeyspace(live)).Get()
+	defer lc.Close()
+
+	lv, err := loadSchemaVersion(lc)
+	if err != nil {
+		return err
+	}
+
+	c := newNSRediser(r, releaseKeyspace(name)).Get()
+	defer c.Close()
+
+	v, err := loadSchemaVersion(c)
+	if err != nil {
+		return err
+	}
+	if v < lv {
+		return fmt.Errorf("release %s has schema version %d, live one has %d, migrate it first", name, v, lv)
+	}
+
+	n, err := runFsck(c, false, func(Problem) error { return nil })
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("release %s has %d problems, see fsck", name, n)
+	}
+
+	return nil
+}
+
+// RollbackRelease makes previous release live again.
+func RollbackRelease(r rediser, ns string) error {
+	c := newNSRediser(r, ns).Get()
+	defer c.Close()
+
+	_, err := c.Do("WATCH", keyReleaseLive, keyReleasePrev)
+	if err != nil {
+		return err
+	}
+
+	v, err := redis.Values(c.Do("MGET", keyReleaseLive, keyReleasePrev))
+	if err != nil {
+		return err
+	}
+	if v[1] == nil {
+		_, _ = c.Do("UNWATCH")
+		return fmt.Errorf("no previous release")
+	}
+	live, _ := redis.String(v[0], nil)
+	prev, _ := redis.String(v[1], nil)
+
+	return multiExec(c, func() error {
+		err := c.Send("SET", keyReleaseLive, prev)
+		if err != nil {
+			return err
+		}
+		return c.Send("SET", keyReleasePrev, live)
+	})
+}
+
+// ListReleases returns releases ordered by creation time.
+func ListReleases(r rediser, ns string) ([]Release, error) {
+	c := newNSRediser(r, ns).Get()
+	defer c.Close()
+
+	v, err := redis.Strings(c.Do("MGET", keyReleaseLive, keyReleasePrev))
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := redis.Int64Map(c.Do("ZRANGE", keyReleaseList, 0, -1, "WITHSCORES"))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Release, 0, len(m))
+	for k, t := range m {
+		res = append(res, Release{
+			Name:    k,
+			Created: time.Unix(t, 0),
+			Live:    k == v[0],
+			Prev:    k == v[1],
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Created.Before(res[j].Created)
+	})
+
+	return res, nil
+}
+
+func checkReleaseName(name string) error {
+	if name == "" || strings.ContainsAny(name, ":*?[] ") {
+		return fmt.Errorf("invalid release name: %q", name)
+	}
+	return nil
+}
+
+func loadLiveRelease(c redis.Conn) (string, error) {
+	s, err := redis.String(c.Do("GET", keyReleaseLive))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return s, err
+}
+
+// releaseKeyspace returns key prefix of release, the catalog without release
+// lives in the root of namespace.
+func releaseKeyspace(name string) string {
+	if name == "" {
+		return ""
+	}
+	return genKey(prefixRelease, name)
+}
+
+// releaseTTL is how long the name of the live release is cached by api, so
+// publish and rollback reach servers within it.
+const releaseTTL = time.Second
+
+// liveCache keeps the name of the live release.
+type liveCache struct {
+	mu   sync.Mutex
+	name string
+	at   time.Time
+}
+
+// load returns the name of the live release, it is loaded from r once in
+// releaseTTL.
+func (l *liveCache) load(r rediser) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Since(l.at) >= releaseTTL {
+		c := r.Get()
+		live, err := loadLiveRelease(c)
+		c.Close()
+		if err != nil {
+			return "", err
+		}
+		l.name, l.at = live, time.Now()
+	}
+
+	return l.name, nil
+}
+
+// withRelease returns rediser for the keyspace of the given release or of the
+// live release, if the name is empty.
+func withRelease(r rediser, name string) (rediser, error) {
+	c := r.Get()
+	defer c.Close()
+
+	var err error
+	if name == "" {
+		name, err = loadLiveRelease(c)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err = redis.Int64(c.Do("ZSCORE", keyReleaseList, name))
+		if err == redis.ErrNil {
+			return nil, fmt.Errorf("release %s not found", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newNSRediser(r, releaseKeyspace(name)), nil
+}
+
+// copyKeyspace copies keys from one keyspace to another by DUMP and RESTORE,
+// keys of releases and hooks are skipped when the root keyspace is copied.
+// Audit log and change feed are not copied, schema version is, so the copy
+// is migrated from the version of its data.
+func copyKeyspace(c redis.Conn, from, to string) error {
+	if from != "" {
+		from = from + ":"
+	}
+
+	var next int
+	var keys []string
+	for done := false; !done; {
+		v, err := redis.Values(c.Do("SCAN", next, "MATCH", from+"*", "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+
+		next, _ = redis.Int(v[0], nil)
+		keys, err = redis.Strings(v[1], nil)
+		if err != nil {
+			return err
+		}
+
+		done = next == 0
+
+		keys = copyKeys(keys, from)
+		if len(keys) == 0 {
+			continue
+		}
+
+		for i := range keys {
+			err = c.Send("DUMP", keys[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		dump, err := redis.Values(c.Do(""))
+		if err != nil {
+			return err
+		}
+
+		for i := range keys {
+			if dump[i] == nil {
+				continue // key was deleted meanwhile
+			}
+			err = c.Send("RESTORE", genKey(to, strings.TrimPrefix(keys[i], from)), 0, dump[i], "REPLACE")
+			if err != nil {
+				return err
+			}
+		}
+
+		res, err := c.Do("")
+		if err != nil {
+			return err
+		}
+		for _, x := range res.([]interface{}) {
+			if e, ok := x.(redis.Error); ok {
+				return e
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyKeys drops keys, which are not copied to release: its audit log and
+// change feed start anew, migration lock is of the source.
+func copyKeys(keys []string, from string) []string {
+	res := keys[:0]
+	for i := range keys {
+		k := strings.TrimPrefix(keys[i], from)
+		if k == keyAudit || strings.HasPrefix(k, keyAudit+":") || k == keyChanges || k == keySchemaLock {
+			continue
+		}
+		if from == "" && (strings.HasPrefix(k, prefixRelease+":") || strings.HasPrefix(k, "release:") || strings.HasPrefix(k, prefixHook+":")) {
+			continue
+		}
+		res = append(res, keys[i])
+	}
+	return res
+}
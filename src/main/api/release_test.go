@@ -0,0 +1,165 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReleaseRollback(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол","slug":"paracetamol"}]`)
+
+	err := OpenRelease(a.s, "", "r1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PublishRelease(a.s, "", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.ok(nil, "/set-inn", `[{"id":1,"slug":"paracetamol-r1"}]`, "Release", "r1")
+
+	err = RollbackRelease(a.s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := a.s.Get()
+	defer c.Close()
+	live, err := loadLiveRelease(c)
+	if err != nil || live != "" {
+		t.Fatalf("live after rollback: %q %v", live, err)
+	}
+
+	var v jsonINNs
+	a.ok(&v, "/get-inn", `[1]`, "Release", "")
+	if len(v) != 1 || v[0].Slug != "paracetamol" {
+		t.Fatalf("get-inn after rollback: %+v", v)
+	}
+}
+
+func TestReleaseCopy(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"}]`)
+	c := a.s.Get()
+	defer c.Close()
+	_, err := c.Do("SET", keySchemaVersion, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = OpenRelease(a.s, "", "r1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns := releaseKeyspace("r1") + ":"
+	n := 0
+	for k := range dumpStore(t, a.s) {
+		if !strings.HasPrefix(k, ns) {
+			continue
+		}
+		n++
+		k = strings.TrimPrefix(k, ns)
+		if k == keyAudit || strings.HasPrefix(k, keyAudit+":") || k == keyChanges {
+			t.Errorf("%s is copied to release", k)
+		}
+	}
+	if n == 0 {
+		t.Fatal("nothing is copied to release")
+	}
+
+	rc := newNSRediser(a.s, releaseKeyspace("r1")).Get()
+	defer rc.Close()
+	v, err := loadSchemaVersion(rc)
+	if err != nil || v != 3 {
+		t.Fatalf("schema version of release: %d %v", v, err)
+	}
+}
+
+// TestReleaseCopyFailure fails RESTORE of the copy and checks that release is
+// removed with keys copied so far.
+func TestReleaseCopyFailure(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"},{"id":2,"name_ru":"Аспирин"}]`)
+
+	n := 0
+	r := hookRediser{a.s, func(cmd string, _ []interface{}) error {
+		if cmd == "RESTORE" {
+			n++
+			if n > 1 {
+				return fmt.Errorf("restore failed")
+			}
+		}
+		return nil
+	}}
+
+	err := OpenRelease(r, "", "r1", false)
+	if err == nil {
+		t.Fatal("copy did not fail")
+	}
+
+	v, err := ListReleases(a.s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 0 {
+		t.Fatalf("releases after failed copy: %+v", v)
+	}
+	for k := range dumpStore(t, a.s) {
+		if strings.HasPrefix(k, releaseKeyspace("r1")+":") {
+			t.Errorf("%s is left after failed copy", k)
+		}
+	}
+}
+
+func TestReleasePublishCheck(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"}]`)
+	c := a.s.Get()
+	defer c.Close()
+
+	err := OpenRelease(a.s, "", "r1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// link to missing spec is found by fsck
+	rc := newNSRediser(a.s, releaseKeyspace("r1")).Get()
+	defer rc.Close()
+	_, err = rc.Do("SADD", genKey(prefixINN, 1, prefixSpecINF), 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PublishRelease(a.s, "", "r1")
+	if err == nil || !strings.Contains(err.Error(), "problems") {
+		t.Fatalf("publish of broken release: %v", err)
+	}
+	_, err = rc.Do("SREM", genKey(prefixINN, 1, prefixSpecINF), 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// live catalog is migrated after release is opened
+	_, err = c.Do("SET", keySchemaVersion, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PublishRelease(a.s, "", "r1")
+	if err == nil || !strings.Contains(err.Error(), "schema version") {
+		t.Fatalf("publish of old release: %v", err)
+	}
+	_, err = rc.Do("SET", keySchemaVersion, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := loadLiveRelease(c)
+	if err != nil || live != "" {
+		t.Fatalf("live after failed publish: %q %v", live, err)
+	}
+
+	err = PublishRelease(a.s, "", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"internal/ctxutil"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Revision of entity is field rev of its hash, it grows by one on every
+// write except updates of derived fields.
+const fieldRev = "rev"
+
+var errMatchMany = fmt.Errorf("If-Match needs a single item, use rev of items instead")
+
+// reviser is entity with revision, non zero revision of input is the
+// expected one.
+type reviser interface {
+	getRev() int64
+}
+
+// revisionError lists entities whose revisions differ from the expected ones.
+type revisionError struct {
+	p   string
+	ids []int64
+	rev []int64
+}
+
+func (e *revisionError) Error() string {
+	s := make([]string, len(e.ids))
+	for i := range e.ids {
+		s[i] = fmt.Sprintf("%s:%d at %d", e.p, e.ids[i], e.rev[i])
+	}
+	return "revision mismatch: " + strings.Join(s, ", ")
+}
+
+// parseMatch returns revision of If-Match header, zero if the header is
+// empty.
+func parseMatch(s string) (int64, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "W/")
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(strings.Trim(s, `"`), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid If-Match %q", s)
+	}
+	return n, nil
+}
+
+// etag formats revision as entity tag.
+func etag(rev int64) string {
+	return strconv.Quote(strconv.FormatInt(rev, 10))
+}
+
+// setETag answers revision of entity as ETag if the request asks for the
+// single one by id.
+func setETag(h *ctxHelper, res interface{}) {
+	v, ok := res.(ruler)
+	if !ok || h.w == nil || v.len() != 1 || v.null(0) {
+		return
+	}
+	// lists replace data of request with ids of their dependencies
+	ids, err := int64sFromJSON(ctxutil.BodyFrom(h.ctx))
+	if err != nil || len(ids) != 1 {
+		return
+	}
+	if x, ok := v.elem(0).(ider); !ok || x.getID() != ids[0] {
+		return
+	}
+	if r, ok := v.elem(0).(reviser); ok && r.getRev() != 0 {
+		h.w.Header().Set("ETag", etag(r.getRev()))
+	}
+}
+
+// checkRevisions compares expected revisions of entities with the watched
+// ones, so the write fails on EXEC if they change later.
+func (h *ctxHelper) checkRevisions(p string, v ruler) error {
+	if h.keepRev {
+		return nil
+	}
+
+	var items []int
+	for i := 0; i < v.len(); i++ {
+		if !v.null(i) {
+			items = append(items, i)
+		}
+	}
+	if h.match != 0 && len(items) != 1 {
+		return errMatchMany
+	}
+
+	e := &revisionError{p: p}
+	for _, i := range items {
+		r, ok := v.elem(i).(reviser)
+		if !ok {
+			continue
+		}
+		want := r.getRev()
+		if want == 0 {
+			want = h.match
+		}
+		if want == 0 {
+			continue
+		}
+
+		id := r.(ider).getID()
+		var cur int64
+		if s := h.old[genKey(p, id)]; s != nil {
+			cur, _ = strconv.ParseInt(s.hash[fieldRev], 10, 64)
+		}
+		if cur != want {
+			e.ids = append(e.ids, id)
+			e.rev = append(e.rev, cur)
+		}
+	}
+	if len(e.ids) > 0 {
+		return e
+	}
+
+	return nil
+}
+
+// addRevision bumps revision of entity saved by HMSET, replaced hashes get
+// the revision next to the watched one, deleted entities (e.g. restored from
+// trash) the one next to their last version. Imports keep revisions as is.
+func addRevision(h *ctxHelper, c redis.Conn, p string, id int64) error {
+	key := genKey(p, id)
+	if h != nil && h.keepRev {
+		return nil
+	}
+	if h != nil {
+		s := h.old[key]
+		if s != nil && s.hash == nil && s.rev != 0 {
+			return c.Send("HSET", key, fieldRev, s.rev+1)
+		}
+		if s != nil && h.replace {
+			n, _ := strconv.ParseInt(s.hash[fieldRev], 10, 64)
+			return c.Send("HSET", key, fieldRev, n+1)
+		}
+	}
+	return c.Send("HINCRBY", key, fieldRev, 1)
+}
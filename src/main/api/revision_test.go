@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestETag reads single entity with its revision as ETag, which is taken
+// back by If-Match of the write.
+func TestETag(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"},{"id":2,"name_ru":"Ибупрофен"}]`)
+	a.ok(nil, "/set-inn", `[{"id":1,"slug":"paracetamol"}]`)
+
+	w := a.call("/get-inn", `[1]`)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("get-inn: %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	w = a.call("/get-inn", `[1]`, headerMeta, `{"fields":["slug"]}`)
+	if w.Header().Get("ETag") != `"2"` || strings.Contains(w.Body.String(), "rev") {
+		t.Fatalf("get-inn with fields: %q %s", w.Header().Get("ETag"), w.Body.String())
+	}
+
+	w = a.call("/get-inn", `[1,2]`)
+	if w.Header().Get("ETag") != "" {
+		t.Fatalf("get-inn of two: %q", w.Header().Get("ETag"))
+	}
+
+	a.ok(nil, "/set-inn", `[{"id":1,"name_en":"Paracetamol"}]`)
+	w = a.call("/get-inn-list-az", `"p"`)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != "" || !strings.Contains(w.Body.String(), `"id":1`) {
+		t.Fatalf("get-inn-list-az: %d %q %s", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+
+	a.ok(nil, "/set-inn", `[{"id":1,"slug":"paracetamolum"}]`, "If-Match", `"3"`)
+	w = a.call("/set-inn", `[{"id":1,"slug":"paracetamol"}]`, "If-Match", `"3"`)
+	if w.Code != http.StatusConflict || w.Header().Get("ETag") != `"4"` {
+		t.Fatalf("set-inn: %d %q", w.Code, w.Header().Get("ETag"))
+	}
+}
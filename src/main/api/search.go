@@ -70,8 +70,9 @@ func listSugg(h *ctxHelper) (interface{}, error) {
 
 			c := h.getConn()
 			defer h.delConn(c)
+			st := newStorage(c)
 
-			r, err := findIn(c, p, h.lang, s, true)
+			r, err := st.findIn(p, h.lang, s, true)
 			if err != nil {
 				errc <- fmt.Errorf("%s %s: %v", p, h.lang, err)
 				return
@@ -80,7 +81,7 @@ func listSugg(h *ctxHelper) (interface{}, error) {
 			// workaround for en layout
 			if len(r) == 0 {
 				s = convLayout(s, "en", h.lang)
-				r, err = findIn(c, p, h.lang, s, true)
+				r, err = st.findIn(p, h.lang, s, true)
 				if err != nil {
 					errc <- fmt.Errorf("%s %s: %v", p, h.lang, err)
 					return
@@ -187,7 +188,7 @@ func findSugg(h *ctxHelper) (interface{}, error) {
 			c := h.getConn()
 			defer h.delConn(c)
 
-			r, err := findIn(c, p, h.lang, s, false)
+			r, err := newStorage(c).findIn(p, h.lang, s, false)
 			if err != nil {
 				errc <- fmt.Errorf("%s %s: %v", p, h.lang, err)
 				return
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"internal/logger"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	snapshotBatch = 500
+
+	// kindCatalog is kind of change events of the whole catalog
+	kindCatalog = "catalog"
+)
+
+// snapshotLine is one line of catalog snapshot in JSON Lines format.
+type snapshotLine struct {
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+type snapshotKind struct {
+	p    string
+	load func(redis.Conn, string, []int64) (ruler, error)
+	save func(*ctxHelper, string) (interface{}, error)
+}
+
+// snapshotKinds are ordered so that linked entities go before specs.
+var snapshotKinds = []snapshotKind{
+	{prefixClassATC, loadClassesForSnapshot, setClassX},
+	{prefixClassNFC, loadClassesForSnapshot, setClassX},
+	{prefixClassFSC, loadClassesForSnapshot, setClassX},
+	{prefixClassBFC, loadClassesForSnapshot, setClassX},
+	{prefixClassCFC, loadClassesForSnapshot, setClassX},
+	{prefixClassMPC, loadClassesForSnapshot, setClassX},
+	{prefixClassCSC, loadClassesForSnapshot, setClassX},
+	{prefixClassICD, loadClassesForSnapshot, setClassX},
+	{prefixINN, loadINNsForSnapshot, setINNX},
+	{prefixMaker, loadMakersForSnapshot, setMakerX},
+	{prefixDrug, loadDrugsForSnapshot, setDrugX},
+	{prefixSpecACT, loadSpecsForSnapshot, setSpecX},
+	{prefixSpecINF, loadSpecsForSnapshot, setSpecX},
+	{prefixSpecDEC, loadSpecsForSnapshot, setSpecX},
+}
+
+func findSnapshotKind(p string) (snapshotKind, bool) {
+	for i := range snapshotKinds {
+		if snapshotKinds[i].p == p {
+			return snapshotKinds[i], true
+		}
+	}
+	return snapshotKind{}, false
+}
+
+// Export writes all entities of catalog as JSON Lines, one entity per line.
+// Links of specs are exported with specs, other links are restored from them.
+func Export(r rediser, ns, rel string, w io.Writer) error {
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, k := range snapshotKinds {
+		ids, err := newStorage(c).loadSyncIDs(k.p, 0)
+		if err != nil {
+			return err
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		for len(ids) > 0 {
+			n := snapshotBatch
+			if n > len(ids) {
+				n = len(ids)
+			}
+
+			v, err := k.load(c, k.p, ids[:n])
+			if err != nil {
+				return err
+			}
+			ids = ids[n:]
+
+			for i := 0; i < v.len(); i++ {
+				if v.null(i) {
+					continue
+				}
+
+				b, err := json.Marshal(v.elem(i))
+				if err != nil {
+					return err
+				}
+
+				err = enc.Encode(snapshotLine{k.p, b})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Import reads JSON Lines written by Export and saves entities the same way
+// as set-* endpoints do, so hashes, links, sync and search keys are rebuilt.
+// Audit entries, versions and change events of entities are not written,
+// a single event of kind catalog with op import follows the whole import.
+func Import(r rediser, ns, rel string, rd io.Reader) error {
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return err
+	}
+
+	h := &ctxHelper{
+		ctx: context.Background(),
+		rdb: r,
+		log: logger.NewDefault(),
+
+		keepRev: true,
+		quiet:   true,
+	}
+
+	var kind snapshotKind
+	var total int
+	var data []json.RawMessage
+	flush := func() error {
+		if len(data) == 0 {
+			return nil
+		}
+
+		var err error
+		h.data, err = json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		data = data[:0]
+
+		_, err = kind.save(h, kind.p)
+		return err
+	}
+
+	s := bufio.NewScanner(rd)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; s.Scan(); n++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		var l snapshotLine
+		err = json.Unmarshal(s.Bytes(), &l)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+
+		if l.Kind != kind.p || len(data) == snapshotBatch {
+			err = flush()
+			if err != nil {
+				return fmt.Errorf("line %d: %v", n, err)
+			}
+
+			var ok bool
+			kind, ok = findSnapshotKind(l.Kind)
+			if !ok {
+				return fmt.Errorf("line %d: unknown kind %q", n, l.Kind)
+			}
+		}
+
+		data = append(data, l.Data)
+		total++
+	}
+
+	err = s.Err()
+	if err != nil {
+		return err
+	}
+
+	err = flush()
+	if err != nil || total == 0 {
+		return err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	err = addChange(nil, c, kindCatalog, 0, "import")
+	if err != nil {
+		return err
+	}
+	_, err = c.Do("")
+	return err
+}
+
+func loadClassesForSnapshot(c redis.Conn, p string, ids []int64) (ruler, error) {
+	v, err := makeClassesFromIDs(ids, nil)
+	if err != nil {
+		return nil, err
+	}
+	return v, newStorage(c).loadHashers(nil, p, v)
+}
+
+func loadINNsForSnapshot(c redis.Conn, p string, ids []int64) (ruler, error) {
+	v, err := makeINNsFromIDs(ids, nil)
+	if err != nil {
+		return nil, err
+	}
+	return v, newStorage(c).loadHashers(nil, p, v)
+}
+
+func loadMakersForSnapshot(c redis.Conn, p string, ids []int64) (ruler, error) {
+	v, err := makeMakersFromIDs(ids, nil)
+	if err != nil {
+		return nil, err
+	}
+	return v, newStorage(c).loadHashers(nil, p, v)
+}
+
+func loadDrugsForSnapshot(c redis.Conn, p string, ids []int64) (ruler, error) {
+	v, err := makeDrugsFromIDs(ids, nil)
+	if err != nil {
+		return nil, err
+	}
+	return v, newStorage(c).loadHashers(nil, p, v)
+}
+
+func loadSpecsForSnapshot(c redis.Conn, p string, ids []int64) (ruler, error) {
+	v, err := makeSpecsFromIDs(ids, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = newStorage(c).loadHashers(nil, p, v)
+	if err != nil {
+		return nil, err
+	}
+	// full is derived from is_info on write
+	for i := range v {
+		if v[i] != nil && v[i].Full {
+			v[i].IsInfo = 1
+		}
+	}
+	return v, loadSpecLinks(c, p, v)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestExportImport(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-class-atc", `[{"id":1,"name_ru":"Анальгетики"}]`)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол","slug":"paracetamol"}]`)
+	a.ok(nil, "/set-maker", `[{"id":1,"name_ru":"ГСК"}]`)
+	a.ok(nil, "/set-spec-inf", `[{"id":100,"name_ru":"Панадол","name_ru_src":"Панадол","id_inn":[1],"id_make":[1],"id_class_atc":[1],"is_info":1}]`)
+
+	var want bytes.Buffer
+	err := Export(a.s, "", "", &want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestAPI(t)
+	err = Import(b.s, "", "", bytes.NewReader(want.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.fsck()
+
+	var got bytes.Buffer
+	err = Export(b.s, "", "", &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Fatalf("export after import:\n%s\nwant:\n%s", got.String(), want.String())
+	}
+
+	c := b.s.Get()
+	defer c.Close()
+	for k := range dumpStore(t, b.s) {
+		if k == keyAudit || strings.HasPrefix(k, keyAudit+":") || strings.HasPrefix(k, prefixVersion+":") {
+			t.Errorf("import writes %s", k)
+		}
+	}
+	v, err := redis.Values(c.Do("XRANGE", keyChanges, "-", "+"))
+	if err != nil || len(v) != 1 {
+		t.Fatalf("change events of import: %d %v", len(v), err)
+	}
+	e, _ := redis.Values(v[0], nil)
+	f, _ := redis.StringMap(e[1], nil)
+	if f["kind"] != kindCatalog || f["op"] != "import" {
+		t.Fatalf("change event of import: %v", f)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"internal/ctxutil"
+	"internal/redismem"
 
 	"github.com/garyburd/redigo/redis"
 )
@@ -72,6 +73,7 @@ type jsonSpec struct {
 	CreatedAt int64    `json:"created_at,omitempty"`
 	UpdatedAt int64    `json:"updated_at,omitempty"`
 	Sale      float64  `json:"sale,omitempty"`
+	Rev       int64    `json:"rev,omitempty"`
 
 	Maker string `json:"maker,omitempty"` // for list only
 	UATag bool   `json:"uatag,omitempty"` // for list only
@@ -81,6 +83,10 @@ func (j *jsonSpec) getID() int64 {
 	return j.ID
 }
 
+func (j *jsonSpec) getRev() int64 {
+	return j.Rev
+}
+
 func (j *jsonSpec) getSrchRU(p string) ([]string, []rune) {
 	var s []string
 	var r []rune
@@ -196,6 +202,7 @@ func (j *jsonSpec) getFields(list bool) []interface{} {
 		"created_at",  // 20
 		"updated_at",  // 21
 		"sale",        // 22
+		"rev",         // 23
 	}
 }
 
@@ -225,6 +232,7 @@ func (j *jsonSpec) getValues() []interface{} {
 		j.CreatedAt,                    // 20
 		j.UpdatedAt,                    // 21
 		j.Sale,                         // 22
+		j.Rev,                          // 23
 	}
 }
 
@@ -303,6 +311,8 @@ func (j *jsonSpec) setValues(list bool, v ...interface{}) {
 			j.UpdatedAt, _ = redis.Int64(v[i], nil)
 		case 22:
 			j.Sale, _ = redis.Float64(v[i], nil)
+		case 23:
+			j.Rev, _ = redis.Int64(v[i], nil)
 		}
 	}
 }
@@ -325,6 +335,14 @@ func (j jsonSpecs) nill(i int) {
 	j[i] = nil
 }
 
+func (v jsonSpecs) pageKey(i int, sale bool) pageKey {
+	k := pageKey{Full: v[i].Full, Name: v[i].Name}
+	if sale {
+		k.Rank = v[i].Sale
+	}
+	return k
+}
+
 func (v jsonSpecs) sort(lang string, withSale ...bool) {
 	coll := newCollator(lang)
 	sort.Slice(v,
@@ -350,7 +368,10 @@ func (v jsonSpecs) sort(lang string, withSale ...bool) {
 					return false
 				}
 			}
-			return coll.CompareString(v[i].Name, v[j].Name) < 0
+			if n := coll.CompareString(v[i].Name, v[j].Name); n != 0 {
+				return n < 0
+			}
+			return v[i].ID < v[j].ID
 		},
 	)
 }
@@ -376,165 +397,151 @@ func makeSpecsFromIDs(v []int64, err error) (jsonSpecs, error) {
 }
 
 func loadSpecLinks(c redis.Conn, p string, v []*jsonSpec) error {
-	var err error
+	return loadSpecLinkSets(c, p, v, specLinks)
+}
+
+// loadSpecLinkSets loads the given link sets of specs, others are left
+// empty.
+func loadSpecLinkSets(c redis.Conn, p string, v []*jsonSpec, links []string) error {
+	if len(links) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(v))
 	for i := range v {
-		if v[i] == nil {
-			continue
+		if v[i] != nil {
+			ids[i] = v[i].ID
 		}
+	}
 
-		v[i].IDINN, err = loadLinkIDs(c, p, prefixINN, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDDrug, err = loadLinkIDs(c, p, prefixDrug, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDMake, err = loadLinkIDs(c, p, prefixMaker, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDSpecACT, err = loadLinkIDs(c, p, prefixSpecACT, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDSpecDEC, err = loadLinkIDs(c, p, prefixSpecDEC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDSpecINF, err = loadLinkIDs(c, p, prefixSpecINF, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDClassATC, err = loadLinkIDs(c, p, prefixClassATC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDClassNFC, err = loadLinkIDs(c, p, prefixClassNFC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDClassFSC, err = loadLinkIDs(c, p, prefixClassFSC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDClassBFC, err = loadLinkIDs(c, p, prefixClassBFC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDClassCFC, err = loadLinkIDs(c, p, prefixClassCFC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDClassMPC, err = loadLinkIDs(c, p, prefixClassMPC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDClassCSC, err = loadLinkIDs(c, p, prefixClassCSC, v[i].ID)
-		if err != nil {
-			return err
-		}
-		v[i].IDClassICD, err = loadLinkIDs(c, p, prefixClassICD, v[i].ID)
-		if err != nil {
-			return err
+	x, err := newStorage(c).loadLinkSets(p, ids, links...)
+	if err != nil {
+		return err
+	}
+
+	for i := range v {
+		if v[i] == nil {
+			continue
 		}
+		v[i].IDINN = x[i][prefixINN]
+		v[i].IDDrug = x[i][prefixDrug]
+		v[i].IDMake = x[i][prefixMaker]
+		v[i].IDSpecACT = x[i][prefixSpecACT]
+		v[i].IDSpecDEC = x[i][prefixSpecDEC]
+		v[i].IDSpecINF = x[i][prefixSpecINF]
+		v[i].IDClassATC = x[i][prefixClassATC]
+		v[i].IDClassNFC = x[i][prefixClassNFC]
+		v[i].IDClassFSC = x[i][prefixClassFSC]
+		v[i].IDClassBFC = x[i][prefixClassBFC]
+		v[i].IDClassCFC = x[i][prefixClassCFC]
+		v[i].IDClassMPC = x[i][prefixClassMPC]
+		v[i].IDClassCSC = x[i][prefixClassCSC]
+		v[i].IDClassICD = x[i][prefixClassICD]
 	}
 	return nil
 }
 
 func loadSpecMakerLinks(c redis.Conn, p string, v []*jsonSpec) error {
-	var err error
+	ids := make([]int64, len(v))
+	for i := range v {
+		if v[i] != nil {
+			ids[i] = v[i].ID
+		}
+	}
+
+	x, err := newStorage(c).loadLinkSets(p, ids, prefixMaker)
+	if err != nil {
+		return err
+	}
+
 	for i := range v {
 		if v[i] == nil {
 			continue
 		}
-		v[i].IDMake, err = loadLinkIDs(c, p, prefixMaker, v[i].ID)
-		if err != nil {
-			return err
-		}
+		v[i].IDMake = x[i][prefixMaker]
 	}
 	return nil
 }
 
-func saveSpecLinks(c redis.Conn, p string, v ...*jsonSpec) error {
+func saveSpecLinks(h *ctxHelper, c redis.Conn, p string, v ...*jsonSpec) error {
 	var err error
 	for i := range v {
 		if v[i] == nil {
 			continue
 		}
 
-		err = saveLinkIDs(c, p, prefixINN, true, v[i].ID, v[i].IDINN...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixINN, true, v[i].ID, v[i].IDINN...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixDrug, true, v[i].ID, v[i].IDDrug...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixDrug, true, v[i].ID, v[i].IDDrug...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixMaker, true, v[i].ID, v[i].IDMake...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixMaker, true, v[i].ID, v[i].IDMake...)
 		if err != nil {
 			return err
 		}
 		if v[i].IDMakeGP != 0 {
-			err = saveLinkIDs(c, p, prefixMaker, false, v[i].ID, v[i].IDMakeGP)
+			err = newStorage(c).saveLinkIDs(h, p, prefixMaker, false, v[i].ID, v[i].IDMakeGP)
 			if err != nil {
 				return err
 			}
 		}
 
-		err = saveLinkIDs(c, p, prefixSpecACT, true, v[i].ID, v[i].IDSpecACT...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixSpecACT, true, v[i].ID, v[i].IDSpecACT...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixSpecDEC, true, v[i].ID, v[i].IDSpecDEC...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixSpecDEC, true, v[i].ID, v[i].IDSpecDEC...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixSpecINF, true, v[i].ID, v[i].IDSpecINF...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixSpecINF, true, v[i].ID, v[i].IDSpecINF...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixClassATC, true, v[i].ID, v[i].IDClassATC...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixClassATC, true, v[i].ID, v[i].IDClassATC...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixClassNFC, true, v[i].ID, v[i].IDClassNFC...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixClassNFC, true, v[i].ID, v[i].IDClassNFC...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixClassFSC, true, v[i].ID, v[i].IDClassFSC...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixClassFSC, true, v[i].ID, v[i].IDClassFSC...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixClassBFC, true, v[i].ID, v[i].IDClassBFC...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixClassBFC, true, v[i].ID, v[i].IDClassBFC...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixClassCFC, true, v[i].ID, v[i].IDClassCFC...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixClassCFC, true, v[i].ID, v[i].IDClassCFC...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixClassMPC, true, v[i].ID, v[i].IDClassMPC...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixClassMPC, true, v[i].ID, v[i].IDClassMPC...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixClassCSC, true, v[i].ID, v[i].IDClassCSC...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixClassCSC, true, v[i].ID, v[i].IDClassCSC...)
 		if err != nil {
 			return err
 		}
 
-		err = saveLinkIDs(c, p, prefixClassICD, true, v[i].ID, v[i].IDClassICD...)
+		err = newStorage(c).saveLinkIDs(h, p, prefixClassICD, true, v[i].ID, v[i].IDClassICD...)
 		if err != nil {
 			return err
 		}
@@ -543,94 +550,81 @@ func saveSpecLinks(c redis.Conn, p string, v ...*jsonSpec) error {
 	return nil
 }
 
-func freeSpecLinks(c redis.Conn, p string, v ...*jsonSpec) error {
-	var val []int64
+// freeSpecLinks removes links of specs, which must be loaded by loadSpecLinks.
+func freeSpecLinks(h *ctxHelper, c redis.Conn, p string, v ...*jsonSpec) error {
 	var err error
 	for i := range v {
 		if v[i] == nil {
 			continue
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixINN, v[i].ID)
-		err = freeLinkIDs(c, p, prefixINN, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixINN, true, v[i].ID, v[i].IDINN...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixDrug, v[i].ID)
-		err = freeLinkIDs(c, p, prefixDrug, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixDrug, true, v[i].ID, v[i].IDDrug...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixMaker, v[i].ID)
-		err = freeLinkIDs(c, p, prefixMaker, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixMaker, true, v[i].ID, v[i].IDMake...)
 		if err != nil {
 			return err
 		}
 		if v[i].IDMakeGP != 0 {
-			err = freeLinkIDs(c, p, prefixMaker, false, v[i].ID, v[i].IDMakeGP)
+			err = newStorage(c).freeLinkIDs(h, p, prefixMaker, false, v[i].ID, v[i].IDMakeGP)
 			if err != nil {
 				return err
 			}
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixSpecDEC, v[i].ID)
-		err = freeLinkIDs(c, p, prefixSpecDEC, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixSpecDEC, true, v[i].ID, v[i].IDSpecDEC...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixSpecINF, v[i].ID)
-		err = freeLinkIDs(c, p, prefixSpecINF, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixSpecINF, true, v[i].ID, v[i].IDSpecINF...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixClassATC, v[i].ID)
-		err = freeLinkIDs(c, p, prefixClassATC, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixClassATC, true, v[i].ID, v[i].IDClassATC...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixClassNFC, v[i].ID)
-		err = freeLinkIDs(c, p, prefixClassNFC, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixClassNFC, true, v[i].ID, v[i].IDClassNFC...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixClassFSC, v[i].ID)
-		err = freeLinkIDs(c, p, prefixClassFSC, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixClassFSC, true, v[i].ID, v[i].IDClassFSC...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixClassBFC, v[i].ID)
-		err = freeLinkIDs(c, p, prefixClassBFC, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixClassBFC, true, v[i].ID, v[i].IDClassBFC...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixClassCFC, v[i].ID)
-		err = freeLinkIDs(c, p, prefixClassCFC, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixClassCFC, true, v[i].ID, v[i].IDClassCFC...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixClassMPC, v[i].ID)
-		err = freeLinkIDs(c, p, prefixClassMPC, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixClassMPC, true, v[i].ID, v[i].IDClassMPC...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixClassCSC, v[i].ID)
-		err = freeLinkIDs(c, p, prefixClassCSC, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixClassCSC, true, v[i].ID, v[i].IDClassCSC...)
 		if err != nil {
 			return err
 		}
 
-		val, _ = loadLinkIDs(c, p, prefixClassICD, v[i].ID)
-		err = freeLinkIDs(c, p, prefixClassICD, true, v[i].ID, val...)
+		err = newStorage(c).freeLinkIDs(h, p, prefixClassICD, true, v[i].ID, v[i].IDClassICD...)
 		if err != nil {
 			return err
 		}
@@ -639,24 +633,15 @@ func freeSpecLinks(c redis.Conn, p string, v ...*jsonSpec) error {
 	return nil
 }
 
-func getSpecXSync(h *ctxHelper, p string) ([]int64, error) {
-	v, err := int64FromJSON(h.data)
-	if err != nil {
-		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
-		return nil, err
-	}
-
-	c := h.getConn()
-	defer h.delConn(c)
-
-	return loadSyncIDs(c, p, v)
+func getSpecXSync(h *ctxHelper, p string) (interface{}, error) {
+	return getSyncX(h, p)
 }
 
 func getSpecXAbcd(h *ctxHelper, p string) ([]string, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	v, err := loadAbcd(c, p, h.lang)
+	v, err := newStorage(c).loadAbcd(p, h.lang)
 	if err != nil {
 		return nil, err
 	}
@@ -674,7 +659,7 @@ func getSpecXAbcdLs(h *ctxHelper, p string) ([]int64, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	v, err := loadAbcdLs(c, p, s, h.lang)
+	v, err := newStorage(c).loadAbcdLs(p, s, h.lang)
 	if err != nil {
 		return nil, err
 	}
@@ -692,10 +677,11 @@ func getSpecXList(h *ctxHelper, p string) (jsonSpecs, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v, true)
+	x, err := loadList(h, c, p, v)
 	if err != nil {
 		return nil, err
 	}
+	v = x.(jsonSpecs)
 
 	if p != prefixSpecACT {
 		// mine maker
@@ -729,10 +715,6 @@ func getSpecXList(h *ctxHelper, p string) (jsonSpecs, error) {
 			}
 		}
 	}
-	normLang(h.lang, p, v)
-
-	v.sort(h.lang, h.hack != "")
-
 	return v, nil
 }
 
@@ -746,7 +728,7 @@ func getSpecXListAZ(h *ctxHelper, p string) (jsonSpecs, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	v, err := loadAbcdLs(c, p, s, h.lang)
+	v, err := newStorage(c).loadAbcdLs(p, s, h.lang)
 	if err != nil {
 		return nil, err
 	}
@@ -765,7 +747,7 @@ func getSpecXListBy(h *ctxHelper, p1, p2 string) (jsonSpecs, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	x, err := loadLinkIDs(c, p2, p1, v)
+	x, err := newStorage(c).loadLinkIDs(p2, p1, v)
 	if err != nil {
 		return nil, err
 	}
@@ -784,7 +766,7 @@ func getSpecXListByForClass(h *ctxHelper, p1, p2 string) (jsonSpecs, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	x, err := loadLinkIDsForClass(c, p2, p1, v)
+	x, err := loadDeepIDs(c, p2, v, p1)
 	if err != nil {
 		return nil, err
 	}
@@ -803,12 +785,13 @@ func getSpecX(h *ctxHelper, p string) (jsonSpecs, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v)
+	err = newStorage(c).loadHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	err = loadSpecLinks(c, p, v)
+	// links dropped by projection are not loaded
+	err = loadSpecLinkSets(c, p, v, h.fields.links(specLinks))
 	if err != nil {
 		return nil, err
 	}
@@ -818,122 +801,165 @@ func getSpecX(h *ctxHelper, p string) (jsonSpecs, error) {
 	return v, nil
 }
 
+// getSpecXWithDeps loads dependencies of all specs at once: entities of each
+// kind are requested by one list and shared by specs linked to them.
 func getSpecXWithDeps(h *ctxHelper, p string) (jsonSpecs, error) {
 	v, err := getSpecX(h, p)
 	if err != nil {
 		return nil, err
 	}
-	cleanup := func(v ...*jsonClass) {
+	// dependencies are answered in full
+	d := h.clone()
+	d.fields = nil
+
+	union := func(f func(*jsonSpec) []int64) []byte {
+		var x []int64
 		for i := range v {
-			v[i].IDSpecDEC = nil
-			v[i].IDSpecINF = nil
+			if v[i] != nil {
+				x = append(x, f(v[i])...)
+			}
 		}
+		return int64sToJSON(uniqInt64(x))
+	}
+
+	d.data = union(func(s *jsonSpec) []int64 { return s.IDINN })
+	inn, err := getINNXList(d, prefixINN)
+	if err != nil {
+		return nil, err
+	}
+	d.data = union(func(s *jsonSpec) []int64 { return s.IDDrug })
+	drug, err := getDrugXList(d, prefixDrug)
+	if err != nil {
+		return nil, err
 	}
+	d.data = union(func(s *jsonSpec) []int64 { return s.IDMake })
+	maker, err := getMakerXList(d, prefixMaker)
+	if err != nil {
+		return nil, err
+	}
+
 	for i := range v {
 		if v[i] == nil {
 			continue
 		}
 		if len(v[i].IDINN) > 0 {
-			h.data = int64sToJSON(v[i].IDINN)
-			v[i].INN, err = getINNXList(h, prefixINN)
-			if err != nil {
-				return nil, err
+			v[i].INN = make(jsonINNs, len(v[i].IDINN))
+			for k, j := range depOrder(v[i].IDINN, inn) {
+				v[i].INN[k] = inn[j]
 			}
 			v[i].IDINN = nil
 		}
 		if len(v[i].IDDrug) > 0 {
-			h.data = int64sToJSON(v[i].IDDrug)
-			v[i].Drug, err = getDrugXList(h, prefixDrug)
-			if err != nil {
-				return nil, err
+			v[i].Drug = make(jsonDrugs, len(v[i].IDDrug))
+			for k, j := range depOrder(v[i].IDDrug, drug) {
+				v[i].Drug[k] = drug[j]
 			}
 			v[i].IDDrug = nil
 		}
 		if len(v[i].IDMake) > 0 {
-			h.data = int64sToJSON(v[i].IDMake)
-			v[i].Make, err = getMakerXList(h, prefixMaker)
-			if err != nil {
-				return nil, err
+			v[i].Make = make(jsonMakers, len(v[i].IDMake))
+			for k, j := range depOrder(v[i].IDMake, maker) {
+				v[i].Make[k] = maker[j]
 			}
 			v[i].IDMake = nil
 		}
-		if len(v[i].IDClassATC) > 0 {
-			h.data = int64sToJSON(v[i].IDClassATC)
-			v[i].ClassATC, err = getClassXNext(h, prefixClassATC)
-			if err != nil {
-				return nil, err
-			}
-			v[i].IDClassATC = nil
-			cleanup(v[i].ClassATC...)
-		}
-		if len(v[i].IDClassNFC) > 0 {
-			h.data = int64sToJSON(v[i].IDClassNFC)
-			v[i].ClassNFC, err = getClassXNext(h, prefixClassNFC)
-			if err != nil {
-				return nil, err
-			}
-			v[i].IDClassNFC = nil
-			cleanup(v[i].ClassNFC...)
-		}
-		if len(v[i].IDClassFSC) > 0 {
-			h.data = int64sToJSON(v[i].IDClassFSC)
-			v[i].ClassFSC, err = getClassXNext(h, prefixClassFSC)
-			if err != nil {
-				return nil, err
-			}
-			v[i].IDClassFSC = nil
-			cleanup(v[i].ClassFSC...)
-		}
-		if len(v[i].IDClassBFC) > 0 {
-			h.data = int64sToJSON(v[i].IDClassBFC)
-			v[i].ClassBFC, err = getClassXNext(h, prefixClassBFC)
-			if err != nil {
-				return nil, err
-			}
-			v[i].IDClassBFC = nil
-			cleanup(v[i].ClassBFC...)
+	}
+
+	for _, kind := range classPrefixes {
+		d.data = union(func(s *jsonSpec) []int64 { return *s.classIDs(kind) })
+		x, err := getClassXNext(d, kind)
+		if err != nil {
+			return nil, err
 		}
-		if len(v[i].IDClassCFC) > 0 {
-			h.data = int64sToJSON(v[i].IDClassCFC)
-			v[i].ClassCFC, err = getClassXNext(h, prefixClassCFC)
-			if err != nil {
-				return nil, err
+		for i := range x {
+			if x[i] != nil {
+				x[i].IDSpecDEC = nil
+				x[i].IDSpecINF = nil
 			}
-			v[i].IDClassCFC = nil
-			cleanup(v[i].ClassCFC...)
 		}
-		if len(v[i].IDClassMPC) > 0 {
-			h.data = int64sToJSON(v[i].IDClassMPC)
-			v[i].ClassMPC, err = getClassXNext(h, prefixClassMPC)
-			if err != nil {
-				return nil, err
+
+		for i := range v {
+			if v[i] == nil {
+				continue
 			}
-			v[i].IDClassMPC = nil
-			cleanup(v[i].ClassMPC...)
-		}
-		if len(v[i].IDClassCSC) > 0 {
-			h.data = int64sToJSON(v[i].IDClassCSC)
-			v[i].ClassCSC, err = getClassXNext(h, prefixClassCSC)
-			if err != nil {
-				return nil, err
+			ids, res := v[i].classIDs(kind), v[i].classes(kind)
+			if len(*ids) == 0 {
+				continue
 			}
-			v[i].IDClassCSC = nil
-			cleanup(v[i].ClassCSC...)
-		}
-		if len(v[i].IDClassICD) > 0 {
-			h.data = int64sToJSON(v[i].IDClassICD)
-			v[i].ClassICD, err = getClassXNext(h, prefixClassICD)
-			if err != nil {
-				return nil, err
+			*res = make(jsonClasses, len(*ids))
+			for k, j := range depOrder(*ids, x) {
+				(*res)[k] = x[j]
 			}
-			v[i].IDClassICD = nil
-			cleanup(v[i].ClassICD...)
+			*ids = nil
 		}
 	}
 
 	return v, nil
 }
 
+// classIDs returns ids of classes of p linked to spec.
+func (v *jsonSpec) classIDs(p string) *[]int64 {
+	switch p {
+	case prefixClassATC:
+		return &v.IDClassATC
+	case prefixClassNFC:
+		return &v.IDClassNFC
+	case prefixClassFSC:
+		return &v.IDClassFSC
+	case prefixClassBFC:
+		return &v.IDClassBFC
+	case prefixClassCFC:
+		return &v.IDClassCFC
+	case prefixClassMPC:
+		return &v.IDClassMPC
+	case prefixClassCSC:
+		return &v.IDClassCSC
+	}
+	return &v.IDClassICD
+}
+
+// classes returns classes of p linked to spec.
+func (v *jsonSpec) classes(p string) *jsonClasses {
+	switch p {
+	case prefixClassATC:
+		return &v.ClassATC
+	case prefixClassNFC:
+		return &v.ClassNFC
+	case prefixClassFSC:
+		return &v.ClassFSC
+	case prefixClassBFC:
+		return &v.ClassBFC
+	case prefixClassCFC:
+		return &v.ClassCFC
+	case prefixClassMPC:
+		return &v.ClassMPC
+	case prefixClassCSC:
+		return &v.ClassCSC
+	}
+	return &v.ClassICD
+}
+
+// depOrder returns positions of entities with ids x in sorted list v keeping
+// the order of v, missing entities are left to nils at the end like the sort
+// does.
+func depOrder(x []int64, v ruler) []int {
+	want := make(map[int64]struct{}, len(x))
+	for _, id := range x {
+		want[id] = struct{}{}
+	}
+
+	res := make([]int, 0, len(x))
+	for i := 0; i < v.len(); i++ {
+		if v.null(i) {
+			continue
+		}
+		if _, ok := want[v.elem(i).(ider).getID()]; ok {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
 func setSpecX(h *ctxHelper, p string) (interface{}, error) {
 	v, err := makeSpecsFromJSON(h.data)
 	if err != nil {
@@ -944,39 +970,70 @@ func setSpecX(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	x, err := makeSpecsFromIDs(findExistsIDs(c, p, mineIDsFromHashers(v)...))
+	err = newStorage(c).watchHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(x) > 0 {
-		err = loadHashers(c, p, x)
+	if h.patch {
+		b, err := mergePatch(h, c, p, newSpecHasher)
 		if err != nil {
 			return nil, err
 		}
-		err = loadSpecLinks(c, p, x)
+		v, err = makeSpecsFromJSON(b)
 		if err != nil {
+			h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
 			return nil, err
 		}
-		err = freeSearchers(c, p, x)
+	}
+
+	x, err := makeSpecsFromIDs(newStorage(c).findExistsIDs(p, mineIDsFromHashers(v)...))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(x) > 0 {
+		err = newStorage(c).loadHashers(h, p, x)
 		if err != nil {
 			return nil, err
 		}
-		err = freeSpecLinks(c, p, x...)
+		err = loadSpecLinks(c, p, x)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err = saveHashers(c, p, v)
-	if err != nil {
-		return nil, err
-	}
-	err = saveSearchers(c, p, v)
+	d, err := specDeep(c, p, x, v)
 	if err != nil {
 		return nil, err
 	}
-	err = saveSpecLinks(c, p, v...)
+
+	err = multiExec(c, func() error {
+		if len(x) > 0 {
+			err := newStorage(c).freeSearchers(p, x)
+			if err != nil {
+				return err
+			}
+			err = freeSpecLinks(h, c, p, x...)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := newStorage(c).saveHashers(h, p, v)
+		if err != nil {
+			return err
+		}
+		err = newStorage(c).saveSearchers(p, v)
+		if err != nil {
+			return err
+		}
+		err = saveSpecLinks(h, c, p, v...)
+		if err != nil {
+			return err
+		}
+		return d.save(c)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -984,11 +1041,13 @@ func setSpecX(h *ctxHelper, p string) (interface{}, error) {
 	return statusOK, nil
 }
 
+// setSpecXSale sums values of linked drugs into sale of specs, only specs
+// with changed sale are watched and written.
 func setSpecXSale(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	v, err := makeSpecsFromIDs(loadSyncIDs(c, p, 0))
+	v, err := makeSpecsFromIDs(newStorage(c).loadSyncIDs(p, 0))
 	if err != nil {
 		return nil, err
 	}
@@ -998,8 +1057,8 @@ func setSpecXSale(h *ctxHelper, p string) (interface{}, error) {
 		if v[i] == nil {
 			continue
 		}
-		d, err = makeDrugsFromIDs(loadLinkIDs(c, p, prefixDrug, v[i].ID))
-		err = loadHashers(c, prefixDrug, d)
+		d, err = makeDrugsFromIDs(newStorage(c).loadLinkIDs(p, prefixDrug, v[i].ID))
+		err = newStorage(c).loadHashers(h, prefixDrug, d)
 		if err != nil {
 			return nil, err
 		}
@@ -1011,7 +1070,44 @@ func setSpecXSale(h *ctxHelper, p string) (interface{}, error) {
 		}
 	}
 
-	err = saveHashers(c, p, v, true)
+	// zero sale is not written by HMSET
+	x := v[:0]
+	for i := range v {
+		if v[i] != nil && v[i].Sale != 0 {
+			x = append(x, v[i])
+		}
+	}
+	if len(x) == 0 {
+		return statusOK, nil
+	}
+	for i := range x {
+		err = c.Send("HGET", genKey(p, x[i].ID), "sale")
+		if err != nil {
+			return nil, err
+		}
+	}
+	old, err := redis.Strings(c.Do(""))
+	if err != nil {
+		return nil, err
+	}
+	v = nil
+	for i := range x {
+		if old[i] != redismem.ArgString(x[i].Sale) {
+			v = append(v, x[i])
+		}
+	}
+	if len(v) == 0 {
+		return statusOK, nil
+	}
+
+	err = newStorage(c).watchHashers(h, p, v, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = multiExec(c, func() error {
+		return newStorage(c).saveHashers(h, p, v, true)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -1029,22 +1125,41 @@ func delSpecX(h *ctxHelper, p string) (interface{}, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	err = loadHashers(c, p, v)
+	err = newStorage(c).watchHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	err = freeHashers(c, p, v)
+	err = newStorage(c).loadHashers(h, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	err = freeSearchers(c, p, v)
+	err = loadSpecLinks(c, p, v)
 	if err != nil {
 		return nil, err
 	}
 
-	err = freeSpecLinks(c, p, v...)
+	d, err := specDeep(c, p, v, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = multiExec(c, func() error {
+		err := newStorage(c).freeHashers(h, p, v)
+		if err != nil {
+			return err
+		}
+		err = newStorage(c).freeSearchers(p, v)
+		if err != nil {
+			return err
+		}
+		err = freeSpecLinks(h, c, p, v...)
+		if err != nil {
+			return err
+		}
+		return d.save(c)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -1062,7 +1177,7 @@ func crazyPermutation(h *ctxHelper, p string, v jsonSpecs) (jsonSpecs, error) {
 	c := h.getConn()
 	defer h.delConn(c)
 
-	atc, err := loadLinkIDs(c, p, prefixClassATC, v[0].ID)
+	atc, err := newStorage(c).loadLinkIDs(p, prefixClassATC, v[0].ID)
 	if err != nil {
 		return v, err
 	}
@@ -1143,6 +1258,10 @@ func delSpecACT(h *ctxHelper) (interface{}, error) {
 	return delSpecX(h, prefixSpecACT)
 }
 
+func restoreSpecACT(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixSpecACT)
+}
+
 // INF
 
 func getSpecINFSync(h *ctxHelper) (interface{}, error) {
@@ -1269,6 +1388,10 @@ func delSpecINF(h *ctxHelper) (interface{}, error) {
 	return delSpecX(h, prefixSpecINF)
 }
 
+func restoreSpecINF(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixSpecINF)
+}
+
 // DEC
 
 func getSpecDECSync(h *ctxHelper) (interface{}, error) {
@@ -1394,3 +1517,7 @@ func setSpecDECSale(h *ctxHelper) (interface{}, error) {
 func delSpecDEC(h *ctxHelper) (interface{}, error) {
 	return delSpecX(h, prefixSpecDEC)
 }
+
+func restoreSpecDEC(h *ctxHelper) (interface{}, error) {
+	return restoreX(h, prefixSpecDEC)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"testing"
+
+	"internal/redismem"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// tripConn counts round trips to Redis: calls of Do and Flush.
+type tripConn struct {
+	redis.Conn
+	n *int
+}
+
+func (c tripConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	*c.n++
+	return c.Conn.Do(cmd, args...)
+}
+
+func (c tripConn) Flush() error {
+	*c.n++
+	return c.Conn.Flush()
+}
+
+// loadSpecLinksEach loads link sets of specs one by one as loadSpecLinks did
+// before the pipeline.
+func loadSpecLinksEach(c redis.Conn, p string, v []*jsonSpec) error {
+	for i := range v {
+		if v[i] == nil {
+			continue
+		}
+		x := make(map[string][]int64, len(specLinks))
+		for _, l := range specLinks {
+			ids, err := newStorage(c).loadLinkIDs(p, l, v[i].ID)
+			if err != nil {
+				return err
+			}
+			x[l] = ids
+		}
+		v[i].restore(x)
+	}
+	return nil
+}
+
+// BenchmarkLoadSpecLinks loads links of 200 specs with 3 links of each kind
+// and reports round trips of the old and the pipelined loadSpecLinks.
+func BenchmarkLoadSpecLinks(b *testing.B) {
+	const specs, links = 200, 3
+
+	s := redismem.New()
+	c := s.Get()
+	defer c.Close()
+
+	ids := make([]int64, specs)
+	for i := range ids {
+		ids[i] = int64(i + 1)
+		for _, l := range specLinks {
+			for j := 0; j < links; j++ {
+				err := c.Send("SADD", genKey(prefixSpecINF, ids[i], l), (i+j)%(links*10)+1)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+	_, err := c.Do("")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, f := range []struct {
+		name string
+		load func(redis.Conn, string, []*jsonSpec) error
+	}{
+		{"old", loadSpecLinksEach},
+		{"new", loadSpecLinks},
+	} {
+		b.Run(f.name, func(b *testing.B) {
+			var n int
+			t := tripConn{c, &n}
+			for i := 0; i < b.N; i++ {
+				v, _ := makeSpecsFromIDs(ids, nil)
+				err := f.load(t, prefixSpecINF, v)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(v[0].IDClassICD) != links {
+					b.Fatalf("links: %+v", v[0])
+				}
+			}
+			b.ReportMetric(float64(n)/float64(b.N), "trips/op")
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+// storage keeps the catalog: hashes of entities, link sets between them,
+// sync index of changes and search index of names. Writes are sent to the
+// transaction of the request, see multiExec, so they are applied together
+// with audit, versions and changes of the request or not at all.
+type storage interface {
+	// watchHashers watches entities of v, so concurrent writes of them
+	// abort the transaction.
+	watchHashers(h *ctxHelper, p string, v ruler, onlyUpdate ...bool) error
+	loadHashers(hlp *ctxHelper, p string, v ruler, mustBeList ...bool) error
+	saveHashers(hlp *ctxHelper, p string, v ruler, onlyUpdate ...bool) error
+	freeHashers(hlp *ctxHelper, p string, v ruler) error
+	findExistsIDs(p string, v ...int64) ([]int64, error)
+	minePath(p, fld string, x int64) ([]int64, error)
+
+	loadLinkIDs(p1, p2 string, x int64) ([]int64, error)
+	loadLinkSets(p string, ids []int64, links ...string) ([]map[string][]int64, error)
+	saveLinkIDs(h *ctxHelper, p1, p2 string, s bool, x int64, v ...int64) error
+	freeLinkIDs(h *ctxHelper, p1, p2 string, s bool, x int64, v ...int64) error
+
+	loadSyncIDs(p string, v int64) ([]int64, error)
+	loadSyncRevs(p string, v int64) (*jsonSync, error)
+	loadSyncDelta(p string, v int64, n int) ([]int64, []int64, int64, bool, error)
+	addSyncRev(p string, id int64, del bool) error
+
+	saveSearchers(p string, v ruler) error
+	saveSearchersTo(p, dst string, v ruler) error
+	freeSearchers(p string, v ruler) error
+	findIn(p, lang, text string, conj bool) ([]*findRes, error)
+	loadAbcd(p, lang string) ([]string, error)
+	loadAbcdLs(p, a, lang string) ([]int64, error)
+}
+
+// redisStorage keeps the catalog in Redis or in the in-memory store, which
+// understands the same commands.
+type redisStorage struct {
+	c redis.Conn
+}
+
+func newStorage(c redis.Conn) storage {
+	return redisStorage{c}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"internal/redismem"
+)
+
+// TestStorageSync runs syncRevScript on the in-memory store and reads the
+// changes back as sync and delta.
+func TestStorageSync(t *testing.T) {
+	c := redismem.New().Get()
+	defer c.Close()
+	st := newStorage(c)
+
+	err := multiExec(c, func() error {
+		for _, id := range []int64{3, 1, 2} {
+			err := st.addSyncRev(prefixINN, id, false)
+			if err != nil {
+				return err
+			}
+		}
+		return st.addSyncRev(prefixINN, 3, true)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := st.loadSyncRevs(prefixINN, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Cursor != 4 || !reflect.DeepEqual(v.ID, []int64{1, 2}) {
+		t.Fatalf("sync = %+v", v)
+	}
+
+	v, err = st.loadSyncRevs(prefixINN, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Cursor != -4 || !reflect.DeepEqual(v.ID, []int64{3}) {
+		t.Fatalf("deleted = %+v", v)
+	}
+
+	upd, del, next, more, err := st.loadSyncDelta(prefixINN, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(upd, []int64{1, 2}) || del != nil || next != 3 || !more {
+		t.Fatalf("delta = %v %v %d %v", upd, del, next, more)
+	}
+
+	upd, del, next, more, err = st.loadSyncDelta(prefixINN, next, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upd != nil || !reflect.DeepEqual(del, []int64{3}) || next != 4 || more {
+		t.Fatalf("delta = %v %v %d %v", upd, del, next, more)
+	}
+}
+
+func TestStorageLinks(t *testing.T) {
+	c := redismem.New().Get()
+	defer c.Close()
+	st := newStorage(c)
+
+	h := &ctxHelper{ctx: context.Background()}
+	err := multiExec(c, func() error {
+		return st.saveLinkIDs(h, prefixSpecINF, prefixINN, true, 10, 1, 2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := st.loadLinkIDs(prefixSpecINF, prefixINN, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("links = %v", ids)
+	}
+
+	x, err := st.loadLinkSets(prefixINN, []int64{1, 2, 3}, prefixSpecINF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string][]int64{
+		{prefixSpecINF: {10}},
+		{prefixSpecINF: {10}},
+		{prefixSpecINF: {}},
+	}
+	if !reflect.DeepEqual(x, want) {
+		t.Fatalf("link sets = %v, want %v", x, want)
+	}
+}
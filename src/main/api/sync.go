@@ -0,0 +1,352 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"internal/ctxutil"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// syncPrefixes are prefixes with sync sets.
+var syncPrefixes = fsckPrefixes
+
+// jsonSync is request and response of sync endpoints with cursor. Cursor is
+// the value of monotonic change counter of the prefix, positive for updated
+// and negative for deleted ids.
+type jsonSync struct {
+	Cursor int64   `json:"cursor"`
+	ID     []int64 `json:"id"`
+}
+
+// getSyncX answers with ids changed since the given time for a number and
+// with ids changed since the given cursor and the new cursor for an object.
+func getSyncX(h *ctxHelper, p string) (interface{}, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(h.data), []byte("{")) {
+		v, err := int64FromJSON(h.data)
+		if err != nil {
+			h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+			return nil, err
+		}
+
+		c := h.getConn()
+		defer h.delConn(c)
+
+		return newStorage(c).loadSyncIDs(p, v)
+	}
+
+	var v jsonSync
+	err := json.Unmarshal(h.data, &v)
+	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, err
+	}
+
+	c := h.getConn()
+	defer h.delConn(c)
+
+	return newStorage(c).loadSyncRevs(p, v.Cursor)
+}
+
+// loadSyncRevs returns ids changed after cursor v, deleted ones for negative
+// v, and the cursor for the next request.
+func (st redisStorage) loadSyncRevs(p string, v int64) (*jsonSync, error) {
+	c := st.c
+	min, max := "("+strconv.FormatInt(v, 10), "+inf"
+	if v < 0 {
+		min, max = "-inf", "("+strconv.FormatInt(v, 10)
+	}
+
+	err := c.Send("MULTI")
+	if err != nil {
+		return nil, err
+	}
+	err = c.Send("GET", genKey(p, "sync", "rev", "horizon"))
+	if err != nil {
+		return nil, err
+	}
+	err = c.Send("GET", genKey(p, "sync", "seq"))
+	if err != nil {
+		return nil, err
+	}
+	err = c.Send("ZRANGEBYSCORE", genKey(p, "sync", "rev"), min, max)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := redis.Int64(res[0], nil)
+	if err != nil && err != redis.ErrNil {
+		return nil, err
+	}
+	if v != 0 && abs64(v) < t {
+		return nil, errResync
+	}
+
+	seq, err := redis.Int64(res[1], nil)
+	if err != nil && err != redis.ErrNil {
+		return nil, err
+	}
+	if v < 0 {
+		seq = -seq
+	}
+
+	ids, err := redis.Int64s(res[2], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonSync{Cursor: seq, ID: ids}, nil
+}
+
+// syncRevScript takes the next value of sync counter KEYS[1] and marks id
+// ARGV[1] with it in revision sync set KEYS[2], negated if ARGV[2] is -1.
+// The value is taken at EXEC, so writes of different entities of the prefix
+// do not conflict on the counter.
+const syncRevScript = `local n = redis.call('INCR', KEYS[1])
+redis.call('ZADD', KEYS[2], n * tonumber(ARGV[2]), ARGV[1])
+return n`
+
+// addSyncRev marks entity in revision sync set with the next value of sync
+// counter, negative for deletion. EVAL is sent instead of EVALSHA: NOSCRIPT
+// of a queued command would fail at EXEC after the rest is applied.
+func (st redisStorage) addSyncRev(p string, id int64, del bool) error {
+	c := st.c
+	sign := 1
+	if del {
+		sign = -1
+	}
+	return c.Send("EVAL", syncRevScript, 2, genKey(p, "sync", "seq"), genKey(p, "sync", "rev"), id, sign)
+}
+
+func loadSyncSeq(c redis.Conn, p string) (int64, error) {
+	v, err := redis.Int64(c.Do("GET", genKey(p, "sync", "seq")))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return v, err
+}
+
+// CompactSync removes tombstones older than retention from sync sets and
+// moves retention horizon of each prefix. The number of removed tombstones
+// of each prefix is passed to report.
+func CompactSync(r rediser, ns, rel string, retention time.Duration, report func(string, int) error) error {
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	t := time.Now().Add(-retention).Unix()
+	for _, p := range syncPrefixes {
+		var n int
+		for i := 0; i < watchAttempts; i++ {
+			n, err = compactSync(c, p, t)
+			if err != errConflict {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		err = report(p, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compactSync removes tombstones deleted before t from both sync sets and
+// moves both horizons, so clients never miss removed tombstones.
+func compactSync(c redis.Conn, p string, t int64) (int, error) {
+	h, err := loadSyncHorizon(c, p)
+	if err != nil {
+		return 0, err
+	}
+	if t <= h {
+		return 0, nil
+	}
+
+	_, err = c.Do("WATCH", genKey(p, "sync"))
+	if err != nil {
+		return 0, err
+	}
+
+	// tombstones deleted before t have scores in (-t, 0)
+	ids, err := redis.Int64s(c.Do("ZRANGEBYSCORE", genKey(p, "sync"), -t+1, -1))
+	if err != nil {
+		return 0, err
+	}
+
+	revs, err := loadSyncRevScores(c, p, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	var rh int64
+	var del []interface{}
+	for i := range ids {
+		if revs[i] < 0 {
+			del = append(del, ids[i])
+			if -revs[i] > rh {
+				rh = -revs[i]
+			}
+		}
+	}
+
+	err = multiExec(c, func() error {
+		err := c.Send("SET", genKey(p, "sync", "horizon"), t)
+		if err != nil {
+			return err
+		}
+		if len(del) > 0 {
+			err = c.Send("ZREM", append([]interface{}{genKey(p, "sync", "rev")}, del...)...)
+			if err != nil {
+				return err
+			}
+			err = c.Send("SET", genKey(p, "sync", "rev", "horizon"), rh)
+			if err != nil {
+				return err
+			}
+		}
+		return c.Send("ZREMRANGEBYSCORE", genKey(p, "sync"), -t+1, -1)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+func loadSyncHorizon(c redis.Conn, p string) (int64, error) {
+	v, err := redis.Int64(c.Do("GET", genKey(p, "sync", "horizon")))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return v, err
+}
+
+// loadSyncRevScores returns scores of ids in revision sync set, zero for
+// missing ones.
+func loadSyncRevScores(c redis.Conn, p string, ids []int64) ([]int64, error) {
+	for i := range ids {
+		err := c.Send("ZSCORE", genKey(p, "sync", "rev"), ids[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := c.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]int64, len(ids))
+	for i := range ids {
+		res[i], err = redis.Int64(c.Receive())
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// buildSyncRevs adds ids of sync sets missing in revision sync sets, the
+// order of changes is kept.
+func buildSyncRevs(c redis.Conn) error {
+	for _, p := range syncPrefixes {
+		var err error
+		for i := 0; i < watchAttempts; i++ {
+			err = buildSyncRev(c, p)
+			if err != errConflict {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func buildSyncRev(c redis.Conn, p string) error {
+	_, err := c.Do("WATCH", genKey(p, "sync"))
+	if err != nil {
+		return err
+	}
+
+	v, err := redis.Int64s(c.Do("ZRANGE", genKey(p, "sync"), 0, -1, "WITHSCORES"))
+	if err != nil {
+		return err
+	}
+
+	var ids, ts []int64
+	for i := 0; i+1 < len(v); i += 2 {
+		ids = append(ids, v[i])
+		ts = append(ts, v[i+1])
+	}
+
+	revs, err := loadSyncRevScores(c, p, ids)
+	if err != nil {
+		return err
+	}
+
+	var x []int
+	for i := range ids {
+		if revs[i] == 0 {
+			x = append(x, i)
+		}
+	}
+	if len(x) == 0 {
+		_, err = c.Do("UNWATCH")
+		return err
+	}
+	sort.SliceStable(x, func(i, j int) bool { return abs64(ts[x[i]]) < abs64(ts[x[j]]) })
+
+	return multiExec(c, func() error {
+		for _, i := range x {
+			err := newStorage(c).addSyncRev(p, ids[i], ts[i] < 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dropSyncRevs deletes revision sync sets and counters.
+func dropSyncRevs(c redis.Conn) error {
+	keys := make([]interface{}, 0, len(syncPrefixes)*3)
+	for _, p := range syncPrefixes {
+		keys = append(keys,
+			genKey(p, "sync", "rev"),
+			genKey(p, "sync", "rev", "horizon"),
+			genKey(p, "sync", "seq"),
+		)
+	}
+
+	_, err := c.Do("DEL", keys...)
+	return err
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
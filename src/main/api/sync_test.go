@@ -0,0 +1,151 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// newCompactAPI writes inns 1, 2 and 3 and deletes 1 and 2 two hours ago,
+// their sync revisions are 4 and 5.
+func newCompactAPI(t *testing.T) *testAPI {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"},{"id":2,"name_ru":"Аспирин"},{"id":3,"name_ru":"Ибупрофен"}]`)
+	a.ok(nil, "/del-inn", `[1]`)
+	a.ok(nil, "/del-inn", `[2]`)
+
+	c := a.s.Get()
+	defer c.Close()
+	old := time.Now().Add(-2 * time.Hour).Unix()
+	_, err := c.Do("ZADD", genKey(prefixINN, "sync"), -old, 1, -old, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s jsonSync
+	a.ok(&s, "/get-inn-sync", `{"cursor":-3}`)
+	if s.Cursor != -5 || !reflect.DeepEqual(s.ID, []int64{2, 1}) {
+		t.Fatalf("deleted before compaction: %+v", s)
+	}
+
+	return a
+}
+
+func compactINN(t *testing.T, r rediser) int {
+	n := 0
+	err := CompactSync(r, "", "", time.Hour, func(p string, x int) error {
+		if p == prefixINN {
+			n = x
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCompactSyncHorizon(t *testing.T) {
+	a := newCompactAPI(t)
+
+	if n := compactINN(t, a.s); n != 2 {
+		t.Fatalf("compacted %d", n)
+	}
+
+	c := a.s.Get()
+	defer c.Close()
+	h, err := redis.Int64(c.Do("GET", genKey(prefixINN, "sync", "horizon")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cursors below the horizon must resync
+	for _, x := range []struct{ path, body string }{
+		{"/get-inn-sync", `{"cursor":-4}`},
+		{"/get-inn-sync", `{"cursor":4}`},
+		{"/get-inn-sync", strconv.FormatInt(h-1, 10)},
+		{"/get-sync", `{"cursor":{"inn":4}}`},
+	} {
+		w := a.call(x.path, x.body)
+		if w.Code != http.StatusGone {
+			t.Errorf("%s %s: %d %s", x.path, x.body, w.Code, w.Body.String())
+		}
+	}
+
+	// cursors at the horizon see no removed tombstones
+	var s jsonSync
+	a.ok(&s, "/get-inn-sync", `{"cursor":-5}`)
+	if s.Cursor != -5 || len(s.ID) != 0 {
+		t.Errorf("deleted at horizon: %+v", s)
+	}
+	a.ok(&s, "/get-inn-sync", `{"cursor":5}`)
+	if s.Cursor != 5 || len(s.ID) != 0 {
+		t.Errorf("updated at horizon: %+v", s)
+	}
+	var ids []int64
+	a.ok(&ids, "/get-inn-sync", strconv.FormatInt(h, 10))
+	if !reflect.DeepEqual(ids, []int64{3}) {
+		t.Errorf("changed since horizon: %v", ids)
+	}
+	var d jsonDelta
+	a.ok(&d, "/get-sync", `{"cursor":{"inn":5}}`)
+	if d.Cursor["inn"] != 5 || len(d.Del) != 0 || d.More {
+		t.Errorf("delta at horizon: %+v", d)
+	}
+
+	// the rest of the sync sets is kept
+	a.ok(&s, "/get-inn-sync", `{"cursor":0}`)
+	if !reflect.DeepEqual(s.ID, []int64{3}) {
+		t.Errorf("updated after compaction: %+v", s)
+	}
+}
+
+// TestCompactSyncConcurrent deletes inn between the read and the write of
+// compaction, the compaction is retried and keeps the new tombstone.
+func TestCompactSyncConcurrent(t *testing.T) {
+	a := newCompactAPI(t)
+
+	written := false
+	r := hookRediser{a.s, func(cmd string, _ []interface{}) error {
+		if cmd != "MULTI" || written {
+			return nil
+		}
+		written = true
+		w := a.call("/del-inn", `[3]`)
+		if w.Code != http.StatusOK {
+			t.Errorf("del-inn 3: %d %s", w.Code, w.Body.String())
+		}
+		return nil
+	}}
+
+	if n := compactINN(t, r); n != 2 {
+		t.Fatalf("compacted %d", n)
+	}
+	if !written {
+		t.Fatal("inn is not deleted during compaction")
+	}
+
+	var s jsonSync
+	a.ok(&s, "/get-inn-sync", `{"cursor":-5}`)
+	if s.Cursor != -6 || !reflect.DeepEqual(s.ID, []int64{3}) {
+		t.Errorf("deleted after compaction: %+v", s)
+	}
+	w := a.call("/get-inn-sync", `{"cursor":-4}`)
+	if w.Code != http.StatusGone {
+		t.Errorf("cursor below horizon: %d %s", w.Code, w.Body.String())
+	}
+
+	c := a.s.Get()
+	defer c.Close()
+	ids, err := redis.Int64s(c.Do("ZRANGE", genKey(prefixINN, "sync"), 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{3}) {
+		t.Errorf("sync set after compaction: %v", ids)
+	}
+}
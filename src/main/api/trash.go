@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"internal/ctxutil"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Deleted entities are kept in trash: ids are in sorted set trash:<prefix>
+// scored by time of deletion, states are the last versions. Link sets held
+// by other entities and class trees are left as is, so restore puts the
+// entity back in place.
+const prefixTrash = "trash"
+
+// trashLinks are link sets of entity, which are left by deletion.
+var trashLinks = []string{
+	"next",
+	prefixSpecACT,
+	prefixSpecINF,
+	prefixSpecDEC,
+}
+
+// jsonTrash is entity in trash.
+type jsonTrash struct {
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// jsonTrashQuery is request of get-trash.
+type jsonTrashQuery struct {
+	Kind string `json:"kind"`
+}
+
+func trashKey(p string) string {
+	return genKey(prefixTrash, p)
+}
+
+// addTrash moves entity deleted by request to trash, it is sent with the
+// deletion in one transaction. Only entities with versions can be restored,
+// so deletions without request are final.
+func addTrash(h *ctxHelper, c redis.Conn, p string, id int64) error {
+	if h == nil {
+		return nil
+	}
+
+	s := h.old[genKey(p, id)]
+	if s == nil || s.hash == nil {
+		return nil
+	}
+
+	return c.Send("ZADD", trashKey(p), time.Now().Unix(), id)
+}
+
+// getTrash answers with entities of kind in trash from the last deleted.
+func getTrash(h *ctxHelper) (interface{}, error) {
+	var v jsonTrashQuery
+	err := json.Unmarshal(h.data, &v)
+	if _, ok := versionKinds[v.Kind]; err == nil && !ok {
+		err = fmt.Errorf("unknown kind %q", v.Kind)
+	}
+	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, err
+	}
+
+	c := h.getConn()
+	defer h.delConn(c)
+
+	x, err := redis.Int64s(c.Do("ZREVRANGE", trashKey(v.Kind), 0, -1, "WITHSCORES"))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]jsonTrash, 0, len(x)/2)
+	for i := 0; i+1 < len(x); i += 2 {
+		res = append(res, jsonTrash{ID: x[i], Time: time.Unix(x[i+1], 0)})
+	}
+
+	return res, nil
+}
+
+// restoreX puts entities back from trash, the write goes through the usual
+// set, so search indexes, links and sync sets follow it.
+func restoreX(h *ctxHelper, p string) (interface{}, error) {
+	ids, err := int64sFromJSON(h.data)
+	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, err
+	}
+
+	k := versionKinds[p]
+	v := make([]hasher, 0, len(ids))
+
+	c := h.getConn()
+	for _, id := range ids {
+		var x *jsonVersion
+		x, err = loadTrash(c, p, id)
+		if err != nil {
+			break
+		}
+		v = append(v, makeVersionHasher(k, id, x))
+	}
+	h.delConn(c)
+	if err == errNoTrash {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	w := h.clone()
+	w.data, err = json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	w.replace = true
+	w.patch = false
+
+	res, err := k.set(w, p)
+	h.ctx = w.ctx
+	return res, err
+}
+
+var errNoTrash = fmt.Errorf("not found in trash")
+
+// loadTrashLinks watches link sets of entities held by specs and returns
+// their members by spec prefix.
+func loadTrashLinks(c redis.Conn, p string, ids []int64) ([]map[string][]int64, error) {
+	var links []string
+	for _, l := range trashLinks {
+		if isSpecPrefix(l) {
+			links = append(links, l)
+		}
+	}
+
+	keys := make([]interface{}, 0, len(ids)*len(links))
+	for _, id := range ids {
+		for _, l := range links {
+			keys = append(keys, genKey(p, id, l))
+		}
+	}
+	_, err := c.Do("WATCH", keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStorage(c).loadLinkSets(p, ids, links...)
+}
+
+// loadTrash returns the state of entity before deletion.
+func loadTrash(c redis.Conn, p string, id int64) (*jsonVersion, error) {
+	_, err := redis.Int64(c.Do("ZSCORE", trashKey(p), id))
+	if err == redis.ErrNil {
+		return nil, errNoTrash
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := redis.ByteSlices(c.Do("ZREVRANGE", versionKey(p, id), 0, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	x := &jsonVersion{}
+	if len(v) > 0 {
+		err = json.Unmarshal(v[0], x)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if x.Hash == nil {
+		return nil, errNoTrash
+	}
+
+	return x, nil
+}
+
+// PurgeTrash removes entities deleted before retention for good: their
+// versions, link sets left by deletion and links to them held by specs. The
+// number of purged entities of each prefix is passed to report.
+func PurgeTrash(r rediser, ns, rel string, retention time.Duration, report func(string, int) error) error {
+	r, err := withRelease(newNSRediser(r, ns), rel)
+	if err != nil {
+		return err
+	}
+
+	c := r.Get()
+	defer c.Close()
+
+	t := time.Now().Add(-retention).Unix()
+	for _, p := range fsckPrefixes {
+		var n int
+		for i := 0; i < watchAttempts; i++ {
+			n, err = purgeTrash(c, p, t)
+			if err != errConflict {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		err = report(p, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeTrash purges entities of p deleted before t, trash and link sets are
+// watched, so entities restored or linked meanwhile are kept consistent.
+func purgeTrash(c redis.Conn, p string, t int64) (int, error) {
+	key := trashKey(p)
+	_, err := c.Do("WATCH", key)
+	if err != nil {
+		return 0, err
+	}
+
+	ids, err := redis.Int64s(c.Do("ZRANGEBYSCORE", key, "-inf", "("+strconv.FormatInt(t, 10)))
+	if err != nil || len(ids) == 0 {
+		_, _ = c.Do("UNWATCH")
+		return 0, err
+	}
+
+	links, err := loadTrashLinks(c, p, ids)
+	if err != nil {
+		_, _ = c.Do("UNWATCH")
+		return 0, err
+	}
+
+	err = multiExec(c, func() error {
+		for i, id := range ids {
+			err := c.Send("ZREM", key, id)
+			if err != nil {
+				return err
+			}
+			err = c.Send("DEL", versionKey(p, id))
+			if err != nil {
+				return err
+			}
+			for _, l := range trashLinks {
+				err = c.Send("DEL", genKey(p, id, l))
+				if err != nil {
+					return err
+				}
+			}
+			for l, v := range links[i] {
+				for _, x := range v {
+					err = c.Send("SREM", genKey(l, x, p), id)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
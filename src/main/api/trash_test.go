@@ -0,0 +1,42 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestTrashPurge purges deleted inn, specs must lose their links to it.
+func TestTrashPurge(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"},{"id":2,"name_ru":"Ибупрофен"}]`)
+	a.ok(nil, "/set-spec-inf", `[{"id":100,"name_ru":"Панадол","id_inn":[1,2],"is_info":1}]`)
+	a.ok(nil, "/del-inn", `[1]`)
+
+	err := PurgeTrash(a.s, "", "", -time.Hour, func(string, int) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v jsonSpecs
+	a.ok(&v, "/get-spec-inf", `[100]`)
+	if len(v) != 1 || !reflect.DeepEqual(v[0].IDINN, []int64{2}) {
+		t.Fatalf("get-spec-inf: %+v", v[0])
+	}
+}
+
+// TestTrashRestoreRev restores deleted inn, its revision must go on from the
+// deleted one.
+func TestTrashRestoreRev(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"}]`)
+	a.ok(nil, "/set-inn", `[{"id":1,"slug":"paracetamol"}]`)
+	a.ok(nil, "/del-inn", `[1]`)
+	a.ok(nil, "/restore-inn", `[1]`)
+
+	var v jsonINNs
+	a.ok(&v, "/get-inn", `[1]`)
+	if len(v) != 1 || v[0].Rev != 3 || v[0].Slug != "paracetamol" {
+		t.Fatalf("get-inn: %+v", v[0])
+	}
+}
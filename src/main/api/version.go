@@ -0,0 +1,598 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"internal/ctxutil"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Versions of entity are JSON members of sorted set version:<prefix>:<id>
+// scored by version number, each one is the state replaced by a write.
+const (
+	prefixVersion = "version"
+
+	versionKeep = 50
+)
+
+type versionKind struct {
+	make func(int64) hasher
+	set  func(*ctxHelper, string) (interface{}, error)
+	del  func(*ctxHelper, string) (interface{}, error)
+}
+
+var versionKinds = map[string]versionKind{
+	prefixClassATC: {newClassHasher, setClassX, delClassX},
+	prefixClassNFC: {newClassHasher, setClassX, delClassX},
+	prefixClassFSC: {newClassHasher, setClassX, delClassX},
+	prefixClassBFC: {newClassHasher, setClassX, delClassX},
+	prefixClassCFC: {newClassHasher, setClassX, delClassX},
+	prefixClassMPC: {newClassHasher, setClassX, delClassX},
+	prefixClassCSC: {newClassHasher, setClassX, delClassX},
+	prefixClassICD: {newClassHasher, setClassX, delClassX},
+	prefixINN:      {newINNHasher, setINNX, delINNX},
+	prefixMaker:    {newMakerHasher, setMakerX, delMakerX},
+	prefixDrug:     {newDrugHasher, setDrugX, delDrugX},
+	prefixSpecACT:  {newSpecHasher, setSpecX, delSpecX},
+	prefixSpecINF:  {newSpecHasher, setSpecX, delSpecX},
+	prefixSpecDEC:  {newSpecHasher, setSpecX, delSpecX},
+}
+
+// specLinks are link sets owned by specs, other link sets of entities are
+// kept by specs and class trees.
+var specLinks = []string{
+	prefixINN,
+	prefixDrug,
+	prefixMaker,
+	prefixSpecACT,
+	prefixSpecDEC,
+	prefixSpecINF,
+	prefixClassATC,
+	prefixClassNFC,
+	prefixClassFSC,
+	prefixClassBFC,
+	prefixClassCFC,
+	prefixClassMPC,
+	prefixClassCSC,
+	prefixClassICD,
+}
+
+// restorer is entity with links, which is rebuilt from version.
+type restorer interface {
+	restore(map[string][]int64)
+	links() map[string][]int64
+}
+
+// jsonVersion is state of entity, nil hash means that entity was missing.
+type jsonVersion struct {
+	Ver  int64              `json:"ver"`
+	Time time.Time          `json:"time"`
+	User string             `json:"user,omitempty"`
+	Req  string             `json:"req,omitempty"`
+	Hash map[string]string  `json:"hash,omitempty"`
+	Link map[string][]int64 `json:"link,omitempty"`
+
+	rev int64 // revision of missing entity by its last version
+}
+
+// jsonVersionQuery is request of version endpoints, zero ver means the
+// current state.
+type jsonVersionQuery struct {
+	Kind string    `json:"kind"`
+	ID   int64     `json:"id"`
+	Ver  int64     `json:"ver"`
+	Time time.Time `json:"time"`
+	To   int64     `json:"to"`
+}
+
+// jsonVersionData is entity as of version, time is the time of write which
+// replaced it.
+type jsonVersionData struct {
+	Ver  int64       `json:"ver"`
+	Time *time.Time  `json:"time,omitempty"`
+	User string      `json:"user,omitempty"`
+	Data interface{} `json:"data"`
+}
+
+// jsonVersionDiff is difference of two states of entity.
+type jsonVersionDiff struct {
+	Diff map[string][2]*string   `json:"diff"`
+	Link map[string]jsonLinkDiff `json:"link"`
+}
+
+type jsonLinkDiff struct {
+	Add []int64 `json:"add,omitempty"`
+	Del []int64 `json:"del,omitempty"`
+}
+
+func newClassHasher(id int64) hasher {
+	return &jsonClass{ID: id}
+}
+
+func newINNHasher(id int64) hasher {
+	return &jsonINN{ID: id}
+}
+
+func newMakerHasher(id int64) hasher {
+	return &jsonMaker{ID: id}
+}
+
+func newDrugHasher(id int64) hasher {
+	return &jsonDrug{ID: id}
+}
+
+func newSpecHasher(id int64) hasher {
+	return &jsonSpec{ID: id}
+}
+
+// restore fills links and fields of input, which are derived on save.
+func (j *jsonSpec) restore(v map[string][]int64) {
+	j.IDINN = v[prefixINN]
+	j.IDDrug = v[prefixDrug]
+	j.IDMake = v[prefixMaker]
+	j.IDSpecACT = v[prefixSpecACT]
+	j.IDSpecDEC = v[prefixSpecDEC]
+	j.IDSpecINF = v[prefixSpecINF]
+	j.IDClassATC = v[prefixClassATC]
+	j.IDClassNFC = v[prefixClassNFC]
+	j.IDClassFSC = v[prefixClassFSC]
+	j.IDClassBFC = v[prefixClassBFC]
+	j.IDClassCFC = v[prefixClassCFC]
+	j.IDClassMPC = v[prefixClassMPC]
+	j.IDClassCSC = v[prefixClassCSC]
+	j.IDClassICD = v[prefixClassICD]
+	if j.Full {
+		j.IsInfo = 1
+	}
+}
+
+// links returns link sets of input as they are kept in versions.
+func (j *jsonSpec) links() map[string][]int64 {
+	var res map[string][]int64
+	for l, v := range map[string][]int64{
+		prefixINN:      j.IDINN,
+		prefixDrug:     j.IDDrug,
+		prefixMaker:    j.IDMake,
+		prefixSpecACT:  j.IDSpecACT,
+		prefixSpecDEC:  j.IDSpecDEC,
+		prefixSpecINF:  j.IDSpecINF,
+		prefixClassATC: j.IDClassATC,
+		prefixClassNFC: j.IDClassNFC,
+		prefixClassFSC: j.IDClassFSC,
+		prefixClassBFC: j.IDClassBFC,
+		prefixClassCFC: j.IDClassCFC,
+		prefixClassMPC: j.IDClassMPC,
+		prefixClassCSC: j.IDClassCSC,
+		prefixClassICD: j.IDClassICD,
+	} {
+		if len(v) == 0 {
+			continue
+		}
+		if res == nil {
+			res = make(map[string][]int64)
+		}
+		res[l] = v
+	}
+	return res
+}
+
+func versionKey(p string, id int64) string {
+	return genKey(prefixVersion, p, id)
+}
+
+// versionLinks returns link sets kept in versions of p.
+func versionLinks(p string) []string {
+	switch p {
+	case prefixSpecACT, prefixSpecINF, prefixSpecDEC:
+		return specLinks
+	}
+	return nil
+}
+
+// loadSnapshots returns current states of entities with numbers of their
+// last versions.
+func loadSnapshots(c redis.Conn, p string, ids []int64) ([]*jsonVersion, error) {
+	links := versionLinks(p)
+	for _, id := range ids {
+		err := c.Send("HGETALL", genKey(p, id))
+		if err != nil {
+			return nil, err
+		}
+		err = c.Send("ZREVRANGE", versionKey(p, id), 0, 0, "WITHSCORES")
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range links {
+			err = c.Send("SMEMBERS", genKey(p, id, l))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	res := make([]*jsonVersion, len(ids))
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	v, err := redis.Values(c.Do(""))
+	if err != nil {
+		return nil, err
+	}
+
+	n := 2 + len(links)
+	for i := range ids {
+		x := v[i*n : (i+1)*n]
+		m, err := redis.StringMap(x[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = &jsonVersion{}
+		if len(m) > 0 {
+			res[i].Hash = m
+		}
+		s, err := redis.Values(x[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(s) == 2 {
+			res[i].Ver, err = redis.Int64(s[1], nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(s) == 2 && res[i].Hash == nil {
+			var last jsonVersion
+			b, _ := redis.Bytes(s[0], nil)
+			err = json.Unmarshal(b, &last)
+			if err != nil {
+				return nil, err
+			}
+			res[i].rev, _ = strconv.ParseInt(last.Hash[fieldRev], 10, 64)
+		}
+		for j, l := range links {
+			ids, err := redis.Int64s(x[2+j], nil)
+			if err != nil {
+				return nil, err
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			if res[i].Link == nil {
+				res[i].Link = make(map[string][]int64)
+			}
+			sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+			res[i].Link[l] = ids
+		}
+	}
+
+	return res, nil
+}
+
+// addVersion keeps state of entity replaced by request as the next version,
+// it is sent with the change in one transaction. Updates of derived fields
+// are not versioned.
+func addVersion(h *ctxHelper, c redis.Conn, p string, id int64, onlyUpdate bool) error {
+	if h == nil || h.quiet || onlyUpdate {
+		return nil
+	}
+
+	s := h.old[genKey(p, id)]
+	if s == nil {
+		return nil
+	}
+
+	s.ver++
+	x := &jsonVersion{
+		Ver:  s.ver,
+		Time: time.Now(),
+		User: ctxutil.AuthFrom(h.ctx),
+		Req:  ctxutil.UUIDFrom(h.ctx),
+		Hash: s.hash,
+		Link: s.link,
+	}
+	b, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+
+	key := versionKey(p, id)
+	err = c.Send("ZADD", key, x.Ver, b)
+	if err != nil {
+		return err
+	}
+	return c.Send("ZREMRANGEBYRANK", key, 0, -versionKeep-1)
+}
+
+// followLinks keeps links written by request in state of entity, so the next
+// version of the entity in the same request gets them. Old links are freed
+// once per request, so links of all its writes add up; nil e means deletion.
+func (h *ctxHelper) followLinks(p string, id int64, e hasher) {
+	if h == nil {
+		return
+	}
+	s := h.old[genKey(p, id)]
+	if s == nil {
+		return
+	}
+
+	if !s.linked {
+		s.link = nil
+		s.linked = true
+	}
+	r, ok := e.(restorer)
+	if !ok {
+		return
+	}
+	for l, v := range r.links() {
+		if s.link == nil {
+			s.link = make(map[string][]int64)
+		}
+		x := uniqInt64(append(append([]int64(nil), s.link[l]...), v...))
+		sort.Slice(x, func(a, b int) bool { return x[a] < x[b] })
+		s.link[l] = x
+	}
+}
+
+func getVersionList(h *ctxHelper) (interface{}, error) {
+	v, _, err := makeVersionQuery(h)
+	if err != nil {
+		return nil, err
+	}
+
+	c := h.getConn()
+	defer h.delConn(c)
+
+	x, err := loadVersions(c, v.Kind, v.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// states are left out of the list
+	res := make([]*jsonVersion, len(x))
+	for i := range x {
+		res[len(x)-1-i] = &jsonVersion{Ver: x[i].Ver, Time: x[i].Time, User: x[i].User, Req: x[i].Req}
+	}
+
+	return res, nil
+}
+
+// getVersion answers with entity as of version or time, null if it was
+// missing.
+func getVersion(h *ctxHelper) (interface{}, error) {
+	v, k, err := makeVersionQuery(h)
+	if err != nil {
+		return nil, err
+	}
+
+	c := h.getConn()
+	defer h.delConn(c)
+
+	x, err := loadVersion(c, v.Kind, v.ID, v.Ver, v.Time)
+	if err == errNoVersion {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := &jsonVersionData{Ver: x.Ver, User: x.User}
+	if x.Ver != 0 {
+		res.Time = &x.Time
+	}
+	if x.Hash != nil {
+		e := makeVersionHasher(k, v.ID, x)
+		if l, ok := e.(langer); ok {
+			l.lang(h.lang, v.Kind)
+		}
+		res.Data = e
+	}
+
+	return res, nil
+}
+
+// getVersionDiff answers with changes from version ver to version to.
+func getVersionDiff(h *ctxHelper) (interface{}, error) {
+	v, _, err := makeVersionQuery(h)
+	if err != nil {
+		return nil, err
+	}
+
+	c := h.getConn()
+	defer h.delConn(c)
+
+	a, err := loadVersion(c, v.Kind, v.ID, v.Ver, time.Time{})
+	if err == nil {
+		var b *jsonVersion
+		b, err = loadVersion(c, v.Kind, v.ID, v.To, time.Time{})
+		if err == nil {
+			return diffVersions(a, b), nil
+		}
+	}
+	if err == errNoVersion {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusNotFound)
+	}
+
+	return nil, err
+}
+
+// setVersion rolls entity back to version, the write goes through the usual
+// set or del, so search indexes, links and sync sets follow it.
+func setVersion(h *ctxHelper) (interface{}, error) {
+	v, k, err := makeVersionQuery(h)
+	if err == nil && v.Ver == 0 {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		err = fmt.Errorf("version is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := h.getConn()
+	x, err := loadVersion(c, v.Kind, v.ID, v.Ver, time.Time{})
+	h.delConn(c)
+	if err == errNoVersion {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	w := h.clone()
+	f := k.del
+	if x.Hash == nil {
+		w.data = int64sToJSON([]int64{v.ID})
+	} else {
+		w.data, err = json.Marshal([]hasher{makeVersionHasher(k, v.ID, x)})
+		if err != nil {
+			return nil, err
+		}
+		w.replace = true
+		w.patch = false
+		f = k.set
+	}
+
+	res, err := f(w, v.Kind)
+	h.ctx = w.ctx
+	return res, err
+}
+
+var errNoVersion = fmt.Errorf("version not found")
+
+func makeVersionQuery(h *ctxHelper) (*jsonVersionQuery, versionKind, error) {
+	var v jsonVersionQuery
+	err := json.Unmarshal(h.data, &v)
+	k, ok := versionKinds[v.Kind]
+	if err == nil && !ok {
+		err = fmt.Errorf("unknown kind %q", v.Kind)
+	}
+	if err == nil && v.ID == 0 {
+		err = fmt.Errorf("id is required")
+	}
+	if err != nil {
+		h.ctx = ctxutil.WithCode(h.ctx, http.StatusBadRequest)
+		return nil, k, err
+	}
+	return &v, k, nil
+}
+
+func makeVersionHasher(k versionKind, id int64, x *jsonVersion) hasher {
+	e := k.make(id)
+	f := e.getFields(false)
+	v := make([]interface{}, len(f))
+	for i := range f {
+		// revision is given by the write
+		if redisString(f[i]) == fieldRev {
+			continue
+		}
+		if s, ok := x.Hash[redisString(f[i])]; ok {
+			v[i] = []byte(s)
+		}
+	}
+	e.setValues(false, v...)
+	if r, ok := e.(restorer); ok {
+		r.restore(x.Link)
+	}
+	return e
+}
+
+// loadVersions returns versions of entity from the oldest.
+func loadVersions(c redis.Conn, p string, id int64) ([]*jsonVersion, error) {
+	v, err := redis.ByteSlices(c.Do("ZRANGE", versionKey(p, id), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*jsonVersion, len(v))
+	for i := range v {
+		res[i] = &jsonVersion{}
+		err = json.Unmarshal(v[i], res[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// loadVersion returns version ver or, if ver is zero, the state at time t,
+// the current state if t is zero too.
+func loadVersion(c redis.Conn, p string, id, ver int64, t time.Time) (*jsonVersion, error) {
+	if ver != 0 || !t.IsZero() {
+		v, err := loadVersions(c, p, id)
+		if err != nil {
+			return nil, err
+		}
+		for i := range v {
+			if (ver != 0 && v[i].Ver == ver) || (ver == 0 && v[i].Time.After(t)) {
+				return v[i], nil
+			}
+		}
+		if ver != 0 {
+			return nil, errNoVersion
+		}
+	}
+
+	v, err := loadSnapshots(c, p, []int64{id})
+	if err != nil {
+		return nil, err
+	}
+	v[0].Ver = 0
+
+	return v[0], nil
+}
+
+func diffVersions(a, b *jsonVersion) *jsonVersionDiff {
+	res := &jsonVersionDiff{
+		Diff: make(map[string][2]*string),
+		Link: make(map[string]jsonLinkDiff),
+	}
+
+	for k, x := range a.Hash {
+		x := x
+		if y, ok := b.Hash[k]; !ok {
+			res.Diff[k] = [2]*string{&x, nil}
+		} else if x != y {
+			res.Diff[k] = [2]*string{&x, &y}
+		}
+	}
+	for k, y := range b.Hash {
+		y := y
+		if _, ok := a.Hash[k]; !ok {
+			res.Diff[k] = [2]*string{nil, &y}
+		}
+	}
+
+	for _, l := range specLinks {
+		d := jsonLinkDiff{
+			Add: diffInt64s(b.Link[l], a.Link[l]),
+			Del: diffInt64s(a.Link[l], b.Link[l]),
+		}
+		if len(d.Add) > 0 || len(d.Del) > 0 {
+			res.Link[l] = d
+		}
+	}
+
+	return res
+}
+
+// diffInt64s returns values of a missing in b.
+func diffInt64s(a, b []int64) []int64 {
+	m := make(map[int64]bool, len(b))
+	for _, x := range b {
+		m[x] = true
+	}
+	var res []int64
+	for _, x := range a {
+		if !m[x] {
+			res = append(res, x)
+		}
+	}
+	return res
+}
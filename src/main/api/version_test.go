@@ -0,0 +1,33 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestVersionBatch writes the same spec twice in one set-spec-inf, the
+// version between the writes must keep links of the first one, so set-version
+// rolls back to it.
+func TestVersionBatch(t *testing.T) {
+	a := newTestAPI(t)
+	a.ok(nil, "/set-inn", `[{"id":1,"name_ru":"Парацетамол"},{"id":2,"name_ru":"Ибупрофен"},{"id":3,"name_ru":"Аспирин"}]`)
+	a.ok(nil, "/set-spec-inf", `[{"id":100,"name_ru":"Панадол","id_inn":[1],"is_info":1}]`)
+	a.ok(nil, "/set-spec-inf", `[{"id":100,"name_ru":"Нурофен","id_inn":[2],"is_info":1},{"id":100,"name_ru":"Аспирин","id_inn":[3],"is_info":1}]`)
+
+	var v struct {
+		Ver  int64    `json:"ver"`
+		Data jsonSpec `json:"data"`
+	}
+	a.ok(&v, "/get-version", `{"kind":"spec:inf","id":100,"ver":3}`)
+	if v.Data.Name != "Нурофен" || !reflect.DeepEqual(v.Data.IDINN, []int64{2}) {
+		t.Fatalf("get-version: %+v", v)
+	}
+
+	a.ok(nil, "/set-version", `{"kind":"spec:inf","id":100,"ver":3}`)
+	var s jsonSpecs
+	a.ok(&s, "/get-spec-inf", `[100]`)
+	if len(s) != 1 || s[0].Name != "Нурофен" || !reflect.DeepEqual(s[0].IDINN, []int64{2}) {
+		t.Fatalf("get-spec-inf: %+v", s)
+	}
+	a.fsck()
+}
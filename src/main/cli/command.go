@@ -4,11 +4,16 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"internal/logger"
+	"internal/redismem"
+	"internal/redispool"
 
+	"github.com/garyburd/redigo/redis"
 	"github.com/google/subcommands"
 )
 
@@ -19,6 +24,9 @@ type baseCommand struct {
 	brief string
 	usage string
 	log   logger.Logger
+
+	// closers are closed after the command
+	closers []io.Closer
 }
 
 func (c *baseCommand) appName() string {
@@ -76,6 +84,12 @@ func (c *baseCommand) Execute(ctx context.Context, f *flag.FlagSet, args ...inte
 		}
 	}
 
+	for i := len(c.closers) - 1; i >= 0; i-- {
+		if e := c.closers[i].Close(); err == nil {
+			err = e
+		}
+	}
+
 	if err != nil {
 		c.log.Printf("%v", err)
 		return subcommands.ExitFailure
@@ -83,3 +97,32 @@ func (c *baseCommand) Execute(ctx context.Context, f *flag.FlagSet, args ...inte
 
 	return subcommands.ExitSuccess
 }
+
+// rediser is common interface for Redis pool and in-memory store.
+type rediser interface {
+	Get() redis.Conn
+	Close() error
+}
+
+// newRediser returns in-memory store for mem:// address or Redis pool, it
+// is closed after the command. In-memory store of mem://<file> is loaded
+// from the file and saved back, so commands run one by one share it.
+func (c *baseCommand) newRediser(addr string, options ...func(*redispool.Pool) error) (rediser, error) {
+	var r rediser
+	var err error
+	if strings.HasPrefix(addr, "mem://") {
+		if path := strings.TrimPrefix(addr, "mem://"); path != "" {
+			r, err = redismem.Open(path)
+		} else {
+			r = redismem.New()
+		}
+	} else {
+		r, err = redispool.New(append([]func(*redispool.Pool) error{redispool.Address(addr)}, options...)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.closers = append(c.closers, r)
+	return r, nil
+}
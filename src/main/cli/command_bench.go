@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"internal/router"
+
+	"main/api"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/google/subcommands"
+)
+
+func init() {
+	subcommands.Register(newBenchCommand(), "")
+}
+
+type benchCommand struct {
+	baseCommand
+	flag struct {
+		redis string
+		ns    string
+		specs int
+		links int
+	}
+}
+
+func newBenchCommand() subcommands.Command {
+	c := &benchCommand{
+		baseCommand: baseCommand{
+			name:  "bench",
+			brief: "count Redis round trips of spec reads",
+			usage: "Fill namespace with generated specs and print Redis round trips and time of get-spec-inf endpoints",
+		},
+	}
+	c.base = c
+	return c
+}
+
+func (c *benchCommand) setFlags(f *flag.FlagSet) {
+	f.StringVar(&c.flag.redis,
+		"redis",
+		"mem://",
+		"Redis server address, mem:// for in-memory store",
+	)
+	f.StringVar(&c.flag.ns,
+		"namespace",
+		"bench",
+		"Prefix for all Redis keys, generated entities are written there",
+	)
+	f.IntVar(&c.flag.specs,
+		"specs",
+		200,
+		"Number of specs",
+	)
+	f.IntVar(&c.flag.links,
+		"links",
+		3,
+		"Number of links of each kind per spec",
+	)
+}
+
+func (c *benchCommand) execute(ctx context.Context, _ *flag.FlagSet, _ ...interface{}) error {
+	r, err := c.newRediser(c.flag.redis)
+	if err != nil {
+		return err
+	}
+
+	err = api.Import(r, c.flag.ns, "", c.fill())
+	if err != nil {
+		return err
+	}
+
+	n := new(int64)
+	h, err := api.NewWithRouter(
+		router.NewMuxVestigo(ctx),
+		api.Redis(countRediser{r, n}),
+		api.Namespace(c.flag.ns),
+		api.Logger(c.log),
+	)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, c.flag.specs)
+	for i := range ids {
+		ids[i] = int64(i + 1)
+	}
+	body, _ := json.Marshal(ids)
+
+	for _, path := range []string{
+		"/get-spec-inf",
+		"/get-spec-inf-with-deps",
+		"/get-spec-inf-list-by-id-inn",
+	} {
+		b := body
+		if path == "/get-spec-inf-list-by-id-inn" {
+			b = []byte("1")
+		}
+
+		atomic.StoreInt64(n, 0)
+		t := time.Now()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", path, bytes.NewReader(b))
+		r.Header.Set("Accept-Language", "ru")
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			return fmt.Errorf("%s: %d %s", path, w.Code, w.Body.String())
+		}
+
+		_, err = fmt.Printf("%s\t%d\t%s\n", path, atomic.LoadInt64(n), time.Since(t))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fill returns snapshot of generated catalog, every spec is linked to a few
+// entities of each kind.
+func (c *benchCommand) fill() *bytes.Buffer {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	line := func(kind string, v interface{}) {
+		b, _ := json.Marshal(v)
+		_ = enc.Encode(map[string]interface{}{"kind": kind, "data": json.RawMessage(b)})
+	}
+
+	k := c.flag.links * 10
+	for i := 1; i <= k; i++ {
+		name := fmt.Sprintf("Name %d", i)
+		line("class:atc", map[string]interface{}{"id": i, "code": fmt.Sprint(i), "slug": fmt.Sprint("c", i), "name_ru": name})
+		line("inn", map[string]interface{}{"id": i, "name_ru": name})
+		line("maker", map[string]interface{}{"id": i, "name_ru": name})
+		line("drug", map[string]interface{}{"id": i, "name_ru": name})
+	}
+
+	for i := 1; i <= c.flag.specs; i++ {
+		links := make([]int, c.flag.links)
+		for j := range links {
+			links[j] = (i+j)%k + 1
+		}
+		name := fmt.Sprintf("Spec %d", i)
+		line("spec:inf", map[string]interface{}{
+			"id":           i,
+			"name_ru":      name,
+			"name_ru_src":  name,
+			"is_info":      1,
+			"id_inn":       links,
+			"id_make":      links,
+			"id_drug":      links,
+			"id_class_atc": links,
+		})
+	}
+
+	return &buf
+}
+
+// countRediser counts round trips to Redis: calls of Do and Flush.
+type countRediser struct {
+	r rediser
+	n *int64
+}
+
+func (r countRediser) Get() redis.Conn {
+	return countConn{r.r.Get(), r.n}
+}
+
+type countConn struct {
+	redis.Conn
+	n *int64
+}
+
+func (c countConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	atomic.AddInt64(c.n, 1)
+	return c.Conn.Do(cmd, args...)
+}
+
+func (c countConn) Flush() error {
+	atomic.AddInt64(c.n, 1)
+	return c.Conn.Flush()
+}
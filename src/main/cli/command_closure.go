@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"main/api"
+
+	"github.com/google/subcommands"
+)
+
+func init() {
+	subcommands.Register(newClosureCommand(), "")
+}
+
+type closureCommand struct {
+	baseCommand
+	flag struct {
+		redis   string
+		ns      string
+		release string
+		prefix  string
+	}
+}
+
+func newClosureCommand() subcommands.Command {
+	c := &closureCommand{
+		baseCommand: baseCommand{
+			name:  "closure",
+			brief: "rebuild class closure",
+			usage: "Rebuild deep sets of classes, which list specs linked to class subtrees",
+		},
+	}
+	c.base = c
+	return c
+}
+
+func (c *closureCommand) setFlags(f *flag.FlagSet) {
+	f.StringVar(&c.flag.redis,
+		"redis",
+		"redis://localhost:6379",
+		"Redis server address",
+	)
+	f.StringVar(&c.flag.ns,
+		"namespace",
+		"",
+		"Prefix for all Redis keys",
+	)
+	f.StringVar(&c.flag.release,
+		"release",
+		"",
+		"Release to rebuild, the live one if empty",
+	)
+	f.StringVar(&c.flag.prefix,
+		"prefix",
+		"",
+		"Comma separated class prefixes to rebuild, all if empty",
+	)
+}
+
+func (c *closureCommand) execute(_ context.Context, _ *flag.FlagSet, _ ...interface{}) error {
+	r, err := c.newRediser(c.flag.redis)
+	if err != nil {
+		return err
+	}
+
+	var prefixes []string
+	for _, p := range strings.Split(c.flag.prefix, ",") {
+		if p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+
+	return api.RebuildClosure(r, c.flag.ns, c.flag.release, prefixes, func(p string, n int) error {
+		_, err := fmt.Printf("%s\t%d\n", p, n)
+		return err
+	})
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"main/api"
+
+	"github.com/google/subcommands"
+)
+
+func init() {
+	subcommands.Register(newCompactCommand(), "")
+}
+
+type compactCommand struct {
+	baseCommand
+	flag struct {
+		redis     string
+		ns        string
+		release   string
+		retention time.Duration
+		audit     time.Duration
+		trash     time.Duration
+	}
+}
+
+func newCompactCommand() subcommands.Command {
+	c := &compactCommand{
+		baseCommand: baseCommand{
+			name:  "compact",
+			brief: "compact sync sets, audit log and trash",
+			usage: "Remove tombstones older than retention from sync sets, old entries from audit log and old deleted entities from trash",
+		},
+	}
+	c.base = c
+	return c
+}
+
+func (c *compactCommand) setFlags(f *flag.FlagSet) {
+	f.StringVar(&c.flag.redis,
+		"redis",
+		"redis://localhost:6379",
+		"Redis server address",
+	)
+	f.StringVar(&c.flag.ns,
+		"namespace",
+		"",
+		"Prefix for all Redis keys",
+	)
+	f.StringVar(&c.flag.release,
+		"release",
+		"",
+		"Release to compact, the live one if empty",
+	)
+	f.DurationVar(&c.flag.retention,
+		"retention",
+		30*24*time.Hour,
+		"Retention window for tombstones",
+	)
+	f.DurationVar(&c.flag.audit,
+		"audit",
+		0,
+		"Retention window for audit log, it is kept if zero",
+	)
+	f.DurationVar(&c.flag.trash,
+		"trash",
+		0,
+		"Retention window for deleted entities, trash is kept if zero",
+	)
+}
+
+func (c *compactCommand) execute(_ context.Context, _ *flag.FlagSet, _ ...interface{}) error {
+	r, err := c.newRediser(c.flag.redis)
+	if err != nil {
+		return err
+	}
+
+	err = api.CompactSync(r, c.flag.ns, c.flag.release, c.flag.retention, func(p string, n int) error {
+		_, err := fmt.Printf("%s\t%d\n", p, n)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.flag.audit > 0 {
+		n, err := api.CompactAudit(r, c.flag.ns, c.flag.release, c.flag.audit)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Printf("audit\t%d\n", n)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.flag.trash <= 0 {
+		return nil
+	}
+
+	return api.PurgeTrash(r, c.flag.ns, c.flag.release, c.flag.trash, func(p string, n int) error {
+		_, err := fmt.Printf("trash %s\t%d\n", p, n)
+		return err
+	})
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+
+	"internal/gzippool"
+
+	"main/api"
+
+	"github.com/google/subcommands"
+	"github.com/klauspost/compress/gzip"
+)
+
+func init() {
+	subcommands.Register(newExportCommand(), "")
+}
+
+type exportCommand struct {
+	baseCommand
+	flag struct {
+		redis   string
+		ns      string
+		release string
+		output  string
+		gzip    bool
+	}
+}
+
+func newExportCommand() subcommands.Command {
+	c := &exportCommand{
+		baseCommand: baseCommand{
+			name:  "export",
+			brief: "export catalog",
+			usage: "Export catalog snapshot as JSON Lines",
+		},
+	}
+	c.base = c
+	return c
+}
+
+func (c *exportCommand) setFlags(f *flag.FlagSet) {
+	f.StringVar(&c.flag.redis,
+		"redis",
+		"redis://localhost:6379",
+		"Redis server address",
+	)
+	f.StringVar(&c.flag.ns,
+		"namespace",
+		"",
+		"Prefix for all Redis keys",
+	)
+	f.StringVar(&c.flag.release,
+		"release",
+		"",
+		"Release to export, the live one if empty",
+	)
+	f.StringVar(&c.flag.output,
+		"o",
+		"",
+		"Output file, stdout if empty",
+	)
+	f.BoolVar(&c.flag.gzip,
+		"gzip",
+		false,
+		"Compress output with gzip",
+	)
+}
+
+func (c *exportCommand) execute(_ context.Context, _ *flag.FlagSet, _ ...interface{}) error {
+	r, err := c.newRediser(c.flag.redis)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if c.flag.output != "" {
+		f, err = os.Create(c.flag.output)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+
+	var z *gzip.Writer
+	if c.flag.gzip {
+		z = gzippool.GetWriter()
+		z.Reset(w)
+		w = z
+	}
+
+	err = api.Export(r, c.flag.ns, c.flag.release, w)
+
+	// gzip writer flushes its footer to the file, so it is closed first and
+	// errors of both closes are reported, the output is incomplete otherwise
+	if z != nil {
+		if e := z.Close(); err == nil {
+			err = e
+		}
+		gzippool.PutWriter(z)
+	}
+	if f != nil {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"main/api"
+
+	"github.com/google/subcommands"
+)
+
+func init() {
+	subcommands.Register(newFsckCommand(), "")
+}
+
+type fsckCommand struct {
+	baseCommand
+	flag struct {
+		redis   string
+		ns      string
+		release string
+		repair  bool
+	}
+}
+
+func newFsckCommand() subcommands.Command {
+	c := &fsckCommand{
+		baseCommand: baseCommand{
+			name:  "fsck",
+			brief: "check catalog integrity",
+			usage: "Check catalog integrity and print problems as JSON Lines",
+		},
+	}
+	c.base = c
+	return c
+}
+
+func (c *fsckCommand) setFlags(f *flag.FlagSet) {
+	f.StringVar(&c.flag.redis,
+		"redis",
+		"redis://localhost:6379",
+		"Redis server address",
+	)
+	f.StringVar(&c.flag.ns,
+		"namespace",
+		"",
+		"Prefix for all Redis keys",
+	)
+	f.StringVar(&c.flag.release,
+		"release",
+		"",
+		"Release to check, the live one if empty",
+	)
+	f.BoolVar(&c.flag.repair,
+		"repair",
+		false,
+		"Repair found problems",
+	)
+}
+
+func (c *fsckCommand) execute(_ context.Context, _ *flag.FlagSet, _ ...interface{}) error {
+	r, err := c.newRediser(c.flag.redis)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	n, err := api.Fsck(r, c.flag.ns, c.flag.release, c.flag.repair, func(p api.Problem) error {
+		return enc.Encode(p)
+	})
+	if err != nil {
+		return err
+	}
+
+	if n > 0 && !c.flag.repair {
+		return fmt.Errorf("fsck: %d problems found", n)
+	}
+
+	return nil
+}
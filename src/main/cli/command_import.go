@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"io"
+	"os"
+
+	"internal/gzippool"
+
+	"main/api"
+
+	"github.com/google/subcommands"
+)
+
+func init() {
+	subcommands.Register(newImportCommand(), "")
+}
+
+type importCommand struct {
+	baseCommand
+	flag struct {
+		redis   string
+		ns      string
+		release string
+		input   string
+	}
+}
+
+func newImportCommand() subcommands.Command {
+	c := &importCommand{
+		baseCommand: baseCommand{
+			name:  "import",
+			brief: "import catalog",
+			usage: "Import catalog snapshot from JSON Lines, gzip is detected automatically",
+		},
+	}
+	c.base = c
+	return c
+}
+
+func (c *importCommand) setFlags(f *flag.FlagSet) {
+	f.StringVar(&c.flag.redis,
+		"redis",
+		"redis://localhost:6379",
+		"Redis server address",
+	)
+	f.StringVar(&c.flag.ns,
+		"namespace",
+		"",
+		"Prefix for all Redis keys",
+	)
+	f.StringVar(&c.flag.release,
+		"release",
+		"",
+		"Release to import into, the live one if empty",
+	)
+	f.StringVar(&c.flag.input,
+		"i",
+		"",
+		"Input file, stdin if empty",
+	)
+}
+
+func (c *importCommand) execute(_ context.Context, _ *flag.FlagSet, _ ...interface{}) error {
+	r, err := c.newRediser(c.flag.redis)
+	if err != nil {
+		return err
+	}
+
+	var rd io.Reader = os.Stdin
+	if c.flag.input != "" {
+		f, err := os.Open(c.flag.input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rd = f
+	}
+
+	br := bufio.NewReader(rd)
+	rd = br
+	if b, _ := br.Peek(2); len(b) == 2 && b[0] == 0x1f && b[1] == 0x8b {
+		z := gzippool.GetReader()
+		err = z.Reset(br)
+		if err != nil {
+			return err
+		}
+		defer gzippool.PutReader(z)
+		rd = z
+	}
+
+	return api.Import(r, c.flag.ns, c.flag.release, rd)
+}
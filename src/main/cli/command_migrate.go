@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"main/api"
+
+	"github.com/google/subcommands"
+)
+
+func init() {
+	subcommands.Register(newMigrateCommand(), "")
+}
+
+type migrateCommand struct {
+	baseCommand
+	flag struct {
+		redis   string
+		ns      string
+		release string
+		to      int
+	}
+}
+
+func newMigrateCommand() subcommands.Command {
+	c := &migrateCommand{
+		baseCommand: baseCommand{
+			name:  "migrate",
+			brief: "migrate data schema",
+			usage: "Migrate data schema: up | down | status",
+		},
+	}
+	c.base = c
+	return c
+}
+
+func (c *migrateCommand) setFlags(f *flag.FlagSet) {
+	f.StringVar(&c.flag.redis,
+		"redis",
+		"redis://localhost:6379",
+		"Redis server address",
+	)
+	f.StringVar(&c.flag.ns,
+		"namespace",
+		"",
+		"Prefix for all Redis keys",
+	)
+	f.StringVar(&c.flag.release,
+		"release",
+		"",
+		"Release to migrate, the live one if empty",
+	)
+	f.IntVar(&c.flag.to,
+		"to",
+		-1,
+		"Target version, the latest for up and the previous for down by default",
+	)
+}
+
+func (c *migrateCommand) execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) error {
+	args := f.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("migrate: missing action, see %s help %s", c.appName(), c.name)
+	}
+
+	r, err := c.newRediser(c.flag.redis)
+	if err != nil {
+		return err
+	}
+
+	v, m, err := api.MigrateStatus(r, c.flag.ns, c.flag.release)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		to := c.flag.to
+		if to < 0 {
+			to = 0
+		}
+		err = api.MigrateUp(r, c.flag.ns, c.flag.release, to)
+	case "down":
+		to := c.flag.to
+		if to < 0 {
+			to = 0
+			for i := range m {
+				if m[i].Version < v {
+					to = m[i].Version
+				}
+			}
+		}
+		err = api.MigrateDown(r, c.flag.ns, c.flag.release, to)
+	case "status":
+		for i := range m {
+			mark := " "
+			if m[i].Version <= v {
+				mark = "*"
+			}
+			fmt.Printf("%s %d\t%s\n", mark, m[i].Version, m[i].Name)
+		}
+		fmt.Printf("version %d\n", v)
+		return nil
+	default:
+		return fmt.Errorf("migrate: unknown action %s", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	v, _, err = api.MigrateStatus(r, c.flag.ns, c.flag.release)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("version %d\n", v)
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"main/api"
+
+	"github.com/google/subcommands"
+)
+
+func init() {
+	subcommands.Register(newReleaseCommand(), "")
+}
+
+type releaseCommand struct {
+	baseCommand
+	flag struct {
+		redis string
+		ns    string
+		empty bool
+	}
+}
+
+func newReleaseCommand() subcommands.Command {
+	c := &releaseCommand{
+		baseCommand: baseCommand{
+			name:  "release",
+			brief: "manage catalog releases",
+			usage: "Manage catalog releases: open <name> | publish <name> | rollback | list",
+		},
+	}
+	c.base = c
+	return c
+}
+
+func (c *releaseCommand) setFlags(f *flag.FlagSet) {
+	f.StringVar(&c.flag.redis,
+		"redis",
+		"redis://localhost:6379",
+		"Redis server address",
+	)
+	f.StringVar(&c.flag.ns,
+		"namespace",
+		"",
+		"Prefix for all Redis keys",
+	)
+	f.BoolVar(&c.flag.empty,
+		"empty",
+		false,
+		"Open empty release instead of copy of the live one",
+	)
+}
+
+func (c *releaseCommand) execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) error {
+	args := f.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("release: missing action, see %s help %s", c.appName(), c.name)
+	}
+
+	r, err := c.newRediser(c.flag.redis)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "open":
+		if len(args) != 2 {
+			return fmt.Errorf("release open: missing name")
+		}
+		err = api.OpenRelease(r, c.flag.ns, args[1], c.flag.empty)
+	case "publish":
+		if len(args) != 2 {
+			return fmt.Errorf("release publish: missing name")
+		}
+		err = api.PublishRelease(r, c.flag.ns, args[1])
+	case "rollback":
+		err = api.RollbackRelease(r, c.flag.ns)
+	case "list":
+		var v []api.Release
+		v, err = api.ListReleases(r, c.flag.ns)
+		for i := range v {
+			mark := " "
+			if v[i].Live {
+				mark = "*"
+			} else if v[i].Prev {
+				mark = "-"
+			}
+			fmt.Printf("%s %s\t%s\n", mark, v[i].Name, v[i].Created.Format("2006-01-02 15:04:05"))
+		}
+	default:
+		return fmt.Errorf("release: unknown action %s", args[0])
+	}
+
+	return err
+}
@@ -3,6 +3,7 @@ package cli
 import (
 	"context"
 	"flag"
+	"strings"
 	"time"
 
 	"internal/redispool"
@@ -23,6 +24,13 @@ type serverCommand struct {
 	flag struct {
 		addr    string
 		redis   string
+		replica string
+		ns      string
+		retain  time.Duration
+		audit   time.Duration
+		trash   time.Duration
+		hooks   bool
+		admin   string
 		secret  string
 		maxIdle int
 		timeout time.Duration
@@ -50,7 +58,42 @@ func (c *serverCommand) setFlags(f *flag.FlagSet) {
 	f.StringVar(&c.flag.redis,
 		"redis",
 		"redis://localhost:6379",
-		"Redis server address",
+		"Redis server address, redis+sentinel://host1,host2/master for Sentinel, mem:// or mem://<file> for in-memory store",
+	)
+	f.StringVar(&c.flag.replica,
+		"replica",
+		"",
+		"Comma separated Redis replica addresses for get-* endpoints",
+	)
+	f.StringVar(&c.flag.ns,
+		"namespace",
+		"",
+		"Prefix for all Redis keys",
+	)
+	f.DurationVar(&c.flag.retain,
+		"retention",
+		0,
+		"Retention window for tombstones of sync sets, compaction is off if zero",
+	)
+	f.DurationVar(&c.flag.audit,
+		"audit-retention",
+		0,
+		"Retention window for audit log, it is kept if zero",
+	)
+	f.DurationVar(&c.flag.trash,
+		"trash-retention",
+		0,
+		"Retention window for deleted entities, trash is kept if zero",
+	)
+	f.BoolVar(&c.flag.hooks,
+		"webhooks",
+		false,
+		"Deliver change events to webhook subscribers",
+	)
+	f.StringVar(&c.flag.admin,
+		"admin-token",
+		"",
+		"Bearer token of admin endpoints, they are off if empty",
 	)
 	f.StringVar(&c.flag.secret,
 		"secret",
@@ -70,25 +113,55 @@ func (c *serverCommand) setFlags(f *flag.FlagSet) {
 }
 
 func (c *serverCommand) execute(ctx context.Context, _ *flag.FlagSet, _ ...interface{}) error {
-	r, err := redispool.New(
-		redispool.Address(c.flag.redis),
-		redispool.MaxIdle(c.flag.maxIdle),
-		redispool.IdleTimeout(c.flag.timeout),
-	)
+	r, err := c.newRediser(c.flag.redis)
 	if err != nil {
 		return err
 	}
 
+	var rr []redispool.Getter
+	for _, a := range strings.Split(c.flag.replica, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		x, err := c.newRediser(a)
+		if err != nil {
+			return err
+		}
+		rr = append(rr, x)
+	}
+
+	// subscribers of change feed hold connections for long, so they get
+	// their own pool, the in-memory store is shared
+	rs := r
+	if !strings.HasPrefix(c.flag.redis, "mem://") {
+		rs, err = c.newRediser(c.flag.redis)
+		if err != nil {
+			return err
+		}
+	}
+
 	// ctx will be passed to http handlers via request
 	h, err := api.NewWithRouter(
 		router.NewMuxVestigo(ctx),
 		api.Redis(r),
+		api.Replicas(redispool.NewBalancer(r, rr...)),
+		api.Streams(rs),
+		api.Namespace(c.flag.ns),
+		api.AdminToken(c.flag.admin),
 		api.Logger(c.log),
 	)
 	if err != nil {
 		return err
 	}
 
+	if c.flag.retain > 0 || c.flag.audit > 0 || c.flag.trash > 0 {
+		go c.compact(ctx, r)
+	}
+
+	if c.flag.hooks {
+		go c.runHooks(ctx, r)
+	}
+
 	// ctx will be passed to shutdown func
 	s, err := server.NewWithContext(
 		ctx,
@@ -103,3 +176,67 @@ func (c *serverCommand) execute(ctx context.Context, _ *flag.FlagSet, _ ...inter
 
 	return s.Start()
 }
+
+func (c *serverCommand) newRediser(addr string) (rediser, error) {
+	return c.baseCommand.newRediser(addr,
+		redispool.MaxIdle(c.flag.maxIdle),
+		redispool.IdleTimeout(c.flag.timeout),
+	)
+}
+
+// compact removes old tombstones, audit entries and trash of the live
+// release every hour.
+func (c *serverCommand) compact(ctx context.Context, r rediser) {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+
+	for {
+		if c.flag.retain > 0 {
+			err := api.CompactSync(r, c.flag.ns, "", c.flag.retain, func(p string, n int) error {
+				if n > 0 {
+					c.log.Printf("compact %s: %d tombstones removed", p, n)
+				}
+				return nil
+			})
+			if err != nil {
+				c.log.Printf("compact: %v", err)
+			}
+		}
+
+		if c.flag.audit > 0 {
+			n, err := api.CompactAudit(r, c.flag.ns, "", c.flag.audit)
+			if n > 0 {
+				c.log.Printf("compact audit: %d entries removed", n)
+			}
+			if err != nil {
+				c.log.Printf("compact audit: %v", err)
+			}
+		}
+
+		if c.flag.trash > 0 {
+			err := api.PurgeTrash(r, c.flag.ns, "", c.flag.trash, func(p string, n int) error {
+				if n > 0 {
+					c.log.Printf("purge %s: %d entities removed", p, n)
+				}
+				return nil
+			})
+			if err != nil {
+				c.log.Printf("purge: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// runHooks delivers change events to webhook subscribers.
+func (c *serverCommand) runHooks(ctx context.Context, r rediser) {
+	err := api.RunHooks(ctx, r, c.flag.ns, api.HookLogger(c.log))
+	if err != nil {
+		c.log.Printf("hook: %v", err)
+	}
+}